@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/markdaws/gohome/pkg/gohome"
 	"github.com/markdaws/gohome/pkg/log"
@@ -34,6 +36,54 @@ func main() {
 		false,
 		"Set the password for a user. Creates a user if the login is not found, you must specify the location to the goHOME config file. e.g. ghadmin --config=./myconfig.json --set-password guest password12345")
 
+	role := flag.String(
+		"role",
+		"",
+		"The role of the user when used with --set-password, one of admin, member or guest. New users are admins if no role is specified. e.g. ghadmin --config=./myconfig.json --set-password --role=guest babysitter password12345")
+
+	backup := flag.Bool(
+		"backup",
+		false,
+		"Backs up the system file, config, key file and automation scripts to a .tar.gz file, you must specify the location to the goHOME config file. e.g. ghadmin --config=./config.json --backup ./gohome-backup.tar.gz")
+
+	restore := flag.Bool(
+		"restore",
+		false,
+		"Restores a backup created with --backup, replacing the current system. If the config file does not exist, the config in the backup is restored to that location. Stop the goHOME server before restoring. e.g. ghadmin --config=./config.json --restore ./gohome-backup.tar.gz")
+
+	exportYAML := flag.Bool(
+		"export-yaml",
+		false,
+		"Exports the system configuration as YAML, which is easier to read, edit and diff than the system file. Secrets and users are not exported. Writes to stdout if no path is given. e.g. ghadmin --config=./config.json --export-yaml ./gohome.yaml")
+
+	importYAML := flag.Bool(
+		"import-yaml",
+		false,
+		"Merges a YAML file in the format written by --export-yaml into the system, the file can contain just the parts you want to change, such as a few scenes. Stop the goHOME server before importing. e.g. ghadmin --config=./config.json --import-yaml ./scenes.yaml")
+
+	createToken := flag.Bool(
+		"create-token",
+		false,
+		"Creates a named API token for a user and prints it, scripts send the token in an Authorization: Bearer header. Use --role to limit the token to a lower role than the user. Stop the goHOME server before creating tokens. e.g. ghadmin --config=./config.json --create-token --role=member mark \"kitchen dashboard\"")
+
+	revokeToken := flag.Bool(
+		"revoke-token",
+		false,
+		"Revokes a users API token, specified by name. Stop the goHOME server before revoking tokens. e.g. ghadmin --config=./config.json --revoke-token mark \"kitchen dashboard\"")
+
+	listTokens := flag.Bool(
+		"list-tokens",
+		false,
+		"Lists the API tokens of a user and when they were last used. e.g. ghadmin --config=./config.json --list-tokens mark")
+
+	disable2FA := flag.Bool(
+		"disable-2fa",
+		false,
+		"Turns off two factor authentication for a user who has lost their authenticator app and recovery codes, they can enrol again after logging in. Stop the goHOME server before running. e.g. ghadmin --config=./config.json --disable-2fa mark")
+
+	includeEvents := flag.Bool("include-events", false, "Include the event log in the backup")
+	includeHistory := flag.Bool("include-history", false, "Include the attribute history in the backup")
+
 	configPath := flag.String("config", "", "Specifies the path and file name to the goHOME config file")
 
 	flag.Parse()
@@ -69,7 +119,68 @@ func main() {
 			os.Exit(1)
 		}
 
-		setPass(flag.Arg(0), flag.Arg(1), *configPath)
+		setPass(flag.Arg(0), flag.Arg(1), *role, *configPath)
+		return
+	}
+
+	if *backup || *restore {
+		if configPath == nil || *configPath == "" {
+			fmt.Print("The config option must be specified when backing up or restoring\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		if *backup {
+			backupSystem(flag.Arg(0), *configPath, store.BackupOptions{
+				IncludeEvents:  *includeEvents,
+				IncludeHistory: *includeHistory,
+			})
+		} else {
+			restoreSystem(flag.Arg(0), *configPath)
+		}
+		return
+	}
+
+	if *exportYAML || *importYAML {
+		if configPath == nil || *configPath == "" {
+			fmt.Print("The config option must be specified when exporting or importing YAML\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		if *exportYAML {
+			exportSystemYAML(flag.Arg(0), *configPath)
+		} else {
+			importSystemYAML(flag.Arg(0), *configPath)
+		}
+		return
+	}
+
+	if *createToken || *revokeToken || *listTokens {
+		if configPath == nil || *configPath == "" {
+			fmt.Print("The config option must be specified when managing API tokens\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		if *createToken {
+			createAPIToken(flag.Arg(0), flag.Arg(1), *role, *configPath)
+		} else if *revokeToken {
+			revokeAPIToken(flag.Arg(0), flag.Arg(1), *configPath)
+		} else {
+			listAPITokens(flag.Arg(0), *configPath)
+		}
+		return
+	}
+
+	if *disable2FA {
+		if configPath == nil || *configPath == "" {
+			fmt.Print("The config option must be specified when disabling two factor authentication\n\n")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		disableUser2FA(flag.Arg(0), *configPath)
 		return
 	}
 
@@ -94,6 +205,8 @@ func initConfig(webUIPath string) *gohome.Config {
 	}
 
 	cfg := gohome.NewDefaultConfig(currentDir, webUIPath)
+	initTLS(cfg, currentDir)
+
 	b, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		fmt.Println("Failed to JSON encode config file: ", err)
@@ -110,14 +223,67 @@ func initConfig(webUIPath string) *gohome.Config {
 	return cfg
 }
 
+// initTLS creates a self signed CA and a server certificate for the WWW server, so it uses HTTPS.
+// The certificate is valid for the detected LAN IP address and the host name of this machine
+func initTLS(cfg *gohome.Config, dir string) {
+	tlsDir := path.Join(dir, "tls")
+	if err := os.MkdirAll(tlsDir, 0700); err != nil {
+		fmt.Println("Failed to create the TLS directory: ", err)
+		os.Exit(1)
+	}
+
+	var hosts []string
+	if cfg.WWWAddr != "" {
+		hosts = append(hosts, cfg.WWWAddr)
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+		if !strings.Contains(hostname, ".") {
+			hosts = append(hosts, hostname+".local")
+		}
+	}
+	hosts = append(hosts, "localhost", "127.0.0.1")
+
+	paths := gohome.TLSCertPaths{
+		CACert: path.Join(tlsDir, "ca.crt"),
+		CAKey:  path.Join(tlsDir, "ca.key"),
+		Cert:   path.Join(tlsDir, "server.crt"),
+		Key:    path.Join(tlsDir, "server.key"),
+	}
+	if err := gohome.GenerateTLSCerts(paths, hosts, time.Now()); err != nil {
+		fmt.Println("Failed to create the TLS certificates: ", err)
+		os.Exit(1)
+	}
+
+	cfg.WWWCertPath = paths.Cert
+	cfg.WWWKeyPath = paths.Key
+	fmt.Printf("TLS certificate for %s written to: %s\n", strings.Join(hosts, ", "), paths.Cert)
+	fmt.Println("To stop browsers warning about the certificate, install this CA certificate on your devices: ", paths.CACert)
+}
+
 func initSystem(cfg *gohome.Config) {
 	if _, err := os.Stat(cfg.SystemPath); err == nil {
 		fmt.Printf("The file %s already exists, please remove and then re-run init", cfg.SystemPath)
 		os.Exit(1)
 	}
 
+	keyPath := cfg.KeyFilePath()
+	key, err := store.LoadOrCreateKeyFile(keyPath)
+	if err != nil {
+		fmt.Println("Failed to create the key file: ", err)
+		os.Exit(1)
+	}
+	store.SetEncryptionKey(key)
+	fmt.Println("Key file written to: ", keyPath)
+
+	sysStore, err := store.New(cfg)
+	if err != nil {
+		fmt.Println("Invalid store type: ", err)
+		os.Exit(1)
+	}
+
 	sys := gohome.NewSystem("My goHOME system")
-	err := store.SaveSystem(cfg.SystemPath, sys)
+	err = sysStore.SaveSystem(sys)
 	if err != nil {
 		fmt.Println("Failed to write system file to disk: ", err)
 		os.Exit(1)
@@ -126,18 +292,85 @@ func initSystem(cfg *gohome.Config) {
 	fmt.Println("System file written to: ", cfg.SystemPath)
 }
 
-func setPass(login, password, configPath string) {
+func setPass(login, password, role, configPath string) {
 	if login == "" || password == "" {
 		fmt.Println("missing values, --set-password <login> <password>")
 		os.Exit(1)
 	}
 
+	cfg := loadConfig(configPath)
+	sysStore := openStore(cfg)
+
+	log.Silent = true
+	sys := loadSystem(sysStore, cfg.SystemPath)
+	log.Silent = false
+
+	user := sys.UserByLogin(login)
+
+	addedUser := false
+	if user == nil {
+		// The first users are created with ghadmin, so they need to be able to manage the system
+		if role == "" {
+			role = gohome.RoleAdmin
+		}
+		user = &gohome.User{
+			ID:    sys.NewID(),
+			Login: login,
+		}
+		addedUser = true
+	}
+
+	if role != "" {
+		user.Role = role
+	}
+	if err := user.Validate(sys); err != nil {
+		fmt.Println("Invalid user:", err)
+		os.Exit(1)
+	}
+	if addedUser {
+		sys.AddUser(user)
+	}
+
+	err := user.SetPassword(password)
+	if err != nil {
+		fmt.Println("Failed to set the password:", err)
+		os.Exit(1)
+	}
+
+	err = sysStore.SaveUser(sys, user)
+	if err != nil {
+		fmt.Println("Failed to save the user changes to disk:" + err.Error())
+		os.Exit(1)
+	}
+
+	if addedUser {
+		fmt.Println("Successfully added user:", login, " to:", cfg.SystemPath)
+	} else {
+		fmt.Println("Successfully updated password for user:", login, "to:", cfg.SystemPath)
+	}
+}
+
+func loadSystem(sysStore store.Store, systemPath string) *gohome.System {
+	sys, err := sysStore.Load()
+	if err == store.ErrFileNotFound {
+		fmt.Println("System file not found at: ", systemPath)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Failed to load system file: ", err)
+		os.Exit(1)
+	}
+
+	return sys
+}
+
+func loadConfig(configPath string) *gohome.Config {
 	var cfg *gohome.Config
 	file, err := os.Open(configPath)
 	if err != nil {
 		fmt.Println("Error trying to open:", configPath)
 		os.Exit(1)
 	}
+	defer file.Close()
 
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&cfg)
@@ -150,63 +383,298 @@ func setPass(login, password, configPath string) {
 		fmt.Println("systemPath key/value not found in:", configPath)
 		os.Exit(1)
 	}
+	return cfg
+}
+
+func backupSystem(backupPath, configPath string, opts store.BackupOptions) {
+	if backupPath == "" {
+		fmt.Println("missing value, --backup <path/to/backup.tar.gz>")
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(backupPath); err == nil {
+		fmt.Printf("The file %s already exists, please remove and then re-run backup\n", backupPath)
+		os.Exit(1)
+	}
+
+	cfg := loadConfig(configPath)
+
+	file, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println("Failed to create the backup file:", err)
+		os.Exit(1)
+	}
+
+	manifest, err := store.CreateBackup(file, cfg, opts)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		fmt.Println("Failed to backup the system:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Backed up %d files to: %s\n", len(manifest.Files), backupPath)
+}
+
+func restoreSystem(backupPath, configPath string) {
+	if backupPath == "" {
+		fmt.Println("missing value, --restore <path/to/backup.tar.gz>")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(backupPath)
+	if err != nil {
+		fmt.Println("Error trying to open:", backupPath)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	// The whole backup is verified before anything is written
+	backup, err := store.ReadBackup(file)
+	if err != nil {
+		fmt.Println("Invalid backup:", err)
+		os.Exit(1)
+	}
+
+	// When moving to a new machine there is no config yet, so use the one from the backup
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		err = ioutil.WriteFile(configPath, backup.ConfigFile(), 0644)
+		if err != nil {
+			fmt.Println("Failed to write the config file:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Config file restored to:", configPath)
+	}
+	cfg := loadConfig(configPath)
+
+	err = backup.Restore(cfg)
+	if err != nil {
+		fmt.Println("Failed to restore the backup:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Restored backup created at %s to: %s\n", backup.Manifest.Created.Local().Format(time.RFC1123), cfg.SystemPath)
+}
+
+// openStore loads the key file and returns the store for the system in the config
+func openStore(cfg *gohome.Config) store.Store {
+	key, err := store.LoadOrCreateKeyFile(cfg.KeyFilePath())
+	if err != nil {
+		fmt.Println("Failed to load the key file:", err)
+		os.Exit(1)
+	}
+	store.SetEncryptionKey(key)
+
+	sysStore, err := store.New(cfg)
+	if err != nil {
+		fmt.Println("Invalid store type:", err)
+		os.Exit(1)
+	}
+	return sysStore
+}
+
+func exportSystemYAML(exportPath, configPath string) {
+	cfg := loadConfig(configPath)
+	sysStore := openStore(cfg)
 
 	log.Silent = true
-	sys := loadSystem(cfg.SystemPath)
+	sys := loadSystem(sysStore, cfg.SystemPath)
 	log.Silent = false
 
-	var user *gohome.User
-	for _, u := range sys.Users() {
-		if u.Login == login {
-			user = u
-			break
-		}
+	b, err := store.ExportYAML(sys)
+	if err != nil {
+		fmt.Println("Failed to export the system:", err)
+		os.Exit(1)
 	}
 
-	addedUser := false
-	if user == nil {
-		user = &gohome.User{
-			ID:    sys.NewID(),
-			Login: login,
-		}
-		err := user.Validate()
+	if exportPath == "" {
+		os.Stdout.Write(b)
+		return
+	}
+
+	err = ioutil.WriteFile(exportPath, b, 0644)
+	if err != nil {
+		fmt.Println("Failed to write the YAML file:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Exported the system to:", exportPath)
+}
+
+func importSystemYAML(importPath, configPath string) {
+	if importPath == "" {
+		fmt.Println("missing value, --import-yaml <path/to/file.yaml>")
+		os.Exit(1)
+	}
+
+	b, err := ioutil.ReadFile(importPath)
+	if err != nil {
+		fmt.Println("Error trying to open:", importPath)
+		os.Exit(1)
+	}
+
+	cfg := loadConfig(configPath)
+	sysStore := openStore(cfg)
+
+	log.Silent = true
+	sys := loadSystem(sysStore, cfg.SystemPath)
+	log.Silent = false
+
+	err = store.ImportYAML(sys, b)
+	if err != nil {
+		fmt.Println("Failed to import the YAML file, the system has not been changed:", err)
+		os.Exit(1)
+	}
+
+	// Record the import in the configuration history, so it can be rolled back
+	if cfg.ConfigHistoryRevisions >= 0 {
+		history, err := store.NewHistory(cfg.ConfigHistoryDirPath(), cfg.ConfigHistoryRevisions)
 		if err != nil {
-			fmt.Println("Failed to add user", err)
+			fmt.Println("Failed to open the configuration history:", err)
 			os.Exit(1)
 		}
-
-		sys.AddUser(user)
-		addedUser = true
+		sysStore = store.WithChange(store.NewHistoryStore(sysStore, history), store.Change{
+			Origin: "ghadmin --import-yaml " + filepath.Base(importPath),
+		})
 	}
 
-	err = user.SetPassword(password)
+	err = sysStore.SaveSystem(sys)
 	if err != nil {
-		fmt.Println("Failed to set the password:", err)
+		fmt.Println("Failed to save the system:", err)
 		os.Exit(1)
 	}
+	fmt.Println("Imported", importPath, "to:", cfg.SystemPath)
+}
+
+// loadUser returns the user with the login, exiting if the user doesn't exist
+func loadUser(login string, cfg *gohome.Config) *gohome.User {
+	sysStore := openStore(cfg)
+
+	log.Silent = true
+	sys := loadSystem(sysStore, cfg.SystemPath)
+	log.Silent = false
+
+	if user := sys.UserByLogin(login); user != nil {
+		return user
+	}
+	fmt.Println("User not found:", login)
+	os.Exit(1)
+	return nil
+}
 
-	err = store.SaveSystem(cfg.SystemPath, sys)
+// loadTokens loads the API tokens in the config
+func loadTokens(cfg *gohome.Config) *gohome.Tokens {
+	tokens := gohome.NewTokens(cfg.TokensFilePath())
+	if err := tokens.Load(); err != nil {
+		fmt.Println("Failed to load the API tokens:", err)
+		os.Exit(1)
+	}
+	return tokens
+}
+
+func createAPIToken(login, name, role, configPath string) {
+	if login == "" || name == "" {
+		fmt.Println("missing values, --create-token <login> <name>")
+		os.Exit(1)
+	}
+
+	cfg := loadConfig(configPath)
+	user := loadUser(login, cfg)
+	tokens := loadTokens(cfg)
+
+	secret, _, err := tokens.Add(user, name, role)
 	if err != nil {
-		fmt.Println("Failed to save the user changes to disk:" + err.Error())
+		fmt.Println("Failed to create the token:", err)
+		os.Exit(1)
+	}
+	if err := tokens.Save(); err != nil {
+		fmt.Println("Failed to save the API tokens:", err)
 		os.Exit(1)
 	}
 
-	if addedUser {
-		fmt.Println("Successfully added user:", login, " to:", cfg.SystemPath)
-	} else {
-		fmt.Println("Successfully updated password for user:", login, "to:", cfg.SystemPath)
+	fmt.Printf("Created token \"%s\" for %s, it will not be shown again:\n%s\n", name, login, secret)
+}
+
+func revokeAPIToken(login, name, configPath string) {
+	if login == "" || name == "" {
+		fmt.Println("missing values, --revoke-token <login> <name>")
+		os.Exit(1)
 	}
+
+	cfg := loadConfig(configPath)
+	user := loadUser(login, cfg)
+	tokens := loadTokens(cfg)
+
+	found := false
+	for _, token := range tokens.UserTokens(user.ID) {
+		if token.Name == name {
+			found = tokens.Delete(token.ID)
+			break
+		}
+	}
+	if !found {
+		fmt.Printf("%s doesn't have a token named \"%s\"\n", login, name)
+		os.Exit(1)
+	}
+	if err := tokens.Save(); err != nil {
+		fmt.Println("Failed to save the API tokens:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Revoked token \"%s\" for %s\n", name, login)
 }
 
-func loadSystem(systemPath string) *gohome.System {
-	sys, err := store.LoadSystem(systemPath)
-	if err == store.ErrFileNotFound {
-		fmt.Println("System file not found at: ", systemPath)
+func listAPITokens(login, configPath string) {
+	if login == "" {
+		fmt.Println("missing values, --list-tokens <login>")
 		os.Exit(1)
-	} else if err != nil {
-		fmt.Println("Failed to load system file: ", err)
+	}
+
+	cfg := loadConfig(configPath)
+	user := loadUser(login, cfg)
+	tokens := loadTokens(cfg)
+
+	for _, token := range tokens.UserTokens(user.ID) {
+		role := token.Role
+		if role == "" {
+			role = user.Role
+		}
+		lastUsed := "never used"
+		if !token.LastUsed.IsZero() {
+			lastUsed = "last used " + token.LastUsed.Local().Format(time.RFC1123)
+		}
+		fmt.Printf("%s\t%s\tcreated %s\t%s\n", token.Name, role, token.Created.Local().Format(time.RFC1123), lastUsed)
+	}
+}
+
+func disableUser2FA(login, configPath string) {
+	if login == "" {
+		fmt.Println("missing values, --disable-2fa <login>")
 		os.Exit(1)
 	}
 
-	return sys
+	cfg := loadConfig(configPath)
+	sysStore := openStore(cfg)
+
+	log.Silent = true
+	sys := loadSystem(sysStore, cfg.SystemPath)
+	log.Silent = false
+
+	user := sys.UserByLogin(login)
+	if user == nil {
+		fmt.Println("User not found:", login)
+		os.Exit(1)
+	}
+
+	user.DisableTOTP()
+	if err := sysStore.SaveUser(sys, user); err != nil {
+		fmt.Println("Failed to save the user changes to disk:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Disabled two factor authentication for:", login)
 }
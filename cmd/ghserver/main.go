@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-home-iot/event-bus"
@@ -45,7 +47,7 @@ func main() {
 	}
 
 	// Load the system from disk
-	sys, cfg := loadSystem(*configPath)
+	sys, sysStore, cfg := loadSystem(*configPath)
 
 	// The event bus is the backbone of the app.  It allows device to post events
 	// and other devices can list for events and act upon them.
@@ -82,6 +84,19 @@ func main() {
 	evtLogger := &gohome.EventLogger{Path: cfg.EventLogPath, Verbose: false}
 	eb.AddConsumer(evtLogger)
 
+	// Record the history of all the attribute values, so they can be charted over time
+	history := gohome.NewAttrHistory(
+		cfg.HistoryDirPath(),
+		time.Duration(cfg.HistoryRawRetentionDays)*time.Hour*24,
+		time.Duration(cfg.HistoryRollupRetentionDays)*time.Hour*24)
+	sys.Services.History = history
+	eb.AddConsumer(history)
+
+	// Record every command and hardware change, and who or what made it, in the audit log
+	audit := gohome.NewAuditLog(sys, cfg.AuditLogFilePath())
+	sys.Services.Audit = audit
+	eb.AddConsumer(audit)
+
 	log.V("Initing devices...")
 	sys.InitDevices()
 
@@ -95,17 +110,67 @@ func main() {
 	}
 	eb.AddProducer(th)
 
-	sessions := gohome.NewSessions()
+	// Sessions are saved so users stay logged in when the server restarts
+	sessions := gohome.NewSessions(
+		cfg.SessionsFilePath(),
+		time.Duration(cfg.SessionIdleDays)*time.Hour*24,
+		time.Duration(cfg.SessionMaxAgeDays)*time.Hour*24)
+	if err := sessions.Load(); err != nil {
+		log.E("Failed to load sessions, all users will need to log in again: %s", err)
+	}
+	sessions.Start()
+
+	tokens := gohome.NewTokens(cfg.TokensFilePath())
+	if err := tokens.Load(); err != nil {
+		log.E("Failed to load API tokens: %s", err)
+	}
+	tokens.Start()
+
+	trustedNets, err := cfg.LoginTrustedNets()
+	if err != nil {
+		log.E("Invalid loginTrustedSubnets in the config file: %s", err)
+		os.Exit(1)
+	}
+	loginThrottle := gohome.NewLoginThrottle(
+		cfg.LoginMaxFailures,
+		time.Duration(cfg.LoginLockoutMinutes)*time.Minute,
+		trustedNets)
+
+	// The server shuts down when it is told to stop, or when a backup has been restored and the
+	// restored system has to be loaded
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	restart := make(chan bool, 1)
+	shutdown := func() {
+		select {
+		case restart <- true:
+		default:
+		}
+	}
+
 	go func() {
 		for {
 			endPoint := cfg.WWWAddr + ":" + cfg.WWWPort
 			log.V("WWW Server starting, listening on %s", endPoint)
-			err := www.ListenAndServe(cfg.WebUIPath, endPoint, sys, cfg.SystemPath, sessions, &cfg)
+			err := www.ListenAndServe(cfg.WebUIPath, endPoint, sys, sysStore, sessions, tokens, loginThrottle, &cfg, shutdown)
 			log.E("error with WWW server, shutting down: %s\n", err)
 			time.Sleep(time.Second * 5)
 		}
 	}()
 
+	// Browsers that go to the HTTP address are redirected to HTTPS, so nobody has to remember to type https://
+	if cfg.WWWTLS() && cfg.WWWRedirectPort != "" {
+		go func() {
+			for {
+				endPoint := cfg.WWWAddr + ":" + cfg.WWWRedirectPort
+				log.V("WWW redirect server starting, redirecting %s to HTTPS", endPoint)
+				err := www.ListenAndServeRedirect(endPoint, cfg.WWWPort)
+				log.E("error with WWW redirect server: %s\n", err)
+				time.Sleep(time.Second * 5)
+			}
+		}()
+	}
+
 	// Load all of the automation scripts
 	autos, err := gohome.LoadAutomation(sys, cfg.AutomationPath)
 	if err != nil {
@@ -136,12 +201,25 @@ func main() {
 	// Log we started the system
 	sys.Services.EvtBus.Enqueue(&gohome.ServerStartedEvt{})
 
-	// Sit forever since we have started all the services
-	var done chan bool
-	<-done
+	// Run until we are told to shut down
+	select {
+	case sig := <-stop:
+		log.V("received %s, shutting down", sig)
+	case <-restart:
+		log.V("shutting down so the server can be restarted")
+	}
+
+	// Stop the devices and consumers, then save the sessions and tokens so nothing is lost
+	for _, d := range sys.Devices() {
+		sys.StopDevice(d)
+	}
+	eb.Stop()
+	sessions.Stop()
+	tokens.Stop()
+	log.V("shut down")
 }
 
-func loadSystem(configPath string) (*gohome.System, gohome.Config) {
+func loadSystem(configPath string) (*gohome.System, store.Store, gohome.Config) {
 	file, err := os.Open(configPath)
 	if err != nil {
 		fmt.Println("Unable to open config file:", configPath, err)
@@ -158,11 +236,61 @@ func loadSystem(configPath string) (*gohome.System, gohome.Config) {
 
 	log.V("Config information: %#v", cfg)
 
-	sys, err := store.LoadSystem(cfg.SystemPath)
+	// Zero means the value was not in the config file, so we keep the default
+	if cfg.SystemBackups != 0 {
+		store.MaxBackups = cfg.SystemBackups
+	}
+
+	// Secrets in the system file are encrypted with the key, systems created before secrets
+	// were encrypted won't have a key file yet, so one is created
+	keyPath := cfg.KeyFilePath()
+	key, err := store.LoadOrCreateKeyFile(keyPath)
 	if err != nil {
+		log.E("Failed to load the key file: %s, %s", keyPath, err)
+		os.Exit(1)
+	}
+	if err := store.SetEncryptionKey(key); err != nil {
+		log.E("Invalid key file: %s, %s", keyPath, err)
+		os.Exit(1)
+	}
+
+	sysStore, err := store.New(cfg)
+	if err != nil {
+		log.E("Invalid store type: %s", err)
+		os.Exit(1)
+	}
+
+	// The store is made read only when a backup is restored, so the restored files aren't overwritten
+	sysStore = store.NewReadOnlyStore(sysStore)
+
+	sys, err := sysStore.Load()
+	if err == store.ErrFileNotFound {
 		log.E("System file not found at: %s, run the ghadmin command to initialize an empty system file", cfg.SystemPath)
 		os.Exit(1)
+	} else if err != nil {
+		log.E("Failed to load system file: %s, %s", cfg.SystemPath, err)
+		os.Exit(1)
+	}
+
+	// Every change to the system is recorded in the configuration history, so it can be rolled back
+	if cfg.ConfigHistoryRevisions >= 0 {
+		historyPath := cfg.ConfigHistoryDirPath()
+		history, err := store.NewHistory(historyPath, cfg.ConfigHistoryRevisions)
+		if err != nil {
+			log.E("Failed to open the configuration history: %s, %s", historyPath, err)
+			os.Exit(1)
+		}
+
+		// The first revision is the system as it was loaded, so the first change has something to diff against
+		revs, err := history.Revisions(1)
+		if err == nil && len(revs) == 0 {
+			_, err = history.Record(sys, store.Change{Origin: "ghserver"}, "initial system")
+		}
+		if err != nil {
+			log.E("Failed to record the initial configuration: %s", err)
+		}
+		sysStore = store.NewHistoryStore(sysStore, history)
 	}
 
-	return sys, *cfg
+	return sys, sysStore, *cfg
 }
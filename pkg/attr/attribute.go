@@ -2,8 +2,10 @@ package attr
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/lucasb-eyer/go-colorful"
 	"github.com/markdaws/gohome/pkg/log"
@@ -35,6 +37,9 @@ const (
 
 	// UTMillisecond millisecond
 	UTMilliSecond string = "millisecond"
+
+	// UTLux lux, a measure of illuminance
+	UTLux string = "lux"
 )
 
 const (
@@ -58,6 +63,27 @@ const (
 
 	// ATButtonState represents a button state e.g. pressed/released
 	ATButtonState string = "BtnState"
+
+	// ATMotion represents a motion attribute, either motion detected or clear
+	ATMotion string = "Motion"
+
+	// ATContact represents a contact attribute, such as a door or window contact that is open or closed
+	ATContact string = "Contact"
+
+	// ATHumidity represents a relative humidity attribute
+	ATHumidity string = "Humidity"
+
+	// ATIlluminance represents an illuminance (light level) attribute
+	ATIlluminance string = "Illuminance"
+
+	// ATBattery represents a battery level attribute
+	ATBattery string = "Battery"
+
+	// ATLeak represents a water leak attribute, either leak detected or dry
+	ATLeak string = "Leak"
+
+	// ATSmoke represents a smoke attribute, either smoke detected or clear
+	ATSmoke string = "Smoke"
 )
 
 const (
@@ -113,43 +139,36 @@ func (a Attribute) String() string {
 }
 
 // FixJSON massages the values back from float64 which is the type given to the values
-// when being unmarshalled, to their correct data type
+// when being unmarshalled, to their correct data type. Values that are not numbers are
+// left untouched, it is up to the caller to validate them using ValidateValue
 func FixJSON(attrs map[string]*Attribute) {
 	for _, attribute := range attrs {
+		if attribute == nil {
+			continue
+		}
+
 		// When these are deserialized from JSON the interface values get the wrong type, need to
 		// massage them back to the expected type
-		if attribute.Value != nil {
-			switch attribute.DataType {
-			case DTFloat32:
-				attribute.Value = float32(attribute.Value.(float64))
-			case DTInt32:
-				attribute.Value = int32(attribute.Value.(float64))
-			}
-		}
-		if attribute.Min != nil {
-			switch attribute.DataType {
-			case DTFloat32:
-				attribute.Min = float32(attribute.Min.(float64))
-			case DTInt32:
-				attribute.Min = int32(attribute.Min.(float64))
-			}
-		}
-		if attribute.Max != nil {
-			switch attribute.DataType {
-			case DTFloat32:
-				attribute.Max = float32(attribute.Max.(float64))
-			case DTInt32:
-				attribute.Max = int32(attribute.Max.(float64))
-			}
-		}
-		if attribute.Step != nil {
-			switch attribute.DataType {
-			case DTFloat32:
-				attribute.Step = float32(attribute.Step.(float64))
-			case DTInt32:
-				attribute.Step = int32(attribute.Step.(float64))
-			}
-		}
+		attribute.Value = fixJSONValue(attribute.DataType, attribute.Value)
+		attribute.Min = fixJSONValue(attribute.DataType, attribute.Min)
+		attribute.Max = fixJSONValue(attribute.DataType, attribute.Max)
+		attribute.Step = fixJSONValue(attribute.DataType, attribute.Step)
+	}
+}
+
+func fixJSONValue(dataType string, val interface{}) interface{} {
+	f, ok := val.(float64)
+	if !ok {
+		return val
+	}
+
+	switch dataType {
+	case DTFloat32:
+		return float32(f)
+	case DTInt32:
+		return int32(f)
+	default:
+		return val
 	}
 }
 
@@ -288,8 +307,12 @@ const (
 	ButtonStateReleased int32 = 2
 )
 
+// NewButtonState returns a new Attribute instance initialized as a ButtonState type
 func NewButtonState(localID string, val *int32) *Attribute {
-	return NewInt32(localID, ATButtonState, val)
+	attr := NewInt32(localID, ATButtonState, val)
+	attr.Min = ButtonStatePressed
+	attr.Max = ButtonStateReleased
+	return attr
 }
 
 const (
@@ -303,6 +326,8 @@ const (
 // NweOpenClose returns a new attribute instance, initialized as an OpenClose type
 func NewOpenClose(localID string, val *int32) *Attribute {
 	attr := NewInt32(localID, ATOpenClose, val)
+	attr.Min = OpenCloseClosed
+	attr.Max = OpenCloseOpen
 	return attr
 }
 
@@ -317,6 +342,8 @@ const (
 // NewOnOff returns a new Attribute instance initialized as an OnOff type
 func NewOnOff(localID string, val *int32) *Attribute {
 	attr := NewInt32(localID, ATOnOff, val)
+	attr.Min = OnOffOff
+	attr.Max = OnOffOn
 	return attr
 }
 
@@ -414,6 +441,98 @@ func NewTemp(localID string, val *int32) *Attribute {
 	return attr
 }
 
+// IsTempUnit returns true if the unit is one of the supported temperature units
+func IsTempUnit(unit string) bool {
+	return unit == UTCelcius || unit == UTFarenheit
+}
+
+// ConvertTemp converts the temperature value from one unit to another, the from and to
+// parameters must be either UTCelcius or UTFarenheit
+func ConvertTemp(val float64, from, to string) (float64, error) {
+	if !IsTempUnit(from) {
+		return 0, fmt.Errorf("unsupported temperature unit: %s", from)
+	}
+	if !IsTempUnit(to) {
+		return 0, fmt.Errorf("unsupported temperature unit: %s", to)
+	}
+
+	if from == to {
+		return val, nil
+	}
+	if from == UTCelcius {
+		return val*9/5 + 32, nil
+	}
+	return (val - 32) * 5 / 9, nil
+}
+
+// ConvertTempValue is the same as ConvertTemp but accepts any of the numeric types an attribute
+// value can hold, the converted value is returned as a float64
+func ConvertTempValue(val interface{}, from, to string) (interface{}, error) {
+	f, ok := toFloat64(val)
+	if !ok {
+		return nil, fmt.Errorf("invalid value, temperature must be a number")
+	}
+
+	converted, err := ConvertTemp(f, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+// ParseTemp parses a temperature string such as "21C", "70F" or "70" returning the value
+// and the unit. If the string does not specify a unit, the returned unit is empty and it is
+// up to the caller to decide which unit the value is in
+func ParseTemp(val string) (float64, string, error) {
+	val = strings.ToUpper(strings.TrimSpace(val))
+
+	unit := ""
+	switch {
+	case strings.HasSuffix(val, "C"):
+		unit = UTCelcius
+	case strings.HasSuffix(val, "F"):
+		unit = UTFarenheit
+	}
+	if unit != "" {
+		val = strings.TrimSuffix(strings.TrimSpace(val[:len(val)-1]), "°")
+	}
+
+	temp, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid temperature: %s, must be a number optionally followed by C or F", val)
+	}
+	return temp, unit, nil
+}
+
+// ToTempUnit returns a clone of the attribute with the value, min and max converted to
+// the specified temperature unit. If the attribute is not a temperature attribute or is already
+// in the specified unit, an unmodified clone is returned.  Converted values keep the data type
+// of the attribute, so int32 values are rounded to the nearest degree
+func (a *Attribute) ToTempUnit(unit string) *Attribute {
+	b := a.Clone()
+	if !IsTempUnit(a.Unit) || !IsTempUnit(unit) || a.Unit == unit {
+		return b
+	}
+
+	convert := func(val interface{}) interface{} {
+		f, ok := toFloat64(val)
+		if !ok {
+			return val
+		}
+		f, _ = ConvertTemp(f, a.Unit, unit)
+		if a.DataType == DTInt32 {
+			return int32(math.Round(f))
+		}
+		return float32(f)
+	}
+
+	b.Value = convert(b.Value)
+	b.Min = convert(b.Min)
+	b.Max = convert(b.Max)
+	b.Unit = unit
+	return b
+}
+
 /*
 const (
 	HeatingCoolingModeOff  int = 0
@@ -0,0 +1,60 @@
+package attr_test
+
+import (
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertTemp(t *testing.T) {
+	t.Parallel()
+
+	f, err := attr.ConvertTemp(100, attr.UTCelcius, attr.UTFarenheit)
+	require.Nil(t, err)
+	require.Equal(t, float64(212), f)
+
+	c, err := attr.ConvertTemp(212, attr.UTFarenheit, attr.UTCelcius)
+	require.Nil(t, err)
+	require.Equal(t, float64(100), c)
+
+	_, err = attr.ConvertTemp(10, "kelvin", attr.UTCelcius)
+	require.NotNil(t, err)
+}
+
+func TestParseTemp(t *testing.T) {
+	t.Parallel()
+
+	val, unit, err := attr.ParseTemp("21C")
+	require.Nil(t, err)
+	require.Equal(t, float64(21), val)
+	require.Equal(t, attr.UTCelcius, unit)
+
+	val, unit, err = attr.ParseTemp("70.5 f")
+	require.Nil(t, err)
+	require.Equal(t, float64(70.5), val)
+	require.Equal(t, attr.UTFarenheit, unit)
+
+	val, unit, err = attr.ParseTemp("70")
+	require.Nil(t, err)
+	require.Equal(t, float64(70), val)
+	require.Equal(t, "", unit)
+
+	_, _, err = attr.ParseTemp("warm")
+	require.NotNil(t, err)
+}
+
+func TestToTempUnit(t *testing.T) {
+	t.Parallel()
+
+	temp := attr.NewTemp("targettemp", attr.Int32P(70))
+	c := temp.ToTempUnit(attr.UTCelcius)
+	require.Equal(t, attr.UTCelcius, c.Unit)
+	require.Equal(t, int32(21), c.Value)
+	require.Equal(t, int32(4), c.Min)
+	require.Equal(t, int32(27), c.Max)
+
+	// The original should not be modified
+	require.Equal(t, attr.UTFarenheit, temp.Unit)
+	require.Equal(t, int32(70), temp.Value)
+}
@@ -0,0 +1,53 @@
+package attr
+
+const (
+	// ATDoorState represents the current state of a door that can be moved, such as a garage door
+	ATDoorState string = "DoorState"
+
+	// ATObstruction represents an obstruction detector, such as the beam across a garage door
+	ATObstruction string = "Obstruction"
+)
+
+const (
+	// DoorStateClosed indicates the door is fully closed
+	DoorStateClosed int32 = 1
+
+	// DoorStateOpen indicates the door is fully open
+	DoorStateOpen int32 = 2
+
+	// DoorStateOpening indicates the door is currently opening
+	DoorStateOpening int32 = 3
+
+	// DoorStateClosing indicates the door is currently closing
+	DoorStateClosing int32 = 4
+
+	// DoorStateStopped indicates the door stopped before it was fully open or closed
+	DoorStateStopped int32 = 5
+)
+
+// NewDoorState returns a new read only Attribute instance initialized as a DoorState type.
+// To move the door, use an OpenClose attribute
+func NewDoorState(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATDoorState, val)
+	attr.Min = DoorStateClosed
+	attr.Max = DoorStateStopped
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+const (
+	// ObstructionClear indicates there is no obstruction
+	ObstructionClear int32 = 1
+
+	// ObstructionDetected indicates an obstruction has been detected
+	ObstructionDetected int32 = 2
+)
+
+// NewObstruction returns a new read only Attribute instance initialized as an Obstruction type
+func NewObstruction(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATObstruction, val)
+	attr.Min = ObstructionClear
+	attr.Max = ObstructionDetected
+	attr.Perms = PermsReadOnly
+	return attr
+}
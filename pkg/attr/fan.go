@@ -0,0 +1,63 @@
+package attr
+
+const (
+	// ATFanSpeed represents a preset fan speed attribute e.g. off, low, medium, high
+	ATFanSpeed string = "FanSpeed"
+
+	// ATSpeed represents a speed attribute, as a percentage of the max speed
+	ATSpeed string = "Speed"
+
+	// ATFanDirection represents the direction a fan is spinning
+	ATFanDirection string = "FanDirection"
+)
+
+const (
+	// FanSpeedOff indicates the fan is not spinning
+	FanSpeedOff int32 = 1
+
+	// FanSpeedLow indicates the fan is spinning at the low preset speed
+	FanSpeedLow int32 = 2
+
+	// FanSpeedMedium indicates the fan is spinning at the medium preset speed
+	FanSpeedMedium int32 = 3
+
+	// FanSpeedHigh indicates the fan is spinning at the high preset speed
+	FanSpeedHigh int32 = 4
+)
+
+// NewFanSpeed returns a new Attribute instance initialized as a FanSpeed type
+func NewFanSpeed(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATFanSpeed, val)
+	attr.Min = FanSpeedOff
+	attr.Max = FanSpeedHigh
+	return attr
+}
+
+// NewSpeed returns a new Attribute instance initialized as a Speed type, the value is
+// a percentage of the maximum speed
+func NewSpeed(localID string, val *float32) *Attribute {
+	attr := NewFloat32(localID, ATSpeed, val)
+	attr.Unit = UTPercentage
+	attr.Min = float32(0)
+	attr.Max = float32(100)
+	attr.Step = float32(1)
+	return attr
+}
+
+const (
+	// FanDirectionForward indicates the fan is spinning forwards, for a ceiling fan
+	// this pushes air downwards
+	FanDirectionForward int32 = 1
+
+	// FanDirectionReverse indicates the fan is spinning in reverse, for a ceiling fan
+	// this pulls air upwards
+	FanDirectionReverse int32 = 2
+)
+
+// NewFanDirection returns a new Attribute instance initialized as a FanDirection type
+func NewFanDirection(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATFanDirection, val)
+	attr.Min = FanDirectionForward
+	attr.Max = FanDirectionReverse
+	return attr
+}
@@ -0,0 +1,123 @@
+package attr
+
+const (
+	// MotionClear indicates the Motion attribute is not detecting any motion
+	MotionClear int32 = 1
+
+	// MotionDetected indicates the Motion attribute has detected motion
+	MotionDetected int32 = 2
+)
+
+// NewMotion returns a new read only Attribute instance initialized as a Motion type
+func NewMotion(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATMotion, val)
+	attr.Min = MotionClear
+	attr.Max = MotionDetected
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+const (
+	// ContactClosed indicates the Contact attribute is closed e.g. the door is shut
+	ContactClosed int32 = 1
+
+	// ContactOpen indicates the Contact attribute is open e.g. the door is open
+	ContactOpen int32 = 2
+)
+
+// NewContact returns a new read only Attribute instance initialized as a Contact type
+func NewContact(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATContact, val)
+	attr.Min = ContactClosed
+	attr.Max = ContactOpen
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+const (
+	// LeakDry indicates the Leak attribute is not detecting any water
+	LeakDry int32 = 1
+
+	// LeakDetected indicates the Leak attribute has detected water
+	LeakDetected int32 = 2
+)
+
+// NewLeak returns a new read only Attribute instance initialized as a Leak type
+func NewLeak(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATLeak, val)
+	attr.Min = LeakDry
+	attr.Max = LeakDetected
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+const (
+	// SmokeClear indicates the Smoke attribute is not detecting any smoke
+	SmokeClear int32 = 1
+
+	// SmokeDetected indicates the Smoke attribute has detected smoke
+	SmokeDetected int32 = 2
+)
+
+// NewSmoke returns a new read only Attribute instance initialized as a Smoke type
+func NewSmoke(localID string, val *int32) *Attribute {
+	attr := NewInt32(localID, ATSmoke, val)
+	attr.Min = SmokeClear
+	attr.Max = SmokeDetected
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+// NewHumidity returns a new read only Attribute instance initialized as a relative Humidity type
+func NewHumidity(localID string, val *float32) *Attribute {
+	attr := NewFloat32(localID, ATHumidity, val)
+	attr.Unit = UTPercentage
+	attr.Min = float32(0)
+	attr.Max = float32(100)
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+// NewIlluminance returns a new read only Attribute instance initialized as an Illuminance type,
+// measured in lux
+func NewIlluminance(localID string, val *float32) *Attribute {
+	attr := NewFloat32(localID, ATIlluminance, val)
+	attr.Unit = UTLux
+	attr.Min = float32(0)
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+// NewBattery returns a new read only Attribute instance initialized as a Battery type, the
+// value is the percentage of battery remaining
+func NewBattery(localID string, val *float32) *Attribute {
+	attr := NewFloat32(localID, ATBattery, val)
+	attr.Unit = UTPercentage
+	attr.Min = float32(0)
+	attr.Max = float32(100)
+	attr.Perms = PermsReadOnly
+	return attr
+}
+
+// NewSensorAttr returns a new attribute for one of the standard sensor attribute types, such as
+// ATMotion or ATHumidity. If the type is not a sensor type nil is returned
+func NewSensorAttr(localID, attrType string) *Attribute {
+	switch attrType {
+	case ATMotion:
+		return NewMotion(localID, nil)
+	case ATContact:
+		return NewContact(localID, nil)
+	case ATLeak:
+		return NewLeak(localID, nil)
+	case ATSmoke:
+		return NewSmoke(localID, nil)
+	case ATHumidity:
+		return NewHumidity(localID, nil)
+	case ATIlluminance:
+		return NewIlluminance(localID, nil)
+	case ATBattery:
+		return NewBattery(localID, nil)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package attr
+
+import (
+	"fmt"
+	"math"
+)
+
+// floatEpsilon is the tolerance used when checking float values are aligned to the step
+const floatEpsilon = 0.0001
+
+// ValidateValue verifies that val can be assigned to the attribute. It returns the value
+// converted to the attributes data type, for example a float64 decoded from JSON is
+// converted to an int32 for an int32 attribute.  If clamp is true, numeric values outside
+// of the Min/Max range are clamped to the range and rounded to the nearest Step, if clamp is
+// false those values are rejected and an error is returned. Read only attributes can never be
+// written to, an error is always returned for them regardless of the value.
+func (a *Attribute) ValidateValue(val interface{}, clamp bool) (interface{}, error) {
+	if a.Perms == PermsReadOnly {
+		return nil, fmt.Errorf("read only attribute, cannot be set")
+	}
+
+	if val == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	switch a.DataType {
+	case DTString:
+		s, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value, must be a string")
+		}
+		if a.Type == ATHSL {
+			if _, _, _, err := HSLDeconstruct(s); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	case DTBool:
+		b, ok := val.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid value, must be a boolean")
+		}
+		return b, nil
+
+	case DTInt32:
+		f, ok := toFloat64(val)
+		if !ok {
+			return nil, fmt.Errorf("invalid value, must be an integer")
+		}
+		if f != math.Trunc(f) && !clamp {
+			return nil, fmt.Errorf("invalid value, must be an integer")
+		}
+		f, err := a.checkRange(math.Round(f), clamp)
+		if err != nil {
+			return nil, err
+		}
+		return int32(f), nil
+
+	case DTFloat32:
+		f, ok := toFloat64(val)
+		if !ok {
+			return nil, fmt.Errorf("invalid value, must be a number")
+		}
+		f, err := a.checkRange(f, clamp)
+		if err != nil {
+			return nil, err
+		}
+		return float32(f), nil
+
+	default:
+		return nil, fmt.Errorf("unknown data type: %s", a.DataType)
+	}
+}
+
+// RoundValue rounds a numeric value to the nearest Step of the attribute, and to a whole number
+// for int32 attributes. It is used for values converted from another unit, such as a temperature
+// in Celsius set on a Farenheit attribute, which are rarely an exact multiple of the Step. Values
+// are not clamped to the Min/Max range, non numeric values are returned unchanged
+func (a *Attribute) RoundValue(val interface{}) interface{} {
+	f, ok := toFloat64(val)
+	if !ok {
+		return val
+	}
+
+	min, _ := toFloat64(a.Min)
+	if step, ok := toFloat64(a.Step); ok && step > 0 {
+		f = min + math.Round((f-min)/step)*step
+	}
+	if a.DataType == DTInt32 {
+		f = math.Round(f)
+	}
+	return f
+}
+
+// checkRange verifies the value is inside the Min/Max range of the attribute and is a
+// multiple of Step. If clamp is true the value is modified to fit, otherwise an error is
+// returned
+func (a *Attribute) checkRange(val float64, clamp bool) (float64, error) {
+	min, hasMin := toFloat64(a.Min)
+	max, hasMax := toFloat64(a.Max)
+	step, hasStep := toFloat64(a.Step)
+
+	if hasMin && val < min {
+		if !clamp {
+			return 0, fmt.Errorf("invalid value, must be greater than or equal to %v", a.Min)
+		}
+		val = min
+	}
+	if hasMax && val > max {
+		if !clamp {
+			return 0, fmt.Errorf("invalid value, must be less than or equal to %v", a.Max)
+		}
+		val = max
+	}
+
+	if hasStep && step > 0 {
+		steps := (val - min) / step
+		if math.Abs(steps-math.Round(steps)) > floatEpsilon {
+			if !clamp {
+				return 0, fmt.Errorf("invalid value, must be a multiple of %v", a.Step)
+			}
+			val = min + math.Round(steps)*step
+			if hasMax && val > max {
+				val -= step
+			}
+		}
+	}
+	return val, nil
+}
+
+// toFloat64 converts any of the numeric types we can receive in an attribute value, either
+// from the system itself or from deserializing JSON/YAML, to a float64
+func toFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int32:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
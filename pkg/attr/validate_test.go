@@ -0,0 +1,66 @@
+package attr_test
+
+import (
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateValueReadOnly(t *testing.T) {
+	t.Parallel()
+
+	temp := attr.NewTemp("currenttemp", nil)
+	temp.Perms = attr.PermsReadOnly
+
+	_, err := temp.ValidateValue(int32(60), false)
+	require.NotNil(t, err)
+
+	_, err = temp.ValidateValue(int32(60), true)
+	require.NotNil(t, err)
+}
+
+func TestValidateValueDataType(t *testing.T) {
+	t.Parallel()
+
+	onoff := attr.NewOnOff("onoff", nil)
+	_, err := onoff.ValidateValue("on", true)
+	require.NotNil(t, err)
+
+	// JSON numbers are decoded as float64, they should be converted
+	val, err := onoff.ValidateValue(float64(2), false)
+	require.Nil(t, err)
+	require.Equal(t, attr.OnOffOn, val)
+
+	hsl := attr.NewHSL("hsl", nil)
+	_, err = hsl.ValidateValue("blue", false)
+	require.NotNil(t, err)
+
+	val, err = hsl.ValidateValue("hsl(100, 50%, 50%)", false)
+	require.Nil(t, err)
+	require.Equal(t, "hsl(100, 50%, 50%)", val)
+}
+
+func TestValidateValueRange(t *testing.T) {
+	t.Parallel()
+
+	brightness := attr.NewBrightness("brightness", nil)
+
+	_, err := brightness.ValidateValue(float32(250), false)
+	require.NotNil(t, err)
+
+	val, err := brightness.ValidateValue(float32(250), true)
+	require.Nil(t, err)
+	require.Equal(t, float32(100), val)
+
+	val, err = brightness.ValidateValue(float32(-5), true)
+	require.Nil(t, err)
+	require.Equal(t, float32(0), val)
+
+	_, err = brightness.ValidateValue(float32(50.4), false)
+	require.NotNil(t, err)
+
+	val, err = brightness.ValidateValue(float32(50.4), true)
+	require.Nil(t, err)
+	require.Equal(t, float32(50), val)
+}
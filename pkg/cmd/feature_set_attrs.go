@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/validation"
 )
 
 // FeatureSetAttrs indicates there are attributes we need to update on the hardware
@@ -24,3 +27,113 @@ func (c *FeatureSetAttrs) FriendlyString() string {
 func (c *FeatureSetAttrs) String() string {
 	return "cmd.FeatureSetAttrs"
 }
+
+// Validate verifies the attributes in the command can be applied to the feature. Each attribute
+// must exist on the feature, be writable and have a value matching the data type and range of
+// the feature attribute. On success the attribute values are normalized in place to the correct
+// data type. If clamp is true, values outside of the Min/Max/Step range are clamped instead of
+// being rejected. The returned errors are keyed by "attrs_<localID>"
+func (c *FeatureSetAttrs) Validate(f *feature.Feature, clamp bool) *validation.Errors {
+	errors := &validation.Errors{}
+
+	if f == nil || f.ID != c.FeatureID {
+		errors.AddExplicitField("invalid feature ID", "featureId")
+		return errors
+	}
+
+	if len(c.Attrs) == 0 {
+		errors.AddExplicitField("required field", "attrs")
+		return errors
+	}
+
+	for localID, attribute := range c.Attrs {
+		field := "attrs_" + localID
+
+		featureAttr, ok := f.Attrs[localID]
+		if !ok || attribute == nil {
+			errors.AddExplicitField("invalid attribute, not found on the feature", field)
+			continue
+		}
+
+		// Temperatures can be specified in a different unit to the one the hardware uses, in
+		// which case we convert them to the native unit of the feature. The converted value is
+		// rounded to the feature's step, e.g. 21C is 69.8F which is set as 70F on a thermostat
+		// that only accepts whole degrees
+		value := attribute.Value
+		if attr.IsTempUnit(attribute.Unit) && attr.IsTempUnit(featureAttr.Unit) && attribute.Unit != featureAttr.Unit {
+			converted, err := attr.ConvertTempValue(value, attribute.Unit, featureAttr.Unit)
+			if err != nil {
+				errors.AddExplicitField(err.Error(), field)
+				continue
+			}
+			value = featureAttr.RoundValue(converted)
+		}
+
+		val, err := featureAttr.ValidateValue(value, clamp)
+		if err != nil {
+			errors.AddExplicitField(err.Error(), field)
+			continue
+		}
+
+		// The only thing callers have to provide is the value, the rest of the attribute fields
+		// come from the feature so that we know the command matches the current feature definition
+		validAttr := featureAttr.Clone()
+		validAttr.Value = val
+		c.Attrs[localID] = validAttr
+	}
+
+	if errors.Has() {
+		return errors
+	}
+	return nil
+}
+
+func init() {
+	Register(&FeatureSetAttrs{}, Type{
+		Name: "featureSetAttrs",
+		Encode: func(c Command) (map[string]interface{}, error) {
+			setAttrs := c.(*FeatureSetAttrs)
+			return map[string]interface{}{
+				"id":    setAttrs.FeatureID,
+				"type":  setAttrs.FeatureType,
+				"attrs": setAttrs.Attrs,
+			}, nil
+		},
+		Decode: func(ID string, attrs map[string]interface{}, lookup Lookup) (Command, error) {
+			featureID, _ := attrs["id"].(string)
+			if featureID == "" {
+				return nil, validation.NewErrors("attributes_id", "required field", true)
+			}
+
+			f := lookup.FeatureByID(featureID)
+			if f == nil {
+				return nil, validation.NewErrors("attributes_id", "invalid feature ID", true)
+			}
+
+			// The attributes are generic JSON values, so marshal then unmarshal them back to the
+			// attribute type, then fix up the numeric values JSON turned in to float64
+			b, err := json.Marshal(attrs["attrs"])
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve attrs field: %s", err)
+			}
+
+			featureAttrs := make(map[string]*attr.Attribute)
+			if err := json.Unmarshal(b, &featureAttrs); err != nil {
+				return nil, validation.NewErrors("attributes_attrs", "invalid attributes", true)
+			}
+			attr.FixJSON(featureAttrs)
+
+			return &FeatureSetAttrs{
+				ID:          ID,
+				FeatureID:   f.ID,
+				FeatureName: f.Name,
+				FeatureType: f.Type,
+				Attrs:       featureAttrs,
+			}, nil
+		},
+		Validate: func(c Command, lookup Lookup) *validation.Errors {
+			setAttrs := c.(*FeatureSetAttrs)
+			return setAttrs.Validate(lookup.FeatureByID(setAttrs.FeatureID), false)
+		},
+	})
+}
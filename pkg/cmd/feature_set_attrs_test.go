@@ -0,0 +1,45 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/stretchr/testify/require"
+)
+
+func setTargetTemp(val interface{}, unit string) *cmd.FeatureSetAttrs {
+	target := attr.NewTemp(feature.HeatZoneTargetTempLocalID, nil)
+	target.Value = val
+	target.Unit = unit
+	return &cmd.FeatureSetAttrs{
+		FeatureID: "heat1",
+		Attrs:     map[string]*attr.Attribute{target.LocalID: target},
+	}
+}
+
+func TestFeatureSetAttrsConvertsTempUnits(t *testing.T) {
+	t.Parallel()
+
+	// The target temperature is an int32 in Farenheit
+	zone := feature.NewHeatZone("heat1")
+
+	// 21C is 69.8F, which is rounded to the nearest whole degree instead of being rejected
+	c := setTargetTemp(float64(21), attr.UTCelcius)
+	require.Nil(t, c.Validate(zone, false))
+	require.Equal(t, int32(70), c.Attrs[feature.HeatZoneTargetTempLocalID].Value)
+	require.Equal(t, attr.UTFarenheit, c.Attrs[feature.HeatZoneTargetTempLocalID].Unit)
+
+	c = setTargetTemp(float64(22.5), attr.UTCelcius)
+	require.Nil(t, c.Validate(zone, false))
+	require.Equal(t, int32(73), c.Attrs[feature.HeatZoneTargetTempLocalID].Value)
+
+	// Converted values must still be in range
+	c = setTargetTemp(float64(30), attr.UTCelcius)
+	require.NotNil(t, c.Validate(zone, false))
+
+	// Values in the unit of the feature are not rounded
+	c = setTargetTemp(float64(69.8), attr.UTFarenheit)
+	require.NotNil(t, c.Validate(zone, false))
+}
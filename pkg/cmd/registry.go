@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/validation"
+)
+
+// Lookup finds the items in the system that commands reference, it is implemented by gohome.System
+type Lookup interface {
+	// FeatureByID returns the feature with the specified ID, nil if not found
+	FeatureByID(ID string) *feature.Feature
+
+	// SceneNameByID returns the name of the scene with the specified ID, false if not found
+	SceneNameByID(ID string) (string, bool)
+}
+
+// Type describes how a type of command, such as the commands in a scene, is converted to and
+// from JSON. The same attributes are used in the system file and the REST API
+type Type struct {
+	// Name is the value of the type field in the JSON representation e.g. "sceneSet"
+	Name string
+
+	// Encode returns the attributes of the command
+	Encode func(c Command) (map[string]interface{}, error)
+
+	// Decode creates a command from its attributes, items the command references are found using
+	// lookup. If the attributes are invalid a *validation.Errors is returned, with fields named
+	// attributes_<key>
+	Decode func(ID string, attrs map[string]interface{}, lookup Lookup) (Command, error)
+
+	// Validate verifies the command can be executed, it is optional
+	Validate func(c Command, lookup Lookup) *validation.Errors
+}
+
+var registry = struct {
+	sync.RWMutex
+	byName map[string]*Type
+	byType map[reflect.Type]*Type
+}{
+	byName: make(map[string]*Type),
+	byType: make(map[reflect.Type]*Type),
+}
+
+// Register adds a type of command so it can be saved in the system file and used in the REST API.
+// prototype is a value of the Go type of the command e.g. &SceneSet{}. Registering the same name
+// or Go type twice panics
+func Register(prototype Command, t Type) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	goType := reflect.TypeOf(prototype)
+	if _, ok := registry.byName[t.Name]; ok {
+		panic(fmt.Sprintf("cmd: type %s is already registered", t.Name))
+	}
+	if _, ok := registry.byType[goType]; ok {
+		panic(fmt.Sprintf("cmd: %s is already registered", goType))
+	}
+
+	registry.byName[t.Name] = &t
+	registry.byType[goType] = &t
+}
+
+// TypeByName returns the command type with the specified name, false if not registered
+func TypeByName(name string) (*Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.byName[name]
+	return t, ok
+}
+
+// TypeOf returns the type of the command, false if the command type is not registered
+func TypeOf(c Command) (*Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.byType[reflect.TypeOf(c)]
+	return t, ok
+}
+
+// Encode returns the type name and attributes of the command
+func Encode(c Command) (string, map[string]interface{}, error) {
+	t, ok := TypeOf(c)
+	if !ok {
+		return "", nil, fmt.Errorf("unregistered command type: %T", c)
+	}
+
+	attrs, err := t.Encode(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return t.Name, attrs, nil
+}
+
+// Decode creates a command of the named type from its attributes
+func Decode(name, ID string, attrs map[string]interface{}, lookup Lookup) (Command, error) {
+	t, ok := TypeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown command type %s", name)
+	}
+	return t.Decode(ID, attrs, lookup)
+}
+
+// Validate verifies the command can be executed, returns nil if the command is valid
+func Validate(c Command, lookup Lookup) *validation.Errors {
+	t, ok := TypeOf(c)
+	if !ok {
+		return validation.NewErrors("type", fmt.Sprintf("unregistered command type: %T", c), true)
+	}
+	if t.Validate == nil {
+		return nil
+	}
+	return t.Validate(c, lookup)
+}
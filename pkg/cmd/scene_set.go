@@ -1,6 +1,10 @@
 package cmd
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/markdaws/gohome/pkg/validation"
+)
 
 type SceneSet struct {
 	ID        string
@@ -17,3 +21,30 @@ func (c *SceneSet) FriendlyString() string {
 func (c *SceneSet) String() string {
 	return fmt.Sprintf("cmd.SceneSet: %s, %s", c.SceneID, c.SceneName)
 }
+
+func init() {
+	Register(&SceneSet{}, Type{
+		Name: "sceneSet",
+		Encode: func(c Command) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"SceneID": c.(*SceneSet).SceneID,
+			}, nil
+		},
+		Decode: func(ID string, attrs map[string]interface{}, lookup Lookup) (Command, error) {
+			sceneID, _ := attrs["SceneID"].(string)
+			if sceneID == "" {
+				return nil, validation.NewErrors("attributes_SceneID", "required field", true)
+			}
+
+			name, ok := lookup.SceneNameByID(sceneID)
+			if !ok {
+				return nil, validation.NewErrors("attributes_SceneID", "invalid Scene ID", true)
+			}
+			return &SceneSet{
+				ID:        ID,
+				SceneID:   sceneID,
+				SceneName: name,
+			}, nil
+		},
+	})
+}
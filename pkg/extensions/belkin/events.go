@@ -212,6 +212,7 @@ func (p *producer) UPNPNotify(e upnp.NotifyEvent) {
 			p.System.Services.EvtBus.Enqueue(&gohome.FeatureAttrsChangedEvt{
 				FeatureID: sensor.ID,
 				Attrs:     feature.NewAttrs(attribute),
+				Origin:    gohome.Origin{Extension: "Belkin"},
 			})
 		}
 
@@ -230,6 +231,7 @@ func (p *producer) UPNPNotify(e upnp.NotifyEvent) {
 				p.System.Services.EvtBus.Enqueue(&gohome.FeatureAttrsChangedEvt{
 					FeatureID: swtch.ID,
 					Attrs:     feature.NewAttrs(onoff),
+					Origin:    gohome.Origin{Extension: "Belkin"},
 				})
 			}
 			if outlet != nil {
@@ -238,6 +240,7 @@ func (p *producer) UPNPNotify(e upnp.NotifyEvent) {
 				p.System.Services.EvtBus.Enqueue(&gohome.FeatureAttrsChangedEvt{
 					FeatureID: outlet.ID,
 					Attrs:     feature.NewAttrs(onoff),
+					Origin:    gohome.Origin{Extension: "Belkin"},
 				})
 			}
 		}
@@ -220,6 +220,7 @@ func (p *event) StartProducing(b *evtbus.Bus) {
 					p.System.Services.EvtBus.Enqueue(&gohome.FeatureAttrsChangedEvt{
 						FeatureID: btn.ID,
 						Attrs:     feature.NewAttrs(state),
+						Origin:    gohome.Origin{Extension: "Lutron"},
 					})
 
 				case *lutronExt.BtnReleaseEvt:
@@ -239,6 +240,7 @@ func (p *event) StartProducing(b *evtbus.Bus) {
 					p.System.Services.EvtBus.Enqueue(&gohome.FeatureAttrsChangedEvt{
 						FeatureID: btn.ID,
 						Attrs:     feature.NewAttrs(state),
+						Origin:    gohome.Origin{Extension: "Lutron"},
 					})
 
 				case *lutronExt.UnknownEvt:
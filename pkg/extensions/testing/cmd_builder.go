@@ -2,19 +2,118 @@ package testing
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/feature"
 	"github.com/markdaws/gohome/pkg/gohome"
 )
 
+// garageDoorTravelTime is how long the simulated garage door takes to fully open or close
+const garageDoorTravelTime = time.Second * 5
+
 type cmdBuilder struct {
+	System      *gohome.System
 	Device      *gohome.Device
 	ModelNumber string
 }
 
 func (b *cmdBuilder) Build(c cmd.Command) (*cmd.Func, error) {
 	switch b.ModelNumber {
+	case "testing.hardware":
+		command, ok := c.(*cmd.FeatureSetAttrs)
+		if !ok {
+			return nil, fmt.Errorf("unsupported command type")
+		}
+
+		f := b.System.FeatureByID(command.FeatureID)
+		if f == nil {
+			return nil, fmt.Errorf("unknown feature ID: %s", command.FeatureID)
+		}
+
+		switch f.Type {
+		case feature.FTFan:
+			return b.buildFan(f, command.Attrs), nil
+		case feature.FTGarageDoor:
+			return b.buildGarageDoor(f, command.Attrs)
+		default:
+			return nil, fmt.Errorf("unsupported feature type: %s", f.Type)
+		}
+
 	default:
 		return nil, errors.New("unsupported hardware found")
 	}
 }
+
+// buildFan simulates a fan, the hardware just reports back the values it was set to
+func (b *cmdBuilder) buildFan(f *feature.Feature, attrs feature.Attrs) *cmd.Func {
+	return &cmd.Func{
+		Func: func() error {
+			reported := feature.Attrs{}
+			for localID, attribute := range attrs {
+				reported[localID] = attribute.Clone()
+			}
+
+			// Setting a speed also turns the fan on or off, like a real fan controller would
+			if speed, ok := reported[feature.FanSpeedLocalID]; ok {
+				onoff, _, _, _ := feature.FanCloneAttrs(f)
+				if onoff != nil {
+					if speed.Value.(int32) == attr.FanSpeedOff {
+						onoff.Value = attr.OnOffOff
+					} else {
+						onoff.Value = attr.OnOffOn
+					}
+					reported[onoff.LocalID] = onoff
+				}
+			}
+
+			b.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+				FeatureID: f.ID,
+				Attrs:     reported,
+			})
+			return nil
+		},
+		Friendly: "testing.cmdBuilder.fan",
+	}
+}
+
+// buildGarageDoor simulates a garage door, the door reports that it is opening or closing, then
+// after some time has passed reports that it is fully open or closed
+func (b *cmdBuilder) buildGarageDoor(f *feature.Feature, attrs feature.Attrs) (*cmd.Func, error) {
+	target, ok := attrs[feature.GarageDoorOpenCloseLocalID]
+	if !ok {
+		return nil, fmt.Errorf("missing %s attribute", feature.GarageDoorOpenCloseLocalID)
+	}
+
+	return &cmd.Func{
+		Func: func() error {
+			state, openClose, _ := feature.GarageDoorCloneAttrs(f)
+			openClose.Value = target.Value
+
+			moving, final := attr.DoorStateOpening, attr.DoorStateOpen
+			if target.Value.(int32) == attr.OpenCloseClosed {
+				moving, final = attr.DoorStateClosing, attr.DoorStateClosed
+			}
+
+			state.Value = moving
+			b.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+				FeatureID: f.ID,
+				Attrs:     feature.NewAttrs(state, openClose),
+			})
+
+			go func() {
+				time.Sleep(garageDoorTravelTime)
+				state := state.Clone()
+				state.Value = final
+				b.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+					FeatureID: f.ID,
+					Attrs:     feature.NewAttrs(state),
+				})
+			}()
+			return nil
+		},
+		Friendly: "testing.cmdBuilder.garagedoor",
+	}, nil
+}
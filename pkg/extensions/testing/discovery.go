@@ -67,13 +67,12 @@ func (d *discoverer) ScanDevices(sys *gohome.System, uiFields map[string]string)
 	dimmableLight.DeviceID = dev.ID
 	dev.AddFeature(dimmableLight)
 
-	openClose := attr.NewOpenClose("openclose", nil)
-	sensor := feature.NewSensor(
-		sys.NewID(),
-		openClose,
-	)
+	sensor, err := feature.NewSensorFromTypes(sys.NewID(), attr.ATContact)
+	if err != nil {
+		return nil, err
+	}
 	sensor.Address = "3"
-	sensor.Name = "test sensor"
+	sensor.Name = "door sensor"
 	sensor.DeviceID = dev.ID
 	dev.AddFeature(sensor)
 
@@ -115,6 +114,41 @@ func (d *discoverer) ScanDevices(sys *gohome.System, uiFields map[string]string)
 	outlet.DeviceID = dev.ID
 	dev.AddFeature(outlet)
 
+	multiSensor, err := feature.NewSensorFromTypes(
+		sys.NewID(),
+		attr.ATMotion,
+		attr.ATIlluminance,
+		attr.ATHumidity,
+		attr.ATBattery)
+	if err != nil {
+		return nil, err
+	}
+	multiSensor.Name = "multi sensor"
+	multiSensor.Address = "10"
+	multiSensor.DeviceID = dev.ID
+	dev.AddFeature(multiSensor)
+
+	leakSensor, err := feature.NewSensorFromTypes(sys.NewID(), attr.ATLeak, attr.ATSmoke, attr.ATBattery)
+	if err != nil {
+		return nil, err
+	}
+	leakSensor.Name = "leak and smoke sensor"
+	leakSensor.Address = "11"
+	leakSensor.DeviceID = dev.ID
+	dev.AddFeature(leakSensor)
+
+	fan := feature.NewFan(sys.NewID())
+	fan.Name = "ceiling fan"
+	fan.Address = "12"
+	fan.DeviceID = dev.ID
+	dev.AddFeature(fan)
+
+	garageDoor := feature.NewGarageDoor(sys.NewID())
+	garageDoor.Name = "garage door"
+	garageDoor.Address = "13"
+	garageDoor.DeviceID = dev.ID
+	dev.AddFeature(garageDoor)
+
 	return &gohome.DiscoveryResults{
 		Devices: []*gohome.Device{dev},
 	}, nil
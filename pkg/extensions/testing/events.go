@@ -52,11 +52,15 @@ func (p *producer) StartProducing(b *evtbus.Bus) {
 					})
 
 				case "3":
-					openclose := attr.Only(f.Attrs).Clone()
-					openclose.Value = int32(1 + rand.Intn(2))
+					contact := feature.SensorCloneAttr(f, attr.ATContact)
+					if contact == nil {
+						// Imported before sensors had standard attribute types
+						continue
+					}
+					contact.Value = int32(1 + rand.Intn(2))
 					p.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
 						FeatureID: f.ID,
-						Attrs:     feature.NewAttrs(openclose),
+						Attrs:     feature.NewAttrs(contact),
 					})
 
 				case "4":
@@ -92,6 +96,69 @@ func (p *producer) StartProducing(b *evtbus.Bus) {
 						FeatureID: f.ID,
 						Attrs:     feature.NewAttrs(onoff, hsl),
 					})
+
+				case "10":
+					// Sensors imported before they had standard attribute types may be
+					// missing some of these, the missing ones are skipped
+					motion := feature.SensorCloneAttr(f, attr.ATMotion)
+					if motion != nil {
+						motion.Value = int32(1 + rand.Intn(2))
+					}
+					illuminance := feature.SensorCloneAttr(f, attr.ATIlluminance)
+					if illuminance != nil {
+						illuminance.Value = float32(rand.Intn(1000))
+					}
+					humidity := feature.SensorCloneAttr(f, attr.ATHumidity)
+					if humidity != nil {
+						humidity.Value = float32(30 + rand.Intn(41))
+					}
+					battery := feature.SensorCloneAttr(f, attr.ATBattery)
+					if battery != nil {
+						battery.Value = float32(rand.Intn(101))
+					}
+					attrs := feature.NewAttrs(motion, illuminance, humidity, battery)
+					if len(attrs) == 0 {
+						continue
+					}
+					p.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+						FeatureID: f.ID,
+						Attrs:     attrs,
+					})
+
+				case "11":
+					leak := feature.SensorCloneAttr(f, attr.ATLeak)
+					if leak != nil {
+						leak.Value = int32(1 + rand.Intn(2))
+					}
+					smoke := feature.SensorCloneAttr(f, attr.ATSmoke)
+					if smoke != nil {
+						smoke.Value = int32(1 + rand.Intn(2))
+					}
+					battery := feature.SensorCloneAttr(f, attr.ATBattery)
+					if battery != nil {
+						battery.Value = float32(rand.Intn(101))
+					}
+					attrs := feature.NewAttrs(leak, smoke, battery)
+					if len(attrs) == 0 {
+						continue
+					}
+					p.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+						FeatureID: f.ID,
+						Attrs:     attrs,
+					})
+
+				case "13":
+					// The door only moves when commanded, but the obstruction beam can be
+					// broken at any time
+					_, _, obstruction := feature.GarageDoorCloneAttrs(f)
+					if obstruction == nil {
+						continue
+					}
+					obstruction.Value = int32(1 + rand.Intn(2))
+					p.System.Services.EvtBus.Enqueue(&gohome.FeatureReportingEvt{
+						FeatureID: f.ID,
+						Attrs:     feature.NewAttrs(obstruction),
+					})
 				}
 			}
 		}
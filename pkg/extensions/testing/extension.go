@@ -16,7 +16,7 @@ func (e *extension) Name() string {
 func (e *extension) BuilderForDevice(sys *gohome.System, d *gohome.Device) cmd.Builder {
 	switch d.ModelNumber {
 	case "testing.hardware":
-		return &cmdBuilder{ModelNumber: d.ModelNumber, Device: d}
+		return &cmdBuilder{System: sys, ModelNumber: d.ModelNumber, Device: d}
 	default:
 		// This device is not one that we know how to control, return nil
 		return nil
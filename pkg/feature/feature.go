@@ -14,6 +14,12 @@ const (
 	// FTCoolZone cooling zone
 	FTCoolZone string = "CoolZone"
 
+	// FTFan fan, such as a ceiling fan
+	FTFan string = "Fan"
+
+	// FTGarageDoor garage door
+	FTGarageDoor string = "GarageDoor"
+
 	// FTHeatZone heating zone
 	FTHeatZone string = "HeatZone"
 
@@ -87,6 +93,17 @@ func (f *Feature) String() string {
 		f.ID, f.Type, f.Address, f.Name, f.DeviceID)
 }
 
+// AttrByType returns the first attribute on the feature with the specified attribute type
+// e.g. attr.ATMotion, nil if the feature does not have an attribute of that type
+func (f *Feature) AttrByType(attrType string) *attr.Attribute {
+	for _, attribute := range f.Attrs {
+		if attribute.Type == attrType {
+			return attribute
+		}
+	}
+	return nil
+}
+
 // Validate returns nil if the feature is in a valid state, otherwise a validation
 // error is returned detailing the issues
 func (f *Feature) Validate() *validation.Errors {
@@ -108,6 +125,10 @@ func (f *Feature) Validate() *validation.Errors {
 		errors.Add("require field", "Type")
 	}
 
+	if f.Type == FTSensor && len(f.Attrs) == 0 {
+		errors.Add("sensors must have at least one attribute", "Attrs")
+	}
+
 	if errors.Has() {
 		return errors
 	}
@@ -128,6 +149,10 @@ func NewFromType(ID, fType string) *Feature {
 		return NewSwitch(ID)
 	case FTWindowTreatment:
 		return NewWindowTreatment(ID)
+	case FTFan:
+		return NewFan(ID)
+	case FTGarageDoor:
+		return NewGarageDoor(ID)
 	case FTButton:
 		//TODO:
 		return nil
@@ -135,24 +160,84 @@ func NewFromType(ID, fType string) *Feature {
 		//TODO:
 		return nil
 	case FTSensor:
-		// Don't support sensor since a sensor needs an attribute
-		// as well to be initialized
-		return nil
+		// Sensors have no attributes by default, the caller must add the
+		// attributes the sensor supports, see NewSensorFromTypes
+		return NewSensor(ID)
 	default:
 		return nil
 	}
 }
 
-// NewSensor returns a feature instance initialized as a Sensor
-func NewSensor(ID string, attribute *attr.Attribute) *Feature {
+// NewSensor returns a feature instance initialized as a Sensor. A sensor can report one or more
+// attributes, for example a multi-sensor may report motion, illuminance and battery level
+func NewSensor(ID string, attrs ...*attr.Attribute) *Feature {
 	s := &Feature{
 		ID:    ID,
 		Type:  FTSensor,
-		Attrs: Attrs{attribute.LocalID: attribute},
+		Attrs: NewAttrs(attrs...),
 	}
 	return s
 }
 
+const (
+	// SensorMotionLocalID is the local ID of the motion attribute on a standard sensor
+	SensorMotionLocalID string = "motion"
+
+	// SensorContactLocalID is the local ID of the contact attribute on a standard sensor
+	SensorContactLocalID string = "contact"
+
+	// SensorHumidityLocalID is the local ID of the humidity attribute on a standard sensor
+	SensorHumidityLocalID string = "humidity"
+
+	// SensorIlluminanceLocalID is the local ID of the illuminance attribute on a standard sensor
+	SensorIlluminanceLocalID string = "illuminance"
+
+	// SensorBatteryLocalID is the local ID of the battery attribute on a standard sensor
+	SensorBatteryLocalID string = "battery"
+
+	// SensorLeakLocalID is the local ID of the leak attribute on a standard sensor
+	SensorLeakLocalID string = "leak"
+
+	// SensorSmokeLocalID is the local ID of the smoke attribute on a standard sensor
+	SensorSmokeLocalID string = "smoke"
+)
+
+// sensorLocalIDs maps the standard sensor attribute types to their local IDs
+var sensorLocalIDs = map[string]string{
+	attr.ATMotion:      SensorMotionLocalID,
+	attr.ATContact:     SensorContactLocalID,
+	attr.ATHumidity:    SensorHumidityLocalID,
+	attr.ATIlluminance: SensorIlluminanceLocalID,
+	attr.ATBattery:     SensorBatteryLocalID,
+	attr.ATLeak:        SensorLeakLocalID,
+	attr.ATSmoke:       SensorSmokeLocalID,
+}
+
+// NewSensorFromTypes returns a new sensor, with one attribute for each of the specified attribute
+// types e.g. attr.ATMotion, attr.ATBattery. The attributes use the standard sensor local IDs such
+// as SensorMotionLocalID. An error is returned if one of the types is not a sensor attribute type
+func NewSensorFromTypes(ID string, attrTypes ...string) (*Feature, error) {
+	s := NewSensor(ID)
+	for _, attrType := range attrTypes {
+		localID, ok := sensorLocalIDs[attrType]
+		if !ok {
+			return nil, fmt.Errorf("unsupported sensor attribute type: %s", attrType)
+		}
+		s.Attrs[localID] = attr.NewSensorAttr(localID, attrType)
+	}
+	return s, nil
+}
+
+// SensorCloneAttr clones the first attribute on the sensor matching the attribute type so it can
+// be updated, nil if the sensor doesn't have an attribute of that type
+func SensorCloneAttr(f *Feature, attrType string) *attr.Attribute {
+	attribute := f.AttrByType(attrType)
+	if attribute == nil {
+		return nil
+	}
+	return attribute.Clone()
+}
+
 const (
 	// HeatZoneCurrentTempLocalID is the localID value for the current temp attribute
 	HeatZoneCurrentTempLocalID string = "currenttemp"
@@ -444,3 +529,103 @@ func OutletCloneAttrs(f *Feature) (onOff *attr.Attribute) {
 	}
 	return
 }
+
+const (
+	// FanOnOffLocalID is the local ID of the onoff attribute
+	FanOnOffLocalID string = "onoff"
+
+	// FanSpeedLocalID is the local ID of the preset speed attribute
+	FanSpeedLocalID string = "speed"
+
+	// FanSpeedPercentLocalID is the local ID of the speed percentage attribute
+	FanSpeedPercentLocalID string = "speedpercent"
+
+	// FanDirectionLocalID is the local ID of the direction attribute
+	FanDirectionLocalID string = "direction"
+)
+
+// NewFan returns a new feature initialized as a Fan. A fan can be turned on and off, set to one
+// of the preset speeds or a percentage of its max speed, and change the direction it spins
+func NewFan(ID string) *Feature {
+	f := &Feature{
+		ID:   ID,
+		Type: FTFan,
+	}
+	onOff := attr.NewOnOff(FanOnOffLocalID, nil)
+	onOff.Name = "On/Off"
+	speed := attr.NewFanSpeed(FanSpeedLocalID, nil)
+	speed.Name = "Speed"
+	speedPercent := attr.NewSpeed(FanSpeedPercentLocalID, nil)
+	speedPercent.Name = "Speed %"
+	direction := attr.NewFanDirection(FanDirectionLocalID, nil)
+	direction.Name = "Direction"
+	f.Attrs = NewAttrs(onOff, speed, speedPercent, direction)
+	return f
+}
+
+// FanCloneAttrs clone the common attributes for a fan so they can be updated
+func FanCloneAttrs(f *Feature) (onOff, speed, speedPercent, direction *attr.Attribute) {
+	var ok bool
+	if onOff, ok = f.Attrs[FanOnOffLocalID]; ok {
+		onOff = onOff.Clone()
+	}
+
+	if speed, ok = f.Attrs[FanSpeedLocalID]; ok {
+		speed = speed.Clone()
+	}
+
+	if speedPercent, ok = f.Attrs[FanSpeedPercentLocalID]; ok {
+		speedPercent = speedPercent.Clone()
+	}
+
+	if direction, ok = f.Attrs[FanDirectionLocalID]; ok {
+		direction = direction.Clone()
+	}
+	return
+}
+
+const (
+	// GarageDoorStateLocalID is the local ID of the door state attribute
+	GarageDoorStateLocalID string = "state"
+
+	// GarageDoorOpenCloseLocalID is the local ID of the openclose attribute
+	GarageDoorOpenCloseLocalID string = "openclose"
+
+	// GarageDoorObstructionLocalID is the local ID of the obstruction attribute
+	GarageDoorObstructionLocalID string = "obstruction"
+)
+
+// NewGarageDoor returns a new feature initialized as a GarageDoor. The state attribute is read only
+// and reports if the door is open, closed, opening, closing or stopped, to move the door set the
+// openclose attribute. The obstruction attribute reports if something is blocking the door
+func NewGarageDoor(ID string) *Feature {
+	f := &Feature{
+		ID:   ID,
+		Type: FTGarageDoor,
+	}
+	state := attr.NewDoorState(GarageDoorStateLocalID, nil)
+	state.Name = "State"
+	openClose := attr.NewOpenClose(GarageDoorOpenCloseLocalID, nil)
+	openClose.Name = "Open/Close"
+	obstruction := attr.NewObstruction(GarageDoorObstructionLocalID, nil)
+	obstruction.Name = "Obstruction"
+	f.Attrs = NewAttrs(state, openClose, obstruction)
+	return f
+}
+
+// GarageDoorCloneAttrs clone the common attributes for a garage door so they can be updated
+func GarageDoorCloneAttrs(f *Feature) (state, openClose, obstruction *attr.Attribute) {
+	var ok bool
+	if state, ok = f.Attrs[GarageDoorStateLocalID]; ok {
+		state = state.Clone()
+	}
+
+	if openClose, ok = f.Attrs[GarageDoorOpenCloseLocalID]; ok {
+		openClose = openClose.Clone()
+	}
+
+	if obstruction, ok = f.Attrs[GarageDoorObstructionLocalID]; ok {
+		obstruction = obstruction.Clone()
+	}
+	return
+}
@@ -1,8 +1,12 @@
 package gohome
 
-import "github.com/markdaws/gohome/pkg/feature"
+import (
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/validation"
+)
 
-// Area represents a physical space e.g. Bathroom, garden etc
+// Area represents a physical space e.g. Bathroom, garden etc. Areas can contain other areas, for
+// example a floor might contain several rooms, and a feature can be assigned to one area
 type Area struct {
 	ID          string
 	Name        string
@@ -12,10 +16,88 @@ type Area struct {
 	Features    []*feature.Feature
 }
 
+// AddArea adds a child area, if the area already has a parent it is removed from that parent first
 func (a *Area) AddArea(area *Area) {
-	//TODO:
+	if area.Parent == a {
+		return
+	}
+	if area.Parent != nil {
+		area.Parent.RemoveArea(area)
+	}
+	area.Parent = a
+	a.Areas = append(a.Areas, area)
 }
 
+// RemoveArea removes the child area
+func (a *Area) RemoveArea(area *Area) {
+	for i, child := range a.Areas {
+		if child == area {
+			a.Areas = append(a.Areas[:i], a.Areas[i+1:]...)
+			area.Parent = nil
+			return
+		}
+	}
+}
+
+// AddFeature adds the feature to the area, if the area already contains the feature this is a no-op
 func (a *Area) AddFeature(f *feature.Feature) {
-	//TODO:
+	if a.HasFeature(f.ID) {
+		return
+	}
+	a.Features = append(a.Features, f)
+}
+
+// RemoveFeature removes the feature from the area
+func (a *Area) RemoveFeature(f *feature.Feature) {
+	for i, af := range a.Features {
+		if af.ID == f.ID {
+			a.Features = append(a.Features[:i], a.Features[i+1:]...)
+			return
+		}
+	}
+}
+
+// HasFeature returns true if the feature is directly inside this area
+func (a *Area) HasFeature(featureID string) bool {
+	for _, f := range a.Features {
+		if f.ID == featureID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllFeatures returns all of the features in the area and all of its child areas. Areas in a
+// system can be changed by other goroutines, so use System.AreaFeatures instead
+func (a *Area) AllFeatures() []*feature.Feature {
+	features := make([]*feature.Feature, 0, len(a.Features))
+	features = append(features, a.Features...)
+	for _, child := range a.Areas {
+		features = append(features, child.AllFeatures()...)
+	}
+	return features
+}
+
+// IsDescendantOf returns true if the area is somewhere below the ancestor in the area hierarchy
+func (a *Area) IsDescendantOf(ancestor *Area) bool {
+	for p := a.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate verifies the area is in a good state
+func (a *Area) Validate() *validation.Errors {
+	errors := &validation.Errors{}
+
+	if a.Name == "" {
+		errors.Add("required field", "Name")
+	}
+
+	if errors.Has() {
+		return errors
+	}
+	return nil
 }
@@ -0,0 +1,68 @@
+package gohome_test
+
+import (
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemUpdateArea(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("areas")
+	floor := &gohome.Area{ID: "floor", Name: "Ground floor"}
+	kitchen := &gohome.Area{ID: "kitchen", Name: "Kitchen"}
+	require.Nil(t, sys.AddArea(floor, nil))
+	require.Nil(t, sys.AddArea(kitchen, floor))
+
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	sys.AddFeature(light)
+	sys.SetFeatureArea(light, kitchen)
+
+	// Nothing is changed if the area can't be moved
+	name := "Dining room"
+	require.NotNil(t, sys.UpdateArea(floor, &name, nil, kitchen))
+	require.Equal(t, "Ground floor", floor.Name)
+	require.Equal(t, sys.Area, floor.Parent)
+
+	empty := ""
+	require.NotNil(t, sys.UpdateArea(kitchen, &empty, nil, sys.Area))
+	require.Equal(t, floor, kitchen.Parent)
+
+	description := "open plan"
+	require.Nil(t, sys.UpdateArea(kitchen, &name, &description, sys.Area))
+	require.Equal(t, "Dining room", kitchen.Name)
+	require.Equal(t, "open plan", kitchen.Description)
+	require.Equal(t, sys.Area, kitchen.Parent)
+
+	require.Equal(t, 0, len(sys.AreaFeatures(floor, true)))
+	require.Equal(t, []*feature.Feature{light}, sys.AreaFeatures(sys.Area, true))
+	require.Equal(t, 0, len(sys.AreaFeatures(sys.Area, false)))
+}
+
+func TestSystemDeleteDeviceRemovesFeaturesFromAreas(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("areas")
+	kitchen := &gohome.Area{ID: "kitchen", Name: "Kitchen"}
+	require.Nil(t, sys.AddArea(kitchen, nil))
+
+	dev := gohome.NewDevice("dev1", "dimmer", "", "", "", "", "1", nil, nil, nil, nil)
+	sys.AddDevice(dev)
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	light.DeviceID = dev.ID
+	dev.AddFeature(light)
+	sys.AddFeature(light)
+	sys.SetFeatureArea(light, kitchen)
+
+	other := feature.NewLightZone("light2", feature.LightZoneModeBinary)
+	sys.AddFeature(other)
+	sys.SetFeatureArea(other, kitchen)
+
+	sys.DeleteDevice(dev)
+	require.Nil(t, sys.DeviceByID(dev.ID))
+	require.Nil(t, sys.FeatureByID(light.ID))
+	require.Equal(t, []*feature.Feature{other}, sys.AreaFeatures(kitchen, false))
+}
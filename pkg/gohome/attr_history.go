@@ -0,0 +1,499 @@
+package gohome
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-home-iot/event-bus"
+	"github.com/markdaws/gohome/pkg/clock"
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+const (
+	// HistoryRollupInterval is the size of the buckets raw attribute values are averaged
+	// in to once they are older than the raw retention period
+	HistoryRollupInterval = time.Minute * 5
+
+	// DefaultHistoryRawRetention is how long raw values are kept by default
+	DefaultHistoryRawRetention = time.Hour * 24 * 7
+
+	// DefaultHistoryRollupRetention is how long the 5 minute averages are kept by default
+	DefaultHistoryRollupRetention = time.Hour * 24 * 365
+
+	historyDateFormat     = "2006-01-02"
+	historyRawPrefix      = "raw-"
+	historyRollupPrefix   = "5m-"
+	historyFileExt        = ".jsonl"
+	historyMaintainPeriod = time.Hour
+)
+
+// ErrInvalidHistoryQuery is returned when the parameters passed to AttrHistory.Query are not valid
+var ErrInvalidHistoryQuery = errors.New("invalid history query")
+
+// HistoryPoint is a single value in the history of an attribute. For raw values Min and Max are
+// the same as Value, for downsampled values Value is the average of all the values in the bucket
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+}
+
+// historyRecord is how a single point is written to disk, one JSON object per line
+type historyRecord struct {
+	LocalID string  `json:"a"`
+	Time    int64   `json:"t"`
+	Value   float64 `json:"v"`
+	Min     float64 `json:"min,omitempty"`
+	Max     float64 `json:"max,omitempty"`
+	Count   int     `json:"n,omitempty"`
+}
+
+// AttrHistory consumes FeatureAttrsChangedEvt events from the event bus and stores the numeric
+// attribute values so they can be queried over time e.g. to chart temperature or brightness.
+//
+// Values are stored in a directory per feature, with one file per day.  Raw values are kept for
+// RawRetention, days older than that are downsampled to 5 minute averages which are kept for
+// RollupRetention. Only numeric and bool attributes are recorded, other types are ignored.
+type AttrHistory struct {
+	// Path is the directory where the history files are saved
+	Path string
+
+	// RawRetention is how long the raw values are kept before only the 5 minute averages
+	// are available
+	RawRetention time.Duration
+
+	// RollupRetention is how long the 5 minute averages are kept
+	RollupRetention time.Duration
+
+	// Time is the source of the current time, used to timestamp values and expire old data
+	Time clock.Time
+
+	mutex sync.RWMutex
+	done  chan bool
+}
+
+// NewAttrHistory returns a new AttrHistory instance. If the retention values are zero the
+// default values are used
+func NewAttrHistory(path string, rawRetention, rollupRetention time.Duration) *AttrHistory {
+	if rawRetention <= 0 {
+		rawRetention = DefaultHistoryRawRetention
+	}
+	if rollupRetention <= 0 {
+		rollupRetention = DefaultHistoryRollupRetention
+	}
+	return &AttrHistory{
+		Path:            path,
+		RawRetention:    rawRetention,
+		RollupRetention: rollupRetention,
+		Time:            clock.SystemTime{},
+	}
+}
+
+func (h *AttrHistory) ConsumerName() string {
+	return "AttrHistory"
+}
+
+func (h *AttrHistory) StartConsuming(ch chan evtbus.Event) {
+	log.V("AttrHistory - start consuming events")
+
+	h.done = make(chan bool)
+	go func() {
+		for {
+			if err := h.Maintain(); err != nil {
+				log.E("AttrHistory - failed to downsample/expire history: %s", err)
+			}
+
+			select {
+			case <-h.done:
+				return
+			case <-h.Time.After(historyMaintainPeriod):
+			}
+		}
+	}()
+
+	go func() {
+		for e := range ch {
+			evt, ok := e.(*FeatureAttrsChangedEvt)
+			if !ok {
+				continue
+			}
+
+			values := make(map[string]float64)
+			for localID, attribute := range evt.Attrs {
+				if val, ok := historyValue(attribute.Value); ok {
+					values[localID] = val
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+
+			if err := h.Record(evt.FeatureID, h.Time.Now(), values); err != nil {
+				log.E("AttrHistory - failed to record values for feature %s: %s", evt.FeatureID, err)
+			}
+		}
+		log.V("AttrHistory - event channel has closed")
+	}()
+}
+
+func (h *AttrHistory) StopConsuming() {
+	log.V("AttrHistory - stop consuming events")
+	if h.done != nil {
+		close(h.done)
+		h.done = nil
+	}
+}
+
+// Record saves the values of the attributes of a feature at the specified time, the values
+// map is keyed by the attribute local IDs
+func (h *AttrHistory) Record(featureID string, t time.Time, values map[string]float64) error {
+	dir, err := h.featureDir(featureID)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+
+	t = t.UTC()
+	path := filepath.Join(dir, historyRawPrefix+t.Format(historyDateFormat)+historyFileExt)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Write in a consistent order so the files are easier to read
+	localIDs := make([]string, 0, len(values))
+	for localID := range values {
+		localIDs = append(localIDs, localID)
+	}
+	sort.Strings(localIDs)
+
+	enc := json.NewEncoder(f)
+	for _, localID := range localIDs {
+		err := enc.Encode(historyRecord{
+			LocalID: localID,
+			Time:    t.UnixNano() / int64(time.Millisecond),
+			Value:   values[localID],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the values of the attribute between from and to inclusive, ordered by time. If
+// step is greater than zero the values are averaged in to buckets of that size, starting at from.
+// Where the raw values are no longer available, the 5 minute averages are returned instead.
+func (h *AttrHistory) Query(featureID, localID string, from, to time.Time, step time.Duration) ([]HistoryPoint, error) {
+	if localID == "" || to.Before(from) || step < 0 {
+		return nil, ErrInvalidHistoryQuery
+	}
+
+	dir, err := h.featureDir(featureID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	fromMs := from.UnixNano() / int64(time.Millisecond)
+	toMs := to.UnixNano() / int64(time.Millisecond)
+
+	points := []HistoryPoint{}
+	for day := truncateDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(historyDateFormat)
+
+		// Prefer the raw values, only if they have expired fall back to the averages
+		records, err := readHistoryFile(filepath.Join(dir, historyRawPrefix+date+historyFileExt))
+		if os.IsNotExist(err) {
+			records, err = readHistoryFile(filepath.Join(dir, historyRollupPrefix+date+historyFileExt))
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			if r.LocalID != localID || r.Time < fromMs || r.Time > toMs {
+				continue
+			}
+			points = append(points, r.point())
+		}
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	if step == 0 {
+		return points, nil
+	}
+	return downsample(points, from, step), nil
+}
+
+// Maintain downsamples all complete days of raw values to 5 minute averages, then removes any
+// values that are older than the retention periods. This is called periodically while the
+// AttrHistory is consuming events, it only needs to be called manually if you are not
+// consuming events
+func (h *AttrHistory) Maintain() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	dirs, err := ioutil.ReadDir(h.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := h.Time.Now().UTC()
+	today := now.Format(historyDateFormat)
+	rawExpires := now.Add(-h.RawRetention)
+	rollupExpires := now.Add(-h.RollupRetention)
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(h.Path, d.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			if !strings.HasSuffix(name, historyFileExt) {
+				continue
+			}
+
+			var prefix string
+			var expires time.Time
+			switch {
+			case strings.HasPrefix(name, historyRawPrefix):
+				prefix, expires = historyRawPrefix, rawExpires
+			case strings.HasPrefix(name, historyRollupPrefix):
+				prefix, expires = historyRollupPrefix, rollupExpires
+			default:
+				continue
+			}
+
+			date := strings.TrimSuffix(strings.TrimPrefix(name, prefix), historyFileExt)
+			day, err := time.Parse(historyDateFormat, date)
+			if err != nil {
+				continue
+			}
+
+			if prefix == historyRawPrefix && date != today {
+				if err := rollupHistoryFile(dir, date); err != nil {
+					return err
+				}
+			}
+
+			// Only remove a file once the whole day is older than the retention period
+			if day.AddDate(0, 0, 1).Before(expires) {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// featureDir returns the directory the history for a feature is stored in
+func (h *AttrHistory) featureDir(featureID string) (string, error) {
+	if featureID == "" || featureID == "." || featureID == ".." ||
+		strings.ContainsAny(featureID, `/\`) {
+		return "", fmt.Errorf("invalid feature ID: %s", featureID)
+	}
+	return filepath.Join(h.Path, featureID), nil
+}
+
+// rollupHistoryFile creates the 5 minute averages for the day from the raw values, if the
+// averages have already been created the function does nothing
+func rollupHistoryFile(dir, date string) error {
+	rollupPath := filepath.Join(dir, historyRollupPrefix+date+historyFileExt)
+	if _, err := os.Stat(rollupPath); err == nil {
+		return nil
+	}
+
+	records, err := readHistoryFile(filepath.Join(dir, historyRawPrefix+date+historyFileExt))
+	if err != nil {
+		return err
+	}
+
+	type bucketKey struct {
+		localID string
+		start   int64
+	}
+	buckets := make(map[bucketKey]*historyRecord)
+	var keys []bucketKey
+
+	intervalMs := int64(HistoryRollupInterval / time.Millisecond)
+	for _, r := range records {
+		key := bucketKey{localID: r.LocalID, start: r.Time - r.Time%intervalMs}
+		b, ok := buckets[key]
+		if !ok {
+			b = &historyRecord{
+				LocalID: r.LocalID,
+				Time:    key.start,
+				Min:     r.Value,
+				Max:     r.Value,
+			}
+			buckets[key] = b
+			keys = append(keys, key)
+		}
+		b.Value += r.Value
+		b.Min = math.Min(b.Min, r.Value)
+		b.Max = math.Max(b.Max, r.Value)
+		b.Count++
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].start == keys[j].start {
+			return keys[i].localID < keys[j].localID
+		}
+		return keys[i].start < keys[j].start
+	})
+
+	// Write to a temp file then rename, so a crash never leaves a partial file which would
+	// stop the day from being downsampled again
+	tmpPath := rollupPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		b := buckets[key]
+		b.Value = b.Value / float64(b.Count)
+		if err := enc.Encode(b); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, rollupPath)
+}
+
+// readHistoryFile reads all of the records from a history file, lines that cannot be parsed,
+// such as a partial line written before a crash, are skipped
+func readHistoryFile(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// point converts a record read from disk to a HistoryPoint
+func (r historyRecord) point() HistoryPoint {
+	p := HistoryPoint{
+		Time:  time.Unix(0, r.Time*int64(time.Millisecond)).UTC(),
+		Value: r.Value,
+		Min:   r.Value,
+		Max:   r.Value,
+	}
+	if r.Count > 0 {
+		p.Min = r.Min
+		p.Max = r.Max
+	}
+	return p
+}
+
+// downsample averages the points in to buckets of size step, starting at from. Empty buckets
+// are not returned. The points must be ordered by time
+func downsample(points []HistoryPoint, from time.Time, step time.Duration) []HistoryPoint {
+	out := []HistoryPoint{}
+	var current *HistoryPoint
+	var count int
+	for _, p := range points {
+		start := from.Add(p.Time.Sub(from) / step * step).UTC()
+		if current == nil || !current.Time.Equal(start) {
+			if current != nil {
+				current.Value /= float64(count)
+				out = append(out, *current)
+			}
+			current = &HistoryPoint{Time: start, Min: p.Min, Max: p.Max}
+			count = 0
+		}
+		current.Value += p.Value
+		current.Min = math.Min(current.Min, p.Min)
+		current.Max = math.Max(current.Max, p.Max)
+		count++
+	}
+	if current != nil {
+		current.Value /= float64(count)
+		out = append(out, *current)
+	}
+	return out
+}
+
+// truncateDay returns midnight UTC of the day containing t
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// historyValue converts an attribute value to a float64 so it can be recorded, returns false
+// if the value is not a type that can be recorded
+func historyValue(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int32:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,96 @@
+package gohome_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistory(t *testing.T, now time.Time) (*gohome.AttrHistory, func()) {
+	dir, err := ioutil.TempDir("", "gohome-history")
+	require.Nil(t, err)
+
+	h := gohome.NewAttrHistory(dir, 0, 0)
+	h.Time = MockTime{now: now}
+	return h, func() { os.RemoveAll(dir) }
+}
+
+func TestAttrHistoryQuery(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	h, cleanup := newTestHistory(t, start)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		err := h.Record("f1", start.Add(time.Duration(i)*time.Minute), map[string]float64{
+			"currenttemp": float64(60 + i),
+			"targettemp":  70,
+		})
+		require.Nil(t, err)
+	}
+
+	points, err := h.Query("f1", "currenttemp", start, start.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(points))
+	require.Equal(t, float64(60), points[0].Value)
+	require.Equal(t, float64(63), points[3].Value)
+	require.True(t, points[3].Time.Equal(start.Add(3*time.Minute)))
+
+	// Outside of the range
+	points, err = h.Query("f1", "currenttemp", start.Add(time.Hour), start.Add(2*time.Hour), 0)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(points))
+
+	// Averaged in to 2 minute buckets
+	points, err = h.Query("f1", "currenttemp", start, start.Add(time.Hour), 2*time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(points))
+	require.Equal(t, 60.5, points[0].Value)
+	require.Equal(t, float64(60), points[0].Min)
+	require.Equal(t, float64(61), points[0].Max)
+	require.Equal(t, 62.5, points[1].Value)
+
+	_, err = h.Query("../f1", "currenttemp", start, start.Add(time.Hour), 0)
+	require.NotNil(t, err)
+}
+
+func TestAttrHistoryMaintain(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	h, cleanup := newTestHistory(t, day.AddDate(0, 0, 1))
+	defer cleanup()
+
+	require.Nil(t, h.Record("f1", day, map[string]float64{"brightness": 10}))
+	require.Nil(t, h.Record("f1", day.Add(time.Minute), map[string]float64{"brightness": 20}))
+	require.Nil(t, h.Record("f1", day.Add(10*time.Minute), map[string]float64{"brightness": 50}))
+
+	// Day is still inside the raw retention, raw values are returned
+	require.Nil(t, h.Maintain())
+	points, err := h.Query("f1", "brightness", day, day.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(points))
+
+	// Once the raw values expire, the 5 minute averages are returned
+	h.Time = MockTime{now: day.AddDate(0, 0, 9)}
+	require.Nil(t, h.Maintain())
+	points, err = h.Query("f1", "brightness", day, day.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(points))
+	require.Equal(t, float64(15), points[0].Value)
+	require.Equal(t, float64(10), points[0].Min)
+	require.Equal(t, float64(20), points[0].Max)
+	require.Equal(t, float64(50), points[1].Value)
+
+	// After the rollup retention everything is gone
+	h.Time = MockTime{now: day.AddDate(2, 0, 0)}
+	require.Nil(t, h.Maintain())
+	points, err = h.Query("f1", "brightness", day, day.Add(time.Hour), 0)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(points))
+}
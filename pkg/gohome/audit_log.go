@@ -0,0 +1,282 @@
+package gohome
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-home-iot/event-bus"
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/clock"
+	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+const (
+	// AuditCommand is the type of audit entry recorded when a command is run
+	AuditCommand = "command"
+
+	// AuditFeatureAttrsChanged is the type of audit entry recorded when the hardware reports that
+	// attributes were changed on the device itself, such as a keypad button being pressed
+	AuditFeatureAttrsChanged = "featureAttrsChanged"
+)
+
+// AuditEntry is a single entry in the audit log
+type AuditEntry struct {
+	// Time is when the entry was recorded
+	Time time.Time `json:"time"`
+
+	// Type is the type of entry, AuditCommand or AuditFeatureAttrsChanged
+	Type string `json:"type"`
+
+	// Origin is who or what made the change
+	Origin Origin `json:"origin"`
+
+	// Desc describes the change, for commands it is the description of the command group
+	Desc string `json:"desc,omitempty"`
+
+	// SceneID is the ID of the scene that was set, if the change was part of a scene
+	SceneID string `json:"sceneId,omitempty"`
+
+	// FeatureID is the ID of the feature that was changed
+	FeatureID string `json:"featureId,omitempty"`
+
+	// FeatureName is the name of the feature when it was changed
+	FeatureName string `json:"featureName,omitempty"`
+
+	// Values are the new attribute values keyed by attribute local ID, in the units of the feature
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// AuditQuery filters the entries returned from the audit log, zero values match all entries
+type AuditQuery struct {
+	// User matches entries made by the user with this ID or login
+	User string
+
+	// FeatureID matches entries that changed this feature
+	FeatureID string
+
+	// From matches entries recorded at or after this time
+	From time.Time
+
+	// To matches entries recorded at or before this time
+	To time.Time
+
+	// Limit is the maximum number of entries to return, the newest entries are returned
+	Limit int
+}
+
+// Match returns true if the entry matches the query
+func (q AuditQuery) Match(e AuditEntry) bool {
+	if q.User != "" && q.User != e.Origin.UserID && !strings.EqualFold(q.User, e.Origin.Login) {
+		return false
+	}
+	if q.FeatureID != "" && q.FeatureID != e.FeatureID {
+		return false
+	}
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && e.Time.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// AuditLog is an append only log of every command that is run and every change reported by the
+// hardware, along with who or what made it. It consumes FeatureAttrsChangedEvt events from the
+// event bus, commands are recorded by the command processor. The log is saved to Path as one
+// JSON entry per line, if Path is empty nothing is recorded
+type AuditLog struct {
+	// Path is the file the audit log is saved to
+	Path string
+
+	// System is used to look up the features and scenes in the entries
+	System *System
+
+	// Time is the source of the current time, used to timestamp entries
+	Time clock.Time
+
+	mutex sync.Mutex
+}
+
+// NewAuditLog returns a new AuditLog instance
+func NewAuditLog(sys *System, path string) *AuditLog {
+	return &AuditLog{
+		Path:   path,
+		System: sys,
+		Time:   clock.SystemTime{},
+	}
+}
+
+// Record appends the entries to the audit log, entries without a time are given the current time.
+// It is safe to call Record on a nil *AuditLog, nothing is recorded
+func (a *AuditLog) Record(entries ...AuditEntry) error {
+	if a == nil || a.Path == "" || len(entries) == 0 {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	f, err := os.OpenFile(a.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := a.Time.Now().UTC()
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordCommands records the commands in the command group, scenes are expanded so there is an
+// entry for each feature the scene changes
+func (a *AuditLog) RecordCommands(cg CommandGroup) error {
+	if a == nil {
+		return nil
+	}
+
+	var entries []AuditEntry
+	for _, c := range cg.Cmds {
+		entries = append(entries, auditCommand(a.System, cg, c, "", make(map[string]bool))...)
+	}
+	return a.Record(entries...)
+}
+
+// auditCommand returns the audit entries for a command, visited contains the IDs of the scenes
+// that are being expanded so scenes that include each other don't loop forever
+func auditCommand(sys *System, cg CommandGroup, c cmd.Command, sceneID string, visited map[string]bool) []AuditEntry {
+	switch command := c.(type) {
+	case *cmd.FeatureSetAttrs:
+		return []AuditEntry{{
+			Type:        AuditCommand,
+			Origin:      cg.Origin,
+			Desc:        cg.Desc,
+			SceneID:     sceneID,
+			FeatureID:   command.FeatureID,
+			FeatureName: command.FeatureName,
+			Values:      auditValues(command.Attrs),
+		}}
+
+	case *cmd.SceneSet:
+		s := sys.SceneByID(command.SceneID)
+		if s == nil || visited[s.ID] {
+			return nil
+		}
+		visited[s.ID] = true
+
+		var entries []AuditEntry
+		for _, sceneCmd := range s.Commands {
+			entries = append(entries, auditCommand(sys, cg, sceneCmd, s.ID, visited)...)
+		}
+		delete(visited, s.ID)
+		return entries
+	}
+	return nil
+}
+
+// auditValues returns the values of the attributes keyed by local ID
+func auditValues(attrs map[string]*attr.Attribute) map[string]interface{} {
+	values := make(map[string]interface{})
+	for localID, attribute := range attrs {
+		if attribute != nil {
+			values[localID] = attribute.Value
+		}
+	}
+	return values
+}
+
+// Query returns the entries in the audit log that match the query, newest first
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entries := []AuditEntry{}
+	if a.Path == "" {
+		return entries, nil
+	}
+
+	f, err := os.Open(a.Path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A crash while writing can leave a partial line, skip it rather than failing
+			continue
+		}
+		if !q.Match(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if q.Limit > 0 && len(entries) > q.Limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func (a *AuditLog) ConsumerName() string {
+	return "AuditLog"
+}
+
+func (a *AuditLog) StartConsuming(ch chan evtbus.Event) {
+	log.V("AuditLog - start consuming events")
+
+	go func() {
+		for e := range ch {
+			evt, ok := e.(*FeatureAttrsChangedEvt)
+
+			// The monitor raises its own events for changes it has already seen from the
+			// hardware, so they are ignored to avoid recording the same change twice
+			if !ok || evt.Context == MonitorContext {
+				continue
+			}
+
+			entry := AuditEntry{
+				Type:      AuditFeatureAttrsChanged,
+				Origin:    evt.Origin,
+				FeatureID: evt.FeatureID,
+				Values:    auditValues(evt.Attrs),
+			}
+			if f := a.System.FeatureByID(evt.FeatureID); f != nil {
+				entry.FeatureName = f.Name
+			}
+			if err := a.Record(entry); err != nil {
+				log.E("AuditLog - failed to record change to feature %s: %s", evt.FeatureID, err)
+			}
+		}
+		log.V("AuditLog - event channel has closed")
+	}()
+}
+
+func (a *AuditLog) StopConsuming() {
+	log.V("AuditLog - stop consuming events")
+}
@@ -0,0 +1,106 @@
+package gohome_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogRecordsAndQueriesCommands(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "gohome-audit")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	heat := &cmd.FeatureSetAttrs{
+		FeatureID:   "heat1",
+		FeatureName: "Heating",
+		Attrs: map[string]*attr.Attribute{
+			"targettemp": &attr.Attribute{LocalID: "targettemp", Value: float32(30)},
+		},
+	}
+	light := &cmd.FeatureSetAttrs{
+		FeatureID:   "light1",
+		FeatureName: "Kitchen",
+		Attrs: map[string]*attr.Attribute{
+			"brightness": &attr.Attribute{LocalID: "brightness", Value: float32(50)},
+		},
+	}
+
+	// Scenes that include each other must not loop forever
+	sys := gohome.NewSystem("test system")
+	sys.AddScene(&gohome.Scene{ID: "scene1", Name: "Evening", Commands: []cmd.Command{
+		light,
+		&cmd.SceneSet{SceneID: "scene2"},
+	}})
+	sys.AddScene(&gohome.Scene{ID: "scene2", Name: "Loop", Commands: []cmd.Command{
+		&cmd.SceneSet{SceneID: "scene1"},
+	}})
+
+	start := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	audit := gohome.NewAuditLog(sys, filepath.Join(dir, "audit.json"))
+	audit.Time = MockTime{now: start}
+
+	entries, err := audit.Query(gohome.AuditQuery{})
+	require.Nil(t, err)
+	require.Len(t, entries, 0)
+
+	cg := gohome.NewCommandGroup("FeatureSetAttrs", heat)
+	cg.Origin = gohome.Origin{UserID: "user1", Login: "Bob", TokenID: "token1"}
+	require.Nil(t, audit.RecordCommands(cg))
+
+	audit.Time = MockTime{now: start.Add(time.Hour)}
+	cg = gohome.NewCommandGroup("Evening lights", &cmd.SceneSet{SceneID: "scene1"})
+	cg.Origin = gohome.Origin{Automation: "Evening lights"}
+	require.Nil(t, audit.RecordCommands(cg))
+
+	audit.Time = MockTime{now: start.Add(2 * time.Hour)}
+	require.Nil(t, audit.Record(gohome.AuditEntry{
+		Type:      gohome.AuditFeatureAttrsChanged,
+		Origin:    gohome.Origin{Extension: "Lutron"},
+		FeatureID: "light1",
+	}))
+
+	// Newest first
+	entries, err = audit.Query(gohome.AuditQuery{})
+	require.Nil(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "Lutron", entries[0].Origin.Extension)
+	require.Equal(t, "scene1", entries[1].SceneID)
+	require.Equal(t, "light1", entries[1].FeatureID)
+	require.Equal(t, "Evening lights", entries[1].Origin.Automation)
+	require.Equal(t, "heat1", entries[2].FeatureID)
+	require.Equal(t, 30.0, entries[2].Values["targettemp"])
+	require.Equal(t, start, entries[2].Time)
+
+	// The user can be matched by ID or login
+	entries, err = audit.Query(gohome.AuditQuery{User: "bob"})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "token1", entries[0].Origin.TokenID)
+	entries, err = audit.Query(gohome.AuditQuery{User: "user1"})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+
+	entries, err = audit.Query(gohome.AuditQuery{FeatureID: "light1"})
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+
+	entries, err = audit.Query(gohome.AuditQuery{FeatureID: "light1", Limit: 1})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, gohome.AuditFeatureAttrsChanged, entries[0].Type)
+
+	entries, err = audit.Query(gohome.AuditQuery{From: start.Add(30 * time.Minute), To: start.Add(90 * time.Minute)})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "Evening lights", entries[0].Desc)
+}
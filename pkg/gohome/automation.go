@@ -3,6 +3,7 @@ package gohome
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"time"
 
@@ -156,7 +157,16 @@ type automationIntermediate struct {
 			Condition *condition `yaml:"condition"`
 			Count     int        `yaml:"count"`
 			Duration  int        `yaml:"duration"`
+
+			// Shortcuts for sensors, instead of specifying a condition
+			Motion  *string `yaml:"motion"`
+			Contact *string `yaml:"contact"`
+			Leak    *string `yaml:"leak"`
+			Smoke   *string `yaml:"smoke"`
 		} `yaml:"feature"`
+		UserLockedOut *struct {
+			Login string `yaml:"login"`
+		} `yaml:"user_locked_out"`
 	} `yaml:"trigger"`
 	Actions []struct {
 		Scene *struct {
@@ -185,10 +195,23 @@ type automationIntermediate struct {
 			Offset     *float64 `yaml:"offset"`
 		} `yaml:"window_treatment"`
 		HeatZone *struct {
-			ID         *string  `yaml:"id"`
-			AID        *string  `yaml:"aid"`
-			TargetTemp *float64 `yaml:"target_temp"`
+			ID         *string `yaml:"id"`
+			AID        *string `yaml:"aid"`
+			TargetTemp *string `yaml:"target_temp"`
 		} `yaml:"heat_zone"`
+		Fan *struct {
+			ID           *string  `yaml:"id"`
+			AID          *string  `yaml:"aid"`
+			OnOff        *string  `yaml:"on_off"`
+			Speed        *string  `yaml:"speed"`
+			SpeedPercent *float64 `yaml:"speed_percent"`
+			Direction    *string  `yaml:"direction"`
+		} `yaml:"fan"`
+		GarageDoor *struct {
+			ID         *string `yaml:"id"`
+			AID        *string `yaml:"aid"`
+			OpenClosed *string `yaml:"open_closed"`
+		} `yaml:"garage_door"`
 	} `yaml:"actions"`
 }
 
@@ -295,7 +318,7 @@ func NewAutomation(sys automationSys, config string) (*Automation, error) {
 
 func parseActions(sys automationSys, auto automationIntermediate) (*CommandGroup, error) {
 
-	cmdGroup := CommandGroup{Desc: auto.Name}
+	cmdGroup := CommandGroup{Desc: auto.Name, Origin: Origin{Automation: auto.Name}}
 
 	for _, action := range auto.Actions {
 		if action.Scene != nil {
@@ -440,11 +463,74 @@ func parseActions(sys automationSys, auto automationIntermediate) (*CommandGroup
 				}
 				cmdGroup.Cmds = append(cmdGroup.Cmds, command)
 			}
+		} else if action.Fan != nil {
+			if action.Fan.ID == nil && action.Fan.AID == nil {
+				fans := sys.FeaturesByType(feature.FTFan)
+				if len(fans) == 0 {
+					continue
+				}
+
+				for _, fan := range fans {
+					command := buildFanCommand(fan, action.Fan.OnOff, action.Fan.Speed, action.Fan.SpeedPercent, action.Fan.Direction)
+					if command == nil {
+						continue
+					}
+					cmdGroup.Cmds = append(cmdGroup.Cmds, command)
+				}
+			} else {
+				fan, err := getFeature(sys, action.Fan.ID, action.Fan.AID)
+				if err != nil {
+					return nil, err
+				}
+				command := buildFanCommand(fan, action.Fan.OnOff, action.Fan.Speed, action.Fan.SpeedPercent, action.Fan.Direction)
+				if command == nil {
+					continue
+				}
+				cmdGroup.Cmds = append(cmdGroup.Cmds, command)
+			}
+		} else if action.GarageDoor != nil {
+			if action.GarageDoor.ID == nil && action.GarageDoor.AID == nil {
+				doors := sys.FeaturesByType(feature.FTGarageDoor)
+				if len(doors) == 0 {
+					continue
+				}
+
+				for _, door := range doors {
+					command := buildGarageDoorCommand(door, action.GarageDoor.OpenClosed)
+					if command == nil {
+						continue
+					}
+					cmdGroup.Cmds = append(cmdGroup.Cmds, command)
+				}
+			} else {
+				door, err := getFeature(sys, action.GarageDoor.ID, action.GarageDoor.AID)
+				if err != nil {
+					return nil, err
+				}
+				command := buildGarageDoorCommand(door, action.GarageDoor.OpenClosed)
+				if command == nil {
+					continue
+				}
+				cmdGroup.Cmds = append(cmdGroup.Cmds, command)
+			}
 		} else {
 			return nil, fmt.Errorf("unsupported action type")
 		}
 	}
 
+	// Make sure all of the values are valid for the features, values outside of the allowed range
+	// are clamped, but trying to set read only attributes or values of the wrong type is an error
+	for _, c := range cmdGroup.Cmds {
+		setAttrs, ok := c.(*cmd.FeatureSetAttrs)
+		if !ok {
+			continue
+		}
+
+		if valErrs := setAttrs.Validate(sys.FeatureByID(setAttrs.FeatureID), true); valErrs != nil {
+			return nil, fmt.Errorf("invalid action for feature %s: %s", setAttrs.FeatureID, valErrs.Errors[0].Error())
+		}
+	}
+
 	return &cmdGroup, nil
 }
 
@@ -498,14 +584,25 @@ func buildOutletCommand(outlet *feature.Feature, onOffVal *string) cmd.Command {
 	}
 }
 
-func buildHeatZoneCommand(hz *feature.Feature, targetTempVal *float64) cmd.Command {
+func buildHeatZoneCommand(hz *feature.Feature, targetTempVal *string) cmd.Command {
 	if targetTempVal == nil {
 		log.V("missing target_temp field on heat zone: %s", hz.ID)
 		return nil
 	}
 
+	// Temperatures can be specified as 70F or 21C, if there is no unit the value is
+	// assumed to be in the same unit as the heat zone
+	temp, unit, err := attr.ParseTemp(*targetTempVal)
+	if err != nil {
+		log.V("invalid target_temp field on heat zone: %s, %s", hz.ID, err)
+		return nil
+	}
+
 	_, targetTemp := feature.HeatZoneCloneAttrs(hz)
-	targetTemp.Value = int32(*targetTempVal)
+	if unit != "" {
+		targetTemp.Unit = unit
+	}
+	targetTemp.Value = float32(temp)
 
 	return &cmd.FeatureSetAttrs{
 		FeatureID:   hz.ID,
@@ -563,7 +660,8 @@ func buildLightZoneCommand(zn *feature.Feature, onOffVal *string, brightnessVal
 		onoff = nil
 	}
 
-	if brightnessVal != nil {
+	// Lights that can't be dimmed don't have a brightness attribute, just ignore the value
+	if brightnessVal != nil && brightness != nil {
 		brightness.Value = float32(*brightnessVal)
 	} else {
 		brightness = nil
@@ -606,6 +704,105 @@ func buildWindowTreatmentCommand(wt *feature.Feature, openClosedVal *string, off
 	}
 }
 
+func buildFanCommand(fan *feature.Feature, onOffVal, speedVal *string, speedPercentVal *float64, directionVal *string) cmd.Command {
+	onoff, speed, speedPercent, direction := feature.FanCloneAttrs(fan)
+
+	// NOTE: If we get an error we just log it an move on, since we want to try to execute as much
+	// of the automation as possible even if one parts fails.
+
+	if onOffVal != nil && onoff != nil {
+		switch *onOffVal {
+		case "on":
+			onoff.Value = attr.OnOffOn
+		case "off":
+			onoff.Value = attr.OnOffOff
+		default:
+			log.V("unsupported value for on_off, must be either [on|off], fan ID: %s, %s", fan.ID, *onOffVal)
+			onoff = nil
+		}
+	} else {
+		onoff = nil
+	}
+
+	if speedVal != nil && speed != nil {
+		switch *speedVal {
+		case "off":
+			speed.Value = attr.FanSpeedOff
+		case "low":
+			speed.Value = attr.FanSpeedLow
+		case "medium":
+			speed.Value = attr.FanSpeedMedium
+		case "high":
+			speed.Value = attr.FanSpeedHigh
+		default:
+			log.V("unsupported value for speed, must be one of [off|low|medium|high], fan ID: %s, %s", fan.ID, *speedVal)
+			speed = nil
+		}
+	} else {
+		speed = nil
+	}
+
+	if speedPercentVal != nil && speedPercent != nil {
+		speedPercent.Value = float32(*speedPercentVal)
+	} else {
+		speedPercent = nil
+	}
+
+	if directionVal != nil && direction != nil {
+		switch *directionVal {
+		case "forward":
+			direction.Value = attr.FanDirectionForward
+		case "reverse":
+			direction.Value = attr.FanDirectionReverse
+		default:
+			log.V("unsupported value for direction, must be either [forward|reverse], fan ID: %s, %s", fan.ID, *directionVal)
+			direction = nil
+		}
+	} else {
+		direction = nil
+	}
+
+	if onoff == nil && speed == nil && speedPercent == nil && direction == nil {
+		return nil
+	}
+
+	return &cmd.FeatureSetAttrs{
+		FeatureID:   fan.ID,
+		FeatureName: fan.Name,
+		Attrs:       feature.NewAttrs(onoff, speed, speedPercent, direction),
+	}
+}
+
+func buildGarageDoorCommand(door *feature.Feature, openClosedVal *string) cmd.Command {
+	if openClosedVal == nil {
+		log.V("missing open_closed value for garage door ID: %s", door.ID)
+		return nil
+	}
+
+	_, openclosed, _ := feature.GarageDoorCloneAttrs(door)
+	if openclosed == nil {
+		log.V("garage door is missing an openclose attribute, ID: %s", door.ID)
+		return nil
+	}
+
+	switch *openClosedVal {
+	case "open":
+		openclosed.Value = attr.OpenCloseOpen
+	case "closed":
+		openclosed.Value = attr.OpenCloseClosed
+	default:
+		log.V("unsupported value for open_closed, must be either [open|closed], garage door ID: %s, %s",
+			door.ID, *openClosedVal)
+		return nil
+	}
+
+	return &cmd.FeatureSetAttrs{
+		FeatureID:   door.ID,
+		FeatureName: door.Name,
+		Attrs:       feature.NewAttrs(openclosed),
+	}
+}
+
 func parseCondition(f *feature.Feature, c *condition) error {
 	if c.AttrLocalID != nil {
 		c.feature = f
@@ -631,23 +828,84 @@ func parseCondition(f *feature.Feature, c *condition) error {
 	return nil
 }
 
+// sensorCondition builds a condition for one of the sensor trigger shortcuts, such as "motion: detected".
+// The condition is true when the first attribute on the feature of the specified type equals the value
+// mapped to val in the values parameter
+func sensorCondition(f *feature.Feature, attrType, val string, values map[string]int32) (*condition, error) {
+	attribute := f.AttrByType(attrType)
+	if attribute == nil {
+		return nil, fmt.Errorf("feature %s does not have a %s attribute", f.ID, attrType)
+	}
+
+	attrVal, ok := values[val]
+	if !ok {
+		var valid []string
+		for k := range values {
+			valid = append(valid, k)
+		}
+		sort.Strings(valid)
+		return nil, fmt.Errorf("invalid %s value: %s, must be one of [%s]", attrType, val, strings.Join(valid, "|"))
+	}
+
+	localID := attribute.LocalID
+	op := "=="
+	return &condition{
+		AttrLocalID: &localID,
+		Op:          &op,
+		Value:       int(attrVal),
+	}, nil
+}
+
 func parseTrigger(sys automationSys, auto automationIntermediate, triggered func()) (Trigger, error) {
 	if auto.Trigger.Feature != nil {
-		if auto.Trigger.Feature.Condition == nil {
-			return nil, fmt.Errorf("feature trigger missing condition key")
+		trigger := auto.Trigger.Feature
+		ft, err := getFeature(sys, trigger.ID, trigger.AID)
+		if err != nil {
+			return nil, err
 		}
 
-		ft, err := getFeature(sys, auto.Trigger.Feature.ID, auto.Trigger.Feature.AID)
+		cond := trigger.Condition
+		if cond == nil {
+			// Sensors support shortcuts e.g. motion: detected, instead of having to write the condition
+			switch {
+			case trigger.Motion != nil:
+				cond, err = sensorCondition(ft, attr.ATMotion, *trigger.Motion, map[string]int32{
+					"detected": attr.MotionDetected,
+					"clear":    attr.MotionClear,
+				})
+			case trigger.Contact != nil:
+				cond, err = sensorCondition(ft, attr.ATContact, *trigger.Contact, map[string]int32{
+					"open":   attr.ContactOpen,
+					"closed": attr.ContactClosed,
+				})
+			case trigger.Leak != nil:
+				cond, err = sensorCondition(ft, attr.ATLeak, *trigger.Leak, map[string]int32{
+					"detected": attr.LeakDetected,
+					"dry":      attr.LeakDry,
+				})
+			case trigger.Smoke != nil:
+				cond, err = sensorCondition(ft, attr.ATSmoke, *trigger.Smoke, map[string]int32{
+					"detected": attr.SmokeDetected,
+					"clear":    attr.SmokeClear,
+				})
+			default:
+				return nil, fmt.Errorf("feature trigger missing condition key")
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = parseCondition(ft, cond)
 		if err != nil {
 			return nil, err
 		}
 
-		err = parseCondition(ft, auto.Trigger.Feature.Condition)
 		return &FeatureTrigger{
-			Count:     auto.Trigger.Feature.Count,
-			Duration:  time.Duration(auto.Trigger.Feature.Duration) * time.Millisecond,
+			Count:     trigger.Count,
+			Duration:  time.Duration(trigger.Duration) * time.Millisecond,
 			Triggered: triggered,
-			Condition: auto.Trigger.Feature.Condition,
+			Condition: cond,
 		}, nil
 
 	} else if auto.Trigger.Time != nil {
@@ -667,12 +925,11 @@ func parseTrigger(sys automationSys, auto automationIntermediate, triggered func
 			// YYYY/MM/DD HH:MM:SS
 			// HH:MM:SS
 			var err error
-			zoneName, _ := time.Now().Zone()
-			at, err = time.Parse("2006/01/02 15:04:05 MST", t.At+" "+zoneName)
+			at, err = time.ParseInLocation("2006/01/02 15:04:05", t.At, time.Local)
 
 			if err != nil {
 				// try just time
-				at, err = time.Parse("15:04:05 MST", t.At+" "+zoneName)
+				at, err = time.ParseInLocation("15:04:05", t.At, time.Local)
 
 				if err != nil {
 					return nil, fmt.Errorf("invalid time input: %s, must be either HH:MM:SS or yyyy/MM/dd HH:mm:ss", t.At)
@@ -716,6 +973,11 @@ func parseTrigger(sys automationSys, auto automationIntermediate, triggered func
 			Triggered: triggered,
 		}
 		return timeTrigger, nil
+	} else if auto.Trigger.UserLockedOut != nil {
+		return &UserLockedOutTrigger{
+			Login:     auto.Trigger.UserLockedOut.Login,
+			Triggered: triggered,
+		}, nil
 	} else {
 		return nil, fmt.Errorf("unsupported trigger type")
 	}
@@ -147,11 +147,12 @@ func TestSensorTrigger(t *testing.T) {
 	config := `
 name: Test
 trigger:
-  sensor:
+  feature:
+    id: 'sensor1'
     condition:
-      attr: 'on_off'
-      op: 'eq'
-      value: 'off'
+      attr: 'openclose'
+      op: '=='
+      value: 1
 actions:
   - scene:
       id: 12345
@@ -160,12 +161,52 @@ actions:
 	sys := gohome.NewSystem("test system")
 	s1 := &gohome.Scene{ID: "12345"}
 	sys.AddScene(s1)
+	sys.AddFeature(feature.NewSensor("sensor1", attr.NewOpenClose("openclose", nil)))
 
 	auto, err := gohome.NewAutomation(sys, config)
 	require.Nil(t, err)
 	_ = auto
 }
 
+func TestUserLockedOutTrigger(t *testing.T) {
+	t.Parallel()
+
+	config := `
+name: Test
+trigger:
+  user_locked_out:
+    login: bob
+actions:
+  - scene:
+      id: 12345
+`
+
+	sys := gohome.NewSystem("test system")
+	s1 := &gohome.Scene{ID: "12345"}
+	sys.AddScene(s1)
+
+	auto, err := gohome.NewAutomation(sys, config)
+	require.Nil(t, err)
+
+	triggered := make(chan string, 2)
+	auto.Triggered = func(actions *gohome.CommandGroup) {
+		triggered <- "triggered"
+	}
+
+	ch := make(chan evtbus.Event)
+	auto.StartConsuming(ch)
+	ch <- &gohome.UserLockedOutEvt{Login: "alice"}
+	ch <- &gohome.UserLockedOutEvt{Login: "Bob"}
+	close(ch)
+
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Fatal("the trigger did not fire")
+	}
+	require.Equal(t, 0, len(triggered))
+}
+
 func TestTimeTriggerNoDate(t *testing.T) {
 	t.Parallel()
 
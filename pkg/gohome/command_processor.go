@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"runtime/debug"
+	"strings"
 
+	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/cmd"
 	"github.com/markdaws/gohome/pkg/log"
 )
@@ -13,6 +15,9 @@ import (
 type CommandGroup struct {
 	Desc string
 	Cmds []cmd.Command
+
+	// Origin is who or what asked for the commands to be run, it is recorded in the audit log
+	Origin Origin
 }
 
 // NewCommandGroup returns a CommandGroup instance with the Desc and Cmds field set
@@ -56,7 +61,7 @@ func (cp *commandProcessor) Enqueue(cg CommandGroup) error {
 		return nil
 	default:
 		err := errors.New("CommandProcessor - CommandGroup enqueue failed, CommandProcessor queue is full")
-		log.E(err.Error())
+		log.E("%s", err)
 		return err
 	}
 }
@@ -105,6 +110,10 @@ func (cp *commandProcessor) startWorker(index int) (errRet error) {
 			continue
 		}
 
+		if err := cp.system.Services.Audit.RecordCommands(cg); err != nil {
+			log.E("CommandProcessor - unable to record commands in the audit log: %s, %s", cg.Desc, err)
+		}
+
 		for _, c := range cmds {
 			log.V("CommandProcessor - executing command: %s", c)
 			err := c.Func()
@@ -156,6 +165,25 @@ func (cp *commandProcessor) buildCommand(c cmd.Command) ([]*cmd.Func, error) {
 			return nil, fmt.Errorf("unknown device ID: %s", f.DeviceID)
 		}
 
+		// Commands can be shared e.g. they are stored in scenes, so we validate a copy, this
+		// normalizes the values in to the correct data types and clamps them to the allowed
+		// ranges before they get to the extension command builders
+		validCmd := *command
+		validCmd.Attrs = make(map[string]*attr.Attribute)
+		for localID, attribute := range command.Attrs {
+			if attribute != nil {
+				attribute = attribute.Clone()
+			}
+			validCmd.Attrs[localID] = attribute
+		}
+		if valErrs := validCmd.Validate(f, true); valErrs != nil {
+			var msgs []string
+			for _, e := range valErrs.Errors {
+				msgs = append(msgs, e.Error())
+			}
+			return nil, fmt.Errorf("invalid attributes for feature %s: %s", f.ID, strings.Join(msgs, ", "))
+		}
+
 		hub := d.Hub
 		if hub == nil {
 			hub = d
@@ -164,7 +192,7 @@ func (cp *commandProcessor) buildCommand(c cmd.Command) ([]*cmd.Func, error) {
 		var zCmd *cmd.Func
 		var err error
 		if hub.CmdBuilder != nil {
-			zCmd, err = hub.CmdBuilder.Build(command)
+			zCmd, err = hub.CmdBuilder.Build(&validCmd)
 			if err != nil {
 				return nil, err
 			}
@@ -5,8 +5,10 @@ import (
 	"sync"
 	"testing"
 
-	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,44 +22,46 @@ func (b *mockBuilder) Build(c cmd.Command) (*cmd.Func, error) {
 			fmt.Println("exec mock func")
 			b.WaitGroup.Done()
 			panic("panic worker")
-			return nil
 		},
 		Friendly: "mock func",
 	}, nil
 }
-func (b *mockBuilder) ID() string {
-	return "mockBuilder"
-}
-
-func makeTestSystem(cp gohome.CommandProcessor, b *mockBuilder) *gohome.System {
-	s := gohome.NewSystem("mock system", "", cp, 1)
 
-	s.Extensions.CmdBuilders["mock"] = b
+func makeTestSystem(b *mockBuilder) *gohome.System {
+	s := gohome.NewSystem("mock system")
 
-	d, _ := gohome.NewDevice("abcd", "", "1", "mock dev", "", nil, false, b, nil, nil)
-	s.AddDevice(*d)
+	d := gohome.NewDevice("abcd", "mock dev", "", "", "", "", "1", nil, b, nil, nil)
+	s.AddDevice(d)
 
-	z := &zone.Zone{Name: "z1", Address: "1", ID: "z1", DeviceID: d.ID}
-	d.AddZone(z)
-	s.AddZone(z)
+	z := feature.NewLightZone("z1", feature.LightZoneModeBinary)
+	z.DeviceID = d.ID
+	d.AddFeature(z)
+	s.AddFeature(z)
 	return s
 }
 
-func TestWorkersShouldRestartAfterPanic(t *testing.T) {
-	cp := gohome.NewCommandProcessor(2, 100)
+func turnOn(featureID string) *cmd.FeatureSetAttrs {
+	onoff := attr.NewOnOff(feature.LightZoneOnOffLocalID, nil)
+	onoff.Value = attr.OnOffOn
+	return &cmd.FeatureSetAttrs{
+		FeatureID: featureID,
+		Attrs:     map[string]*attr.Attribute{onoff.LocalID: onoff},
+	}
+}
 
+func TestWorkersShouldRestartAfterPanic(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(10)
 
 	cmdBuilder := &mockBuilder{&wg}
-	s := makeTestSystem(cp, cmdBuilder)
-	cp.SetSystem(s)
+	s := makeTestSystem(cmdBuilder)
+	cp := gohome.NewCommandProcessor(s, 2, 100)
 	cp.Start()
 
 	// Mock commands will panic when executed, but the command processor should
 	// keep processing them as the workers restart
 	for i := 0; i < 10; i++ {
-		cp.Enqueue(gohome.NewCommandGroup("mock group", &cmd.ZoneTurnOn{ZoneID: "z1"}))
+		cp.Enqueue(gohome.NewCommandGroup("mock group", turnOn("z1")))
 	}
 
 	// Wait here until all 10 requests are processed, if something goes wrong we will be stuck here
@@ -70,15 +74,15 @@ func TestEnqueueToFullChannelReturnsError(t *testing.T) {
 	// full, then we should get an error
 
 	// Have 0 workers to simulate queue backing up
-	cp := gohome.NewCommandProcessor(0, 1)
+	cp := gohome.NewCommandProcessor(gohome.NewSystem("mock system"), 0, 1)
 	cp.Start()
 
 	// Queue holds up to 1 command
-	err := cp.Enqueue(gohome.NewCommandGroup("mock group", &cmd.ZoneTurnOn{ZoneID: "z1"}))
+	err := cp.Enqueue(gohome.NewCommandGroup("mock group", turnOn("z1")))
 	require.Nil(t, err)
 
 	// Should get an error this time and the enqueue should not block on trying to
 	// add to the channel
-	err = cp.Enqueue(gohome.NewCommandGroup("mock group", &cmd.ZoneTurnOn{ZoneID: "z1"}))
+	err = cp.Enqueue(gohome.NewCommandGroup("mock group", turnOn("z1")))
 	require.NotNil(t, err)
 }
@@ -16,9 +16,73 @@ type Config struct {
 	// SystemPath is a path to the json file containing all of the system information
 	SystemPath string `json:"systemPath"`
 
+	// StoreType is how the system is saved, "json" saves the whole system to a single JSON file,
+	// "kv" saves each device, scene and user as a separate record in a key value file. Defaults to json
+	StoreType string `json:"storeType"`
+
+	// SystemBackups is the number of backups of the system file to keep, each time the system
+	// file is saved a backup of the previous version is made. Set to -1 to disable backups
+	SystemBackups int `json:"systemBackups"`
+
+	// KeyPath is the path to the key file used to encrypt secrets, such as device passwords, in the
+	// system file. Keep this file private and backed up, without it the secrets can't be read
+	KeyPath string `json:"keyPath"`
+
 	// EventLogPath is the path where the event log will be written
 	EventLogPath string `json:"eventLogPath"`
 
+	// AuditLogPath is the file where the audit log, of every command run and who or what ran it,
+	// is written
+	AuditLogPath string `json:"auditLogPath"`
+
+	// HistoryPath is the directory where the history of attribute values is saved
+	HistoryPath string `json:"historyPath"`
+
+	// HistoryRawRetentionDays is the number of days raw attribute values are kept, after which
+	// only the 5 minute averages are available. Defaults to 7 days
+	HistoryRawRetentionDays int `json:"historyRawRetentionDays"`
+
+	// HistoryRollupRetentionDays is the number of days the 5 minute averages of attribute values
+	// are kept. Defaults to 365 days
+	HistoryRollupRetentionDays int `json:"historyRollupRetentionDays"`
+
+	// ConfigHistoryPath is the directory where a snapshot of the system is saved each time
+	// the system configuration changes, so changes can be reviewed and rolled back
+	ConfigHistoryPath string `json:"configHistoryPath"`
+
+	// ConfigHistoryRevisions is the number of configuration snapshots to keep, the oldest
+	// snapshots are removed first. Defaults to 100, set to -1 to disable the configuration history
+	ConfigHistoryRevisions int `json:"configHistoryRevisions"`
+
+	// SessionsPath is the file where logged in sessions are saved, so users stay logged in when
+	// the server restarts
+	SessionsPath string `json:"sessionsPath"`
+
+	// SessionIdleDays is the number of days a session lasts without being used. Defaults to 30
+	SessionIdleDays int `json:"sessionIdleDays"`
+
+	// SessionMaxAgeDays is the number of days a session lasts after the user logged in, even if
+	// it is being used. Defaults to 365
+	SessionMaxAgeDays int `json:"sessionMaxAgeDays"`
+
+	// TokensPath is the file where API tokens are saved
+	TokensPath string `json:"tokensPath"`
+
+	// LoginMaxFailures is the number of failed logins for a login, or from an address, after which
+	// logins are locked out. Defaults to 10
+	LoginMaxFailures int `json:"loginMaxFailures"`
+
+	// LoginLockoutMinutes is the number of minutes logins are locked out for. Defaults to 15
+	LoginLockoutMinutes int `json:"loginLockoutMinutes"`
+
+	// LoginTrustedSubnets are networks in CIDR notation, such as "192.168.0.0/24", whose
+	// addresses are never throttled or locked out when logging in
+	LoginTrustedSubnets []string `json:"loginTrustedSubnets"`
+
+	// RequireAdminTOTP if true, admins must enrol in two factor authentication. Until they do they
+	// only have the permissions of a member
+	RequireAdminTOTP bool `json:"requireAdminTOTP"`
+
 	// AutomationPath is the path where all the automation files live
 	AutomationPath string `json:"automationPath"`
 
@@ -31,6 +95,21 @@ type Config struct {
 	// WWWPort is the port for the WWW server
 	WWWPort string `json:"wwwPort"`
 
+	// WWWCertPath is the PEM encoded TLS certificate for the WWW server. If WWWCertPath and
+	// WWWKeyPath are set the WWW server uses HTTPS
+	WWWCertPath string `json:"wwwCertPath"`
+
+	// WWWKeyPath is the PEM encoded private key of WWWCertPath
+	WWWKeyPath string `json:"wwwKeyPath"`
+
+	// WWWAllowedOrigins are the origins of other web pages that can call the API, such as
+	// "https://dashboard.local:3000". By default only the web UI served by goHOME can call the API
+	WWWAllowedOrigins []string `json:"wwwAllowedOrigins"`
+
+	// WWWRedirectPort if set and the WWW server uses HTTPS, a HTTP server listens on this port
+	// and redirects all requests to HTTPS
+	WWWRedirectPort string `json:"wwwRedirectPort"`
+
 	// UPNPNotifyAddr is the IP of the UPNP Notify server
 	UPNPNotifyAddr string `json:"upnpNotifyAddr"`
 
@@ -46,9 +125,57 @@ func (c *Config) Merge(cfg Config) {
 	if c.SystemPath == "" {
 		c.SystemPath = cfg.SystemPath
 	}
+	if c.StoreType == "" {
+		c.StoreType = cfg.StoreType
+	}
+	if c.SystemBackups == 0 {
+		c.SystemBackups = cfg.SystemBackups
+	}
+	if c.KeyPath == "" {
+		c.KeyPath = cfg.KeyPath
+	}
 	if c.EventLogPath == "" {
 		c.EventLogPath = cfg.EventLogPath
 	}
+	if c.AuditLogPath == "" {
+		c.AuditLogPath = cfg.AuditLogPath
+	}
+	if c.HistoryPath == "" {
+		c.HistoryPath = cfg.HistoryPath
+	}
+	if c.HistoryRawRetentionDays == 0 {
+		c.HistoryRawRetentionDays = cfg.HistoryRawRetentionDays
+	}
+	if c.HistoryRollupRetentionDays == 0 {
+		c.HistoryRollupRetentionDays = cfg.HistoryRollupRetentionDays
+	}
+	if c.ConfigHistoryPath == "" {
+		c.ConfigHistoryPath = cfg.ConfigHistoryPath
+	}
+	if c.ConfigHistoryRevisions == 0 {
+		c.ConfigHistoryRevisions = cfg.ConfigHistoryRevisions
+	}
+	if c.SessionsPath == "" {
+		c.SessionsPath = cfg.SessionsPath
+	}
+	if c.SessionIdleDays == 0 {
+		c.SessionIdleDays = cfg.SessionIdleDays
+	}
+	if c.SessionMaxAgeDays == 0 {
+		c.SessionMaxAgeDays = cfg.SessionMaxAgeDays
+	}
+	if c.TokensPath == "" {
+		c.TokensPath = cfg.TokensPath
+	}
+	if c.LoginMaxFailures == 0 {
+		c.LoginMaxFailures = cfg.LoginMaxFailures
+	}
+	if c.LoginLockoutMinutes == 0 {
+		c.LoginLockoutMinutes = cfg.LoginLockoutMinutes
+	}
+	if c.LoginTrustedSubnets == nil {
+		c.LoginTrustedSubnets = cfg.LoginTrustedSubnets
+	}
 	if c.AutomationPath == "" {
 		c.AutomationPath = cfg.AutomationPath
 	}
@@ -61,6 +188,18 @@ func (c *Config) Merge(cfg Config) {
 	if c.WWWPort == "" {
 		c.WWWPort = cfg.WWWPort
 	}
+	if c.WWWCertPath == "" {
+		c.WWWCertPath = cfg.WWWCertPath
+	}
+	if c.WWWKeyPath == "" {
+		c.WWWKeyPath = cfg.WWWKeyPath
+	}
+	if c.WWWAllowedOrigins == nil {
+		c.WWWAllowedOrigins = cfg.WWWAllowedOrigins
+	}
+	if c.WWWRedirectPort == "" {
+		c.WWWRedirectPort = cfg.WWWRedirectPort
+	}
 	if c.UPNPNotifyAddr == "" {
 		c.UPNPNotifyAddr = cfg.UPNPNotifyAddr
 	}
@@ -73,6 +212,79 @@ func (c *Config) Merge(cfg Config) {
 	}
 }
 
+// KeyFilePath returns the path to the encryption key file. Config files created before secrets
+// were encrypted don't have a key path, in that case the key file lives next to the system file
+func (c *Config) KeyFilePath() string {
+	if c.KeyPath != "" {
+		return c.KeyPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "gohome.key")
+}
+
+// AuditLogFilePath returns the file where the audit log is written. Config files created before
+// the audit log was supported don't have a path, in that case the file lives next to the system file
+func (c *Config) AuditLogFilePath() string {
+	if c.AuditLogPath != "" {
+		return c.AuditLogPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "audit.json")
+}
+
+// HistoryDirPath returns the directory where attribute history is saved. Config files created
+// before history was supported don't have a path, in that case it lives next to the system file
+func (c *Config) HistoryDirPath() string {
+	if c.HistoryPath != "" {
+		return c.HistoryPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "history")
+}
+
+// ConfigHistoryDirPath returns the directory where configuration snapshots are saved. Config files
+// created before the configuration history was supported don't have a path, in that case it lives
+// next to the system file
+func (c *Config) ConfigHistoryDirPath() string {
+	if c.ConfigHistoryPath != "" {
+		return c.ConfigHistoryPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "config-history")
+}
+
+// SessionsFilePath returns the file where sessions are saved. Config files created before sessions
+// were saved don't have a path, in that case the file lives next to the system file
+func (c *Config) SessionsFilePath() string {
+	if c.SessionsPath != "" {
+		return c.SessionsPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "sessions.json")
+}
+
+// TokensFilePath returns the file where API tokens are saved. Config files created before tokens
+// were supported don't have a path, in that case the file lives next to the system file
+func (c *Config) TokensFilePath() string {
+	if c.TokensPath != "" {
+		return c.TokensPath
+	}
+	return path.Join(path.Dir(c.SystemPath), "tokens.json")
+}
+
+// WWWTLS returns true if the WWW server uses HTTPS
+func (c *Config) WWWTLS() bool {
+	return c.WWWCertPath != "" && c.WWWKeyPath != ""
+}
+
+// LoginTrustedNets parses LoginTrustedSubnets, returns an error if any of the subnets are invalid
+func (c *Config) LoginTrustedNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, subnet := range c.LoginTrustedSubnets {
+		_, n, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
 // defaultConfig returns a default Config option with all the values
 // populated to some default values
 func NewDefaultConfig(systemPath, webUIPath string) *Config {
@@ -89,7 +301,12 @@ func NewDefaultConfig(systemPath, webUIPath string) *Config {
 
 	cfg := Config{
 		SystemPath:     path.Join(systemPath, "gohome.json"),
+		StoreType:      "json",
+		SystemBackups:  5,
+		KeyPath:        path.Join(systemPath, "gohome.key"),
 		EventLogPath:   path.Join(systemPath, "events.json"),
+		AuditLogPath:   path.Join(systemPath, "audit.json"),
+		HistoryPath:    path.Join(systemPath, "history"),
 		AutomationPath: path.Join(systemPath, "automation"),
 		WebUIPath:      webUIPath,
 		WWWAddr:        addr,
@@ -97,6 +314,24 @@ func NewDefaultConfig(systemPath, webUIPath string) *Config {
 		UPNPNotifyAddr: addr,
 		UPNPNotifyPort: "8001",
 		Location:       location{},
+
+		HistoryRawRetentionDays:    7,
+		HistoryRollupRetentionDays: 365,
+
+		ConfigHistoryPath:      path.Join(systemPath, "config-history"),
+		ConfigHistoryRevisions: 100,
+
+		SessionsPath:      path.Join(systemPath, "sessions.json"),
+		SessionIdleDays:   30,
+		SessionMaxAgeDays: 365,
+
+		TokensPath: path.Join(systemPath, "tokens.json"),
+
+		LoginMaxFailures:    10,
+		LoginLockoutMinutes: 15,
+		LoginTrustedSubnets: []string{},
+
+		WWWAllowedOrigins: []string{},
 	}
 
 	return &cfg
@@ -2,7 +2,6 @@ package gohome
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"time"
 
@@ -30,7 +29,7 @@ func (c *EventLogger) StartConsuming(ch chan evtbus.Event) {
 	go func() {
 		f, err := os.OpenFile(c.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
 		if err != nil {
-			log.E(fmt.Sprintf("EventLogger - failed to open event log for writing, log path: %s, err: %s", c.Path, err))
+			log.E("EventLogger - failed to open event log for writing, log path: %s, err: %s", c.Path, err)
 			return
 		}
 		log.V("EventLogger - writing events to: %s", c.Path)
@@ -57,6 +56,9 @@ func (c *EventLogger) StartConsuming(ch chan evtbus.Event) {
 			case *UserLogoutEvt:
 				eventType = "UserLogoutEvt"
 				data = evt
+			case *UserLockedOutEvt:
+				eventType = "UserLockedOutEvt"
+				data = evt
 			case *SunriseEvt:
 				eventType = "SunriseEvt"
 				data = evt
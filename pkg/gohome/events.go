@@ -2,6 +2,7 @@ package gohome
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/markdaws/gohome/pkg/attr"
 )
@@ -72,11 +73,15 @@ type FeatureAttrsChangedEvt struct {
 	FeatureID string
 	Context   string
 	Attrs     map[string]*attr.Attribute
+
+	// Origin is who or what changed the attributes, if it is known
+	Origin Origin
 }
 
 // String returns a debug string
 func (e *FeatureAttrsChangedEvt) String() string {
-	return fmt.Sprintf("FeatureAttrsChangedEvt[ID:%s, Context:%s, Attrs:%s]", e.FeatureID, e.Context, e.Attrs)
+	return fmt.Sprintf("FeatureAttrsChangedEvt[ID:%s, Context:%s, Attrs:%s, Origin:%s]",
+		e.FeatureID, e.Context, e.Attrs, e.Origin)
 }
 
 // DeviceProducingEvt is raised when a device starts producing events in the system
@@ -145,6 +150,19 @@ func (ul *UserLogoutEvt) String() string {
 	return fmt.Sprintf("UserLogoutEvt[Login: %s]", ul.Login)
 }
 
+// UserLockedOutEvt is fired when there are too many failed login attempts for a login, or from
+// an address, and logins are locked out until the Until time
+type UserLockedOutEvt struct {
+	Login      string    `json:"login"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Until      time.Time `json:"until"`
+}
+
+// String returns a debug string
+func (ul *UserLockedOutEvt) String() string {
+	return fmt.Sprintf("UserLockedOutEvt[Login: %s, RemoteAddr: %s, Until: %s]", ul.Login, ul.RemoteAddr, ul.Until)
+}
+
 // ServerStartEvt fires when the server is started
 type ServerStartedEvt struct{}
 
@@ -0,0 +1,203 @@
+package gohome
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/clock"
+)
+
+const (
+	// DefaultLoginMaxFailures is the number of failed logins after which logins are locked out
+	DefaultLoginMaxFailures = 10
+
+	// DefaultLoginLockout is how long logins are locked out for
+	DefaultLoginLockout = 15 * time.Minute
+
+	// loginBaseDelay is how long a client has to wait after the first failed login, the delay
+	// doubles after each failure up to loginMaxDelay
+	loginBaseDelay = time.Second
+
+	// loginMaxDelay is the longest a client has to wait between failed logins before it is locked out
+	loginMaxDelay = time.Minute
+
+	// loginFailureReset is how long after the last failure the failures are forgotten
+	loginFailureReset = time.Hour
+)
+
+// loginFailures tracks the failed logins for a login or an address
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// wait returns how long until another login can be attempted
+func (f *loginFailures) wait(now time.Time) time.Duration {
+	if now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	if f.count == 0 {
+		return 0
+	}
+
+	delay := loginBaseDelay << uint(f.count-1)
+	if delay > loginMaxDelay || delay <= 0 {
+		delay = loginMaxDelay
+	}
+	if wait := f.last.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// LoginThrottle protects logins from password guessing. Each failed login makes the login and the
+// address the attempt came from wait exponentially longer before trying again, after MaxFailures
+// failures they are locked out for Lockout. Addresses in TrustedNets are never throttled
+type LoginThrottle struct {
+	// MaxFailures is the number of failed logins after which the login or address is locked out
+	MaxFailures int
+
+	// Lockout is how long a login or address is locked out for
+	Lockout time.Duration
+
+	// TrustedNets are networks whose addresses are never throttled, such as the home LAN
+	TrustedNets []*net.IPNet
+
+	// Time is the source of the current time
+	Time clock.Time
+
+	mutex  sync.Mutex
+	logins map[string]*loginFailures
+	addrs  map[string]*loginFailures
+}
+
+// NewLoginThrottle returns a newly instantiated LoginThrottle. If maxFailures or lockout are zero
+// the default values are used
+func NewLoginThrottle(maxFailures int, lockout time.Duration, trustedNets []*net.IPNet) *LoginThrottle {
+	if maxFailures <= 0 {
+		maxFailures = DefaultLoginMaxFailures
+	}
+	if lockout <= 0 {
+		lockout = DefaultLoginLockout
+	}
+	return &LoginThrottle{
+		MaxFailures: maxFailures,
+		Lockout:     lockout,
+		TrustedNets: trustedNets,
+		Time:        clock.SystemTime{},
+		logins:      make(map[string]*loginFailures),
+		addrs:       make(map[string]*loginFailures),
+	}
+}
+
+// Wait returns how long the client at addr has to wait before it can try to log in as login,
+// zero if it can try now. addr can be a host or host:port
+func (t *LoginThrottle) Wait(login, addr string) time.Duration {
+	host := addrHost(addr)
+	if t.trusted(host) {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.Time.Now()
+	var wait time.Duration
+	if f, ok := t.logins[strings.ToLower(login)]; ok {
+		wait = f.wait(now)
+	}
+	if f, ok := t.addrs[host]; ok {
+		if w := f.wait(now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// Failed records a failed login. If the failure locks out the login or the address, it returns
+// true and the time the lockout ends
+func (t *LoginThrottle) Failed(login, addr string) (bool, time.Time) {
+	host := addrHost(addr)
+	if t.trusted(host) {
+		return false, time.Time{}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.Time.Now()
+	t.purge(now)
+
+	lockedOut := false
+	var until time.Time
+	for _, f := range []*loginFailures{
+		t.failures(t.logins, strings.ToLower(login)),
+		t.failures(t.addrs, host),
+	} {
+		f.count++
+		f.last = now
+		if f.count >= t.MaxFailures {
+			// The backoff starts again once the lockout ends
+			f.count = 0
+			f.lockedUntil = now.Add(t.Lockout)
+			lockedOut = true
+			until = f.lockedUntil
+		}
+	}
+	return lockedOut, until
+}
+
+// Succeeded records a successful login, clearing the failures of the login. The failures of the
+// address are left to expire, otherwise someone could guess other logins' passwords without being
+// throttled by logging in to their own account between guesses
+func (t *LoginThrottle) Succeeded(login string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.logins, strings.ToLower(login))
+}
+
+func (t *LoginThrottle) failures(m map[string]*loginFailures, key string) *loginFailures {
+	f, ok := m[key]
+	if !ok {
+		f = &loginFailures{}
+		m[key] = f
+	}
+	return f
+}
+
+// purge forgets failures that are old enough not to matter, so guessing many different logins
+// doesn't use up memory
+func (t *LoginThrottle) purge(now time.Time) {
+	for _, m := range []map[string]*loginFailures{t.logins, t.addrs} {
+		for key, f := range m {
+			if now.After(f.lockedUntil) && now.Sub(f.last) > loginFailureReset {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+func (t *LoginThrottle) trusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.TrustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrHost returns the host part of an address, which may or may not contain a port
+func addrHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
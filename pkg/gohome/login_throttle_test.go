@@ -0,0 +1,76 @@
+package gohome_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottleBacksOffAndLocksOut(t *testing.T) {
+	t.Parallel()
+
+	_, lan, err := net.ParseCIDR("192.168.0.0/24")
+	require.Nil(t, err)
+
+	now := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	throttle := gohome.NewLoginThrottle(3, 15*time.Minute, []*net.IPNet{lan})
+	throttle.Time = MockTime{now: now}
+
+	require.Equal(t, time.Duration(0), throttle.Wait("bob", "10.0.0.2:1234"))
+
+	// Each failure doubles the wait
+	lockedOut, _ := throttle.Failed("bob", "10.0.0.2:1234")
+	require.False(t, lockedOut)
+	require.Equal(t, time.Second, throttle.Wait("bob", "10.0.0.2:5678"))
+	require.Equal(t, time.Second, throttle.Wait("Bob", "10.0.0.3:1234"))
+	require.Equal(t, time.Second, throttle.Wait("alice", "10.0.0.2:1234"))
+	require.Equal(t, time.Duration(0), throttle.Wait("alice", "10.0.0.3:1234"))
+
+	lockedOut, _ = throttle.Failed("bob", "10.0.0.2:1234")
+	require.False(t, lockedOut)
+	require.Equal(t, 2*time.Second, throttle.Wait("bob", "10.0.0.2:1234"))
+
+	lockedOut, until := throttle.Failed("bob", "10.0.0.2:1234")
+	require.True(t, lockedOut)
+	require.Equal(t, now.Add(15*time.Minute), until)
+	require.Equal(t, 15*time.Minute, throttle.Wait("bob", "10.0.0.4:1234"))
+
+	// Trusted addresses are never throttled
+	require.Equal(t, time.Duration(0), throttle.Wait("bob", "192.168.0.10:1234"))
+	lockedOut, _ = throttle.Failed("alice", "192.168.0.10:1234")
+	require.False(t, lockedOut)
+	require.Equal(t, time.Duration(0), throttle.Wait("alice", "10.0.0.3:1234"))
+
+	throttle.Time = MockTime{now: now.Add(16 * time.Minute)}
+	require.Equal(t, time.Duration(0), throttle.Wait("bob", "10.0.0.2:1234"))
+
+	lockedOut, _ = throttle.Failed("bob", "10.0.0.2:1234")
+	require.False(t, lockedOut)
+	throttle.Succeeded("bob")
+	require.Equal(t, time.Duration(0), throttle.Wait("bob", "10.0.0.4:1234"))
+	require.Equal(t, time.Second, throttle.Wait("bob", "10.0.0.2:1234"))
+}
+
+func TestLoginThrottleSuccessDoesNotClearAddressFailures(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	throttle := gohome.NewLoginThrottle(3, 15*time.Minute, nil)
+	throttle.Time = MockTime{now: now}
+
+	// An attacker guessing alice's password logs in to their own account between guesses, the
+	// failures from their address still add up and lock them out
+	lockedOut := false
+	for i := 0; i < 3; i++ {
+		lockedOut, _ = throttle.Failed("alice", "10.0.0.2:1234")
+		throttle.Succeeded("mallory")
+	}
+	require.True(t, lockedOut)
+	require.Equal(t, 15*time.Minute, throttle.Wait("mallory", "10.0.0.2:1234"))
+
+	// Other addresses can still log in as the attacker's account
+	require.Equal(t, time.Duration(0), throttle.Wait("mallory", "10.0.0.3:1234"))
+}
@@ -24,6 +24,11 @@ type MonitorGroup struct {
 	Features        map[string]bool
 	Handler         MonitorDelegate
 	Timeout         time.Duration
+
+	// Owner identifies the session or API token that created the group, only the owner can
+	// use the group
+	Owner string
+
 	timeoutAbsolute time.Time
 	id              string
 }
@@ -234,11 +239,11 @@ func (m *Monitor) Unsubscribe(monitorID string) {
 		monitorID, emptyFeatureToGroupCount)
 }
 
-func (m *Monitor) featureAttrsChanged(featureID string, attrs map[string]*attr.Attribute) {
-	m.featureReporting(featureID, attrs)
+func (m *Monitor) featureAttrsChanged(featureID string, attrs map[string]*attr.Attribute, origin Origin) {
+	m.featureReporting(featureID, attrs, origin)
 }
 
-func (m *Monitor) featureReporting(featureID string, attrs map[string]*attr.Attribute) {
+func (m *Monitor) featureReporting(featureID string, attrs map[string]*attr.Attribute, origin Origin) {
 	m.mutex.RLock()
 	groups, ok := m.featureToGroups[featureID]
 	m.mutex.RUnlock()
@@ -312,6 +317,7 @@ func (m *Monitor) featureReporting(featureID string, attrs map[string]*attr.Attr
 		FeatureID: featureID,
 		Context:   MonitorContext,
 		Attrs:     updatedAttrs,
+		Origin:    origin,
 	})
 }
 
@@ -386,7 +392,7 @@ func (m *Monitor) StartConsuming(c chan evtbus.Event) {
 		for e := range c {
 			switch evt := e.(type) {
 			case *FeatureReportingEvt:
-				m.featureReporting(evt.FeatureID, evt.Attrs)
+				m.featureReporting(evt.FeatureID, evt.Attrs, Origin{})
 
 			case *FeatureAttrsChangedEvt:
 				// If this is an events we raised, ignore, vs receiving this
@@ -394,7 +400,7 @@ func (m *Monitor) StartConsuming(c chan evtbus.Event) {
 				if evt.Context == MonitorContext {
 					continue
 				}
-				m.featureAttrsChanged(evt.FeatureID, evt.Attrs)
+				m.featureAttrsChanged(evt.FeatureID, evt.Attrs, evt.Origin)
 
 			case *DeviceProducingEvt:
 				m.deviceProducing(evt)
@@ -0,0 +1,52 @@
+package gohome
+
+import "strings"
+
+// Origin records who or what caused a command to be run or an event to be raised, such as a
+// user calling the API, an automation that was triggered or a keypad button being pressed. All
+// of the fields are optional, an empty Origin means the origin is unknown
+type Origin struct {
+	// UserID is the ID of the user that made the request
+	UserID string `json:"userId,omitempty"`
+
+	// Login is the login of the user that made the request, kept so the audit log is still
+	// readable after the user is deleted
+	Login string `json:"login,omitempty"`
+
+	// TokenID is the ID of the API token the request was made with, empty if the user was
+	// logged in
+	TokenID string `json:"tokenId,omitempty"`
+
+	// Automation is the name of the automation that was triggered
+	Automation string `json:"automation,omitempty"`
+
+	// Extension is the name of the extension that reported a change made on the hardware, such
+	// as a button being pressed on a keypad
+	Extension string `json:"extension,omitempty"`
+}
+
+// IsZero returns true if the origin is unknown
+func (o Origin) IsZero() bool {
+	return o == Origin{}
+}
+
+// String returns a debug string
+func (o Origin) String() string {
+	var parts []string
+	if o.Login != "" || o.UserID != "" {
+		parts = append(parts, "user: "+o.Login+" ("+o.UserID+")")
+	}
+	if o.TokenID != "" {
+		parts = append(parts, "token: "+o.TokenID)
+	}
+	if o.Automation != "" {
+		parts = append(parts, "automation: "+o.Automation)
+	}
+	if o.Extension != "" {
+		parts = append(parts, "extension: "+o.Extension)
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,132 @@
+package gohome
+
+import (
+	"fmt"
+
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/validation"
+)
+
+const (
+	// RoleAdmin can do everything, including changing devices, users and the system configuration
+	RoleAdmin = "admin"
+
+	// RoleMember can view and control features and scenes, but can't change the configuration
+	RoleMember = "member"
+
+	// RoleGuest can only view and control the areas, features and scenes they have been granted
+	RoleGuest = "guest"
+)
+
+const (
+	// GrantArea gives access to all of the features in an area and its child areas
+	GrantArea = "area"
+
+	// GrantFeature gives access to a single feature
+	GrantFeature = "feature"
+
+	// GrantScene gives access to a single scene
+	GrantScene = "scene"
+)
+
+// roleRanks orders the roles, each role can do everything the lower ranked roles can do
+var roleRanks = map[string]int{
+	RoleGuest:  1,
+	RoleMember: 2,
+	RoleAdmin:  3,
+}
+
+// Grant gives a user access to an area, feature or scene
+type Grant struct {
+	// Type is the type of item access is granted to, one of GrantArea, GrantFeature or GrantScene
+	Type string
+
+	// ID is the ID of the area, feature or scene
+	ID string
+}
+
+// IsValidRole returns true if role is one of the supported roles
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole returns true if the user has the role or a role with more permissions
+func (u *User) HasRole(role string) bool {
+	return roleRanks[u.Role] >= roleRanks[role]
+}
+
+// restricted returns true if the user can only access the items they have been granted. Guests
+// are always restricted, members are restricted if they have any grants
+func (u *User) restricted() bool {
+	if u.HasRole(RoleAdmin) {
+		return false
+	}
+	return u.Role == RoleGuest || len(u.Grants) > 0
+}
+
+// CanAccessFeature returns true if the user can view and control the feature
+func (u *User) CanAccessFeature(sys *System, f *feature.Feature) bool {
+	if !u.HasRole(RoleGuest) {
+		return false
+	}
+	if !u.restricted() {
+		return true
+	}
+
+	area := sys.FeatureArea(f.ID)
+	for _, grant := range u.Grants {
+		switch grant.Type {
+		case GrantFeature:
+			if grant.ID == f.ID {
+				return true
+			}
+		case GrantArea:
+			for a := area; a != nil; a = a.Parent {
+				if a.ID == grant.ID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// CanAccessScene returns true if the user can view and activate the scene
+func (u *User) CanAccessScene(scn *Scene) bool {
+	if !u.HasRole(RoleGuest) {
+		return false
+	}
+	if !u.restricted() {
+		return true
+	}
+
+	for _, grant := range u.Grants {
+		if grant.Type == GrantScene && grant.ID == scn.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGrants verifies all of the grants reference items that exist in the system
+func (u *User) ValidateGrants(sys *System) *validation.Errors {
+	for _, grant := range u.Grants {
+		var found bool
+		switch grant.Type {
+		case GrantArea:
+			found = sys.AreaByID(grant.ID) != nil
+		case GrantFeature:
+			found = sys.FeatureByID(grant.ID) != nil
+		case GrantScene:
+			found = sys.SceneByID(grant.ID) != nil
+		default:
+			return validation.NewErrors("Grants", fmt.Sprintf("invalid grant type: %s, must be one of [%s|%s|%s]",
+				grant.Type, GrantArea, GrantFeature, GrantScene), false)
+		}
+		if !found {
+			return validation.NewErrors("Grants", fmt.Sprintf("invalid %s ID: %s", grant.Type, grant.ID), false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package gohome_test
+
+import (
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserPermissions(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("permissions")
+	livingRoom := &gohome.Area{ID: "livingroom", Name: "Living Room"}
+	couch := &gohome.Area{ID: "couch", Name: "Couch"}
+	require.Nil(t, sys.AddArea(livingRoom, nil))
+	require.Nil(t, sys.AddArea(couch, livingRoom))
+
+	lamp := feature.NewLightZone("lamp", feature.LightZoneModeBinary)
+	door := feature.NewLightZone("door", feature.LightZoneModeBinary)
+	sys.AddFeature(lamp)
+	sys.AddFeature(door)
+	sys.SetFeatureArea(lamp, couch)
+
+	scene := &gohome.Scene{ID: "movie", Name: "Movie"}
+	sys.AddScene(scene)
+
+	admin := &gohome.User{Role: gohome.RoleAdmin, Grants: []gohome.Grant{{Type: gohome.GrantScene, ID: "other"}}}
+	member := &gohome.User{Role: gohome.RoleMember}
+	guest := &gohome.User{Role: gohome.RoleGuest, Grants: []gohome.Grant{
+		{Type: gohome.GrantArea, ID: livingRoom.ID},
+		{Type: gohome.GrantScene, ID: scene.ID},
+	}}
+	noRole := &gohome.User{}
+
+	require.True(t, admin.HasRole(gohome.RoleMember))
+	require.False(t, member.HasRole(gohome.RoleAdmin))
+	require.True(t, guest.HasRole(gohome.RoleGuest))
+	require.False(t, noRole.HasRole(gohome.RoleGuest))
+
+	// Admins and members without grants can access everything
+	require.True(t, admin.CanAccessFeature(sys, door))
+	require.True(t, admin.CanAccessScene(scene))
+	require.True(t, member.CanAccessFeature(sys, door))
+
+	// Guests can access features in child areas of the areas they are granted
+	require.True(t, guest.CanAccessFeature(sys, lamp))
+	require.False(t, guest.CanAccessFeature(sys, door))
+	require.True(t, guest.CanAccessScene(scene))
+	require.False(t, noRole.CanAccessFeature(sys, lamp))
+
+	// Members with grants are restricted to them
+	member.Grants = []gohome.Grant{{Type: gohome.GrantFeature, ID: door.ID}}
+	require.True(t, member.CanAccessFeature(sys, door))
+	require.False(t, member.CanAccessFeature(sys, lamp))
+	require.False(t, member.CanAccessScene(scene))
+
+	guest.Grants = append(guest.Grants, gohome.Grant{Type: gohome.GrantFeature, ID: "missing"})
+	require.NotNil(t, guest.ValidateGrants(sys))
+}
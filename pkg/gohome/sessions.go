@@ -1,57 +1,341 @@
 package gohome
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"math/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/clock"
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+const (
+	// DefaultSessionIdleTimeout is how long a session lasts without being used
+	DefaultSessionIdleTimeout = 30 * 24 * time.Hour
+
+	// DefaultSessionMaxAge is how long a session lasts after the user logged in, even if it is used
+	DefaultSessionMaxAge = 365 * 24 * time.Hour
+
+	// sessionPurgePeriod is how often expired sessions are removed and sessions are saved
+	sessionPurgePeriod = 5 * time.Minute
+
+	// sessionLastSeenResolution is how much the last seen time has to change before the sessions
+	// need to be saved, so every request doesn't cause a write
+	sessionLastSeenResolution = time.Minute
 )
 
-// Sessions manages user sessions in the app
+// Session is a logged in client, such as a browser or wall tablet
+type Session struct {
+	// ID identifies the session, it is a hash of the session token so it can be shown to users
+	// and saved to disk without allowing anyone to use the session
+	ID string `json:"id"`
+
+	// UserID is the ID of the user that owns the session
+	UserID string `json:"userId"`
+
+	// Created is when the user logged in
+	Created time.Time `json:"created"`
+
+	// LastSeen is the last time the session was used
+	LastSeen time.Time `json:"lastSeen"`
+
+	// UserAgent is the user agent of the client that logged in
+	UserAgent string `json:"userAgent"`
+
+	// RemoteAddr is the address of the client that logged in
+	RemoteAddr string `json:"remoteAddr"`
+}
+
+// Sessions manages user sessions in the app. Sessions expire once they haven't been used for
+// IdleTimeout, or MaxAge after they were created. Sessions are saved to Path so they survive
+// restarts, if Path is empty sessions are only kept in memory
 type Sessions struct {
-	mutex sync.RWMutex
-	sids  map[string]map[string]interface{}
+	// Path is the file the sessions are saved to
+	Path string
+
+	// IdleTimeout is how long a session lasts without being used
+	IdleTimeout time.Duration
+
+	// MaxAge is how long a session lasts after it was created
+	MaxAge time.Duration
+
+	// Time is the source of the current time, used to expire sessions
+	Time clock.Time
+
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+	dirty    bool
+	done     chan bool
 }
 
-// NewSessions returns a newly instantiated Sessions instance
-func NewSessions() *Sessions {
+// NewSessions returns a newly instantiated Sessions instance. If the timeouts are zero the
+// default values are used
+func NewSessions(path string, idleTimeout, maxAge time.Duration) *Sessions {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultSessionIdleTimeout
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultSessionMaxAge
+	}
 	return &Sessions{
-		sids: make(map[string]map[string]interface{}),
+		Path:        path,
+		IdleTimeout: idleTimeout,
+		MaxAge:      maxAge,
+		Time:        clock.SystemTime{},
+		sessions:    make(map[string]*Session),
 	}
 }
 
-// Add adds a new session and returns the session ID back to the caller.  You must
-// call Save() at some point to persist the sessions to disk
-func (s *Sessions) Add() (string, error) {
+// SessionID returns the ID of the session that the session token belongs to
+func SessionID(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Load loads the saved sessions, expired sessions are dropped. If there are no saved sessions
+// there is no error
+func (s *Sessions) Load() error {
+	if s.Path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []*Session
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	b := make([]byte, 128)
-	_, err := rand.Read(b)
+	now := s.Time.Now()
+	s.sessions = make(map[string]*Session)
+	for _, session := range saved {
+		if !s.expired(session, now) {
+			s.sessions[session.ID] = session
+		}
+	}
+	return nil
+}
+
+// Add adds a new session for the user and returns the session token back to the caller, the
+// token is the only way to use the session so it is never saved. You must call Save() at some
+// point to persist the sessions to disk
+func (s *Sessions) Add(userID, userAgent, remoteAddr string) (string, error) {
+	token, err := randomToken()
 	if err != nil {
 		return "", err
 	}
 
-	//TODO: we need to have a expiration date in here too
-	sid := base64.URLEncoding.EncodeToString(b)
-	s.sids[sid] = make(map[string]interface{})
-	return sid, nil
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.Time.Now()
+	session := &Session{
+		ID:         SessionID(token),
+		UserID:     userID,
+		Created:    now,
+		LastSeen:   now,
+		UserAgent:  userAgent,
+		RemoteAddr: remoteAddr,
+	}
+	s.sessions[session.ID] = session
+	s.dirty = true
+	return token, nil
+}
+
+// Get returns the session the token belongs to and marks the session as being used. If the token
+// is not valid or the session has expired it returns false as the second return value
+func (s *Sessions) Get(token string) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[SessionID(token)]
+	if !ok {
+		return Session{}, false
+	}
+
+	now := s.Time.Now()
+	if s.expired(session, now) {
+		delete(s.sessions, session.ID)
+		s.dirty = true
+		return Session{}, false
+	}
+
+	if now.Sub(session.LastSeen) >= sessionLastSeenResolution {
+		session.LastSeen = now
+		s.dirty = true
+	}
+	return *session, true
+}
+
+// Valid returns true if the session with the specified ID exists and has not expired. Unlike Get
+// it doesn't mark the session as being used
+func (s *Sessions) Valid(ID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[ID]
+	return ok && !s.expired(session, s.Time.Now())
 }
 
-// Get returns the values associated with the session ID.  If the session ID is
-// not valid it returns false as the second return value
-func (s *Sessions) Get(SID string) (map[string]interface{}, bool) {
+// UserSessions returns all of the sessions owned by the user, newest first
+func (s *Sessions) UserSessions(userID string) []Session {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	val, ok := s.sids[SID]
 
-	return val, ok
+	now := s.Time.Now()
+	var out []Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && !s.expired(session, now) {
+			out = append(out, *session)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.After(out[j].Created)
+	})
+	return out
+}
+
+// Delete removes the session with the specified ID, returns false if the session was not found
+func (s *Sessions) Delete(ID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[ID]; !ok {
+		return false
+	}
+	delete(s.sessions, ID)
+	s.dirty = true
+	return true
+}
+
+// DeleteUserSessions removes all of the sessions owned by the user, except the session with the ID
+// keep, which can be empty. Returns the number of sessions removed
+func (s *Sessions) DeleteUserSessions(userID, keep string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for ID, session := range s.sessions {
+		if session.UserID == userID && ID != keep {
+			delete(s.sessions, ID)
+			count++
+		}
+	}
+	if count > 0 {
+		s.dirty = true
+	}
+	return count
+}
+
+// Purge removes all of the expired sessions
+func (s *Sessions) Purge() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.Time.Now()
+	for ID, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, ID)
+			s.dirty = true
+		}
+	}
 }
 
-// Save persists the session information to backing store
+// Save persists the session information to disk, if nothing has changed since the last save
+// nothing is written
 func (s *Sessions) Save() error {
-	//TODO: In memory right now, need to persist to disk otherwise sessions are lost
-	//across reboots
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+
+	if s.Path == "" || !s.dirty {
+		return nil
+	}
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	b, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file then rename it, so a crash never leaves a partially written file
+	tmpPath := filepath.Join(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".tmp")
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	s.dirty = false
 	return nil
 }
+
+// Start periodically removes expired sessions and saves any changes, such as when sessions were
+// last used, until Stop is called
+func (s *Sessions) Start() {
+	s.done = make(chan bool)
+	done := s.done
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.Time.After(sessionPurgePeriod):
+			}
+
+			s.Purge()
+			if err := s.Save(); err != nil {
+				log.E("Sessions - failed to save sessions: %s", err)
+			}
+		}
+	}()
+}
+
+// Stop stops removing expired sessions and saves any changes
+func (s *Sessions) Stop() {
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+	if err := s.Save(); err != nil {
+		log.E("Sessions - failed to save sessions: %s", err)
+	}
+}
+
+// randomToken returns a random string that can't be guessed, used for session and API tokens
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func (s *Sessions) expired(session *Session, now time.Time) bool {
+	return now.Sub(session.LastSeen) > s.IdleTimeout || now.Sub(session.Created) > s.MaxAge
+}
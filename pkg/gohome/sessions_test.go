@@ -0,0 +1,80 @@
+package gohome_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionsPersistAndExpire(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "gohome-sessions")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "sessions.json")
+	sessions := gohome.NewSessions(path, time.Hour, 24*time.Hour)
+	sessions.Time = MockTime{now: start}
+
+	token, err := sessions.Add("user1", "tablet", "10.0.0.2:1234")
+	require.Nil(t, err)
+	other, err := sessions.Add("user1", "phone", "10.0.0.3:1234")
+	require.Nil(t, err)
+	require.NotEqual(t, token, other)
+	require.Nil(t, sessions.Save())
+
+	// Only a hash of the token is saved
+	b, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), token)
+
+	loaded := gohome.NewSessions(path, time.Hour, 24*time.Hour)
+	loaded.Time = MockTime{now: start.Add(30 * time.Minute)}
+	require.Nil(t, loaded.Load())
+
+	session, ok := loaded.Get(token)
+	require.True(t, ok)
+	require.Equal(t, "user1", session.UserID)
+	require.Equal(t, "tablet", session.UserAgent)
+	require.Equal(t, 2, len(loaded.UserSessions("user1")))
+
+	laptop, err := loaded.Add("user2", "laptop", "10.0.0.4:1234")
+	require.Nil(t, err)
+	require.Equal(t, 0, loaded.DeleteUserSessions("user2", gohome.SessionID(laptop)))
+	require.Equal(t, 1, len(loaded.UserSessions("user2")))
+	require.Equal(t, 1, loaded.DeleteUserSessions("user2", ""))
+	require.Equal(t, 0, len(loaded.UserSessions("user2")))
+
+	require.True(t, loaded.Valid(gohome.SessionID(other)))
+	require.True(t, loaded.Delete(gohome.SessionID(other)))
+	_, ok = loaded.Get(other)
+	require.False(t, ok)
+	require.False(t, loaded.Valid(gohome.SessionID(other)))
+
+	// The session was used 30 minutes in, so it is still valid an hour after it was created
+	loaded.Time = MockTime{now: start.Add(80 * time.Minute)}
+	_, ok = loaded.Get(token)
+	require.True(t, ok)
+
+	// Sessions that aren't used expire
+	loaded.Time = MockTime{now: start.Add(3 * time.Hour)}
+	require.False(t, loaded.Valid(gohome.SessionID(token)))
+	_, ok = loaded.Get(token)
+	require.False(t, ok)
+
+	// Sessions expire after the max age even if they are used
+	token, err = loaded.Add("user1", "tablet", "")
+	require.Nil(t, err)
+	for i := 1; i <= 25; i++ {
+		loaded.Time = MockTime{now: start.Add(3*time.Hour + time.Duration(i)*time.Hour)}
+		_, ok = loaded.Get(token)
+		require.Equal(t, i <= 24, ok)
+	}
+}
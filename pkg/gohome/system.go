@@ -1,14 +1,18 @@
 package gohome
 
 import (
+	"errors"
+	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/go-home-iot/event-bus"
 	"github.com/go-home-iot/upnp"
 	"github.com/markdaws/gohome/pkg/feature"
 	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/validation"
 	"github.com/nu7hatch/gouuid"
 )
 
@@ -19,6 +23,8 @@ type SystemServices struct {
 	Monitor      *Monitor
 	EvtBus       *evtbus.Bus
 	CmdProcessor CommandProcessor
+	History      *AttrHistory
+	Audit        *AuditLog
 }
 
 // System is a container that holds information such as all the zones and devices
@@ -31,6 +37,7 @@ type System struct {
 	Services    SystemServices
 
 	mutex      sync.RWMutex
+	areas      map[string]*Area
 	automation map[string]*Automation
 	devices    map[string]*Device
 	features   map[string]*feature.Feature
@@ -44,6 +51,7 @@ func NewSystem(name string) *System {
 	s := &System{
 		Name:        name,
 		Description: "",
+		areas:       make(map[string]*Area),
 		automation:  make(map[string]*Automation),
 		devices:     make(map[string]*Device),
 		scenes:      make(map[string]*Scene),
@@ -56,6 +64,7 @@ func NewSystem(name string) *System {
 		ID:   s.NewID(),
 		Name: "Home",
 	}
+	s.areas[s.Area.ID] = s.Area
 
 	s.Extensions = NewExtensions()
 	return s
@@ -180,7 +189,11 @@ func (s *System) DeleteDevice(d *Device) {
 	delete(s.devices, d.ID)
 	s.mutex.Unlock()
 
-	//TODO: Remove all associated zones + buttons
+	// The device's features are removed from the system and from any areas they were in
+	for _, f := range d.Features {
+		s.DeleteFeature(f)
+	}
+
 	//TODO: Need to stop all services, recipes, networking etc to this device
 }
 
@@ -230,6 +243,17 @@ func (s *System) AddFeature(f *feature.Feature) {
 	s.mutex.Unlock()
 }
 
+// DeleteFeature removes the feature from the system and from the area it is in
+func (s *System) DeleteFeature(f *feature.Feature) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.features, f.ID)
+	for _, area := range s.areas {
+		area.RemoveFeature(f)
+	}
+}
+
 // FeatureByID returns the feature with the specified ID, nil if not found
 func (s *System) FeatureByID(ID string) *feature.Feature {
 	s.mutex.RLock()
@@ -272,6 +296,16 @@ func (s *System) SceneByID(ID string) *Scene {
 	return s.scenes[ID]
 }
 
+// SceneNameByID returns the name of the scene with the specified ID, false if not found. Used
+// by commands that reference scenes, see cmd.Lookup
+func (s *System) SceneNameByID(ID string) (string, bool) {
+	scene := s.SceneByID(ID)
+	if scene == nil {
+		return "", false
+	}
+	return scene.Name, true
+}
+
 // AddScene adds a scene to the system. If a scene with the same ID already exists, it is
 // overwritten with the new scene
 func (s *System) AddScene(scn *Scene) {
@@ -299,6 +333,203 @@ func (s *System) DeleteScene(scn *Scene) {
 	s.mutex.Unlock()
 }
 
+// AreaByID returns the area with the specified ID, nil if not found
+func (s *System) AreaByID(ID string) *Area {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.areas[ID]
+}
+
+// Areas returns a map of all the areas in the system, including the root area, keyed by area ID
+func (s *System) Areas() map[string]*Area {
+	out := make(map[string]*Area)
+	s.mutex.RLock()
+	for k, v := range s.areas {
+		out[k] = v
+	}
+	s.mutex.RUnlock()
+	return out
+}
+
+// SetRootArea replaces the root area of the system with the specified area, the area and all
+// of its descendants become the areas of the system
+func (s *System) SetRootArea(root *Area) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.setRootArea(root)
+}
+
+// setRootArea replaces the root area and indexes all of its descendants, the caller must hold
+// the mutex
+func (s *System) setRootArea(root *Area) {
+	root.Parent = nil
+	s.Area = root
+	s.areas = make(map[string]*Area)
+
+	var index func(a *Area)
+	index = func(a *Area) {
+		s.areas[a.ID] = a
+		for _, child := range a.Areas {
+			index(child)
+		}
+	}
+	index(root)
+}
+
+// Replace replaces the name, description, devices, scenes and areas of the system in one step, so
+// nothing sees the system part way through the change. The features of the system become the
+// features of the devices. The devices are not stopped or initialized, callers must do that
+func (s *System) Replace(name, description string, devices map[string]*Device, scenes map[string]*Scene, root *Area) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Name = name
+	s.Description = description
+	s.devices = devices
+	s.features = make(map[string]*feature.Feature)
+	for _, d := range devices {
+		for _, f := range d.Features {
+			s.features[f.ID] = f
+		}
+	}
+	s.scenes = scenes
+	s.setRootArea(root)
+}
+
+// AddArea adds the area to the system as a child of parent, if parent is nil the area is added
+// to the root area. If the area is already in the system, it is moved to the new parent. An area
+// cannot be moved inside itself or one of its descendants
+func (s *System) AddArea(area, parent *Area) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.addArea(area, parent)
+}
+
+// addArea adds the area as a child of parent, see AddArea. Nothing is changed if an error is
+// returned. The caller must hold the mutex
+func (s *System) addArea(area, parent *Area) error {
+	if parent == nil {
+		parent = s.Area
+	}
+	if _, ok := s.areas[parent.ID]; !ok {
+		return fmt.Errorf("invalid parent area ID: %s", parent.ID)
+	}
+	if area == s.Area {
+		return fmt.Errorf("the root area cannot be moved")
+	}
+	if parent == area || parent.IsDescendantOf(area) {
+		return fmt.Errorf("an area cannot be moved inside itself")
+	}
+
+	parent.AddArea(area)
+	s.areas[area.ID] = area
+	return nil
+}
+
+// UpdateArea changes the name and description of the area and moves it inside parent, nil values
+// are left unchanged. Nothing is changed if the updated area is not valid or can't be moved
+func (s *System) UpdateArea(area *Area, name, description *string, parent *Area) *validation.Errors {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated := Area{Name: area.Name, Description: area.Description}
+	if name != nil {
+		updated.Name = *name
+	}
+	if description != nil {
+		updated.Description = *description
+	}
+	if valErrs := updated.Validate(); valErrs != nil {
+		return valErrs
+	}
+
+	if parent != nil && parent != area.Parent {
+		if err := s.addArea(area, parent); err != nil {
+			return validation.NewErrors("ParentID", err.Error(), false)
+		}
+	}
+
+	// The area is referenced by its parent and children, so it is updated in place
+	area.Name = updated.Name
+	area.Description = updated.Description
+	return nil
+}
+
+// AreaFeatures returns the features in the area, if recursive is true the features of all of its
+// child areas are included
+func (s *System) AreaFeatures(area *Area, recursive bool) []*feature.Feature {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if recursive {
+		return area.AllFeatures()
+	}
+	return append([]*feature.Feature{}, area.Features...)
+}
+
+// ReadAreas calls fn with the root area, the areas can't be changed until fn returns so it can
+// safely read the area hierarchy. fn must not call any other methods of the system
+func (s *System) ReadAreas(fn func(root *Area)) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fn(s.Area)
+}
+
+// DeleteArea deletes the area from the system. Any child areas and features in the area are
+// moved to the parent of the deleted area. The root area cannot be deleted
+func (s *System) DeleteArea(area *Area) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if area == s.Area || area.Parent == nil {
+		return fmt.Errorf("the root area cannot be deleted")
+	}
+
+	parent := area.Parent
+	for _, child := range append([]*Area{}, area.Areas...) {
+		parent.AddArea(child)
+	}
+	for _, f := range area.Features {
+		parent.AddFeature(f)
+	}
+	area.Features = nil
+	parent.RemoveArea(area)
+	delete(s.areas, area.ID)
+	return nil
+}
+
+// SetFeatureArea moves the feature to the specified area, a feature is only ever in one area.
+// If area is nil the feature is removed from all areas
+func (s *System) SetFeatureArea(f *feature.Feature, area *Area) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, a := range s.areas {
+		if a != area {
+			a.RemoveFeature(f)
+		}
+	}
+	if area != nil {
+		area.AddFeature(f)
+	}
+}
+
+// FeatureArea returns the area that contains the feature, nil if the feature is not in an area
+func (s *System) FeatureArea(featureID string) *Area {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, a := range s.areas {
+		if a.HasFeature(featureID) {
+			return a
+		}
+	}
+	return nil
+}
+
 // Automations reutrns all of the automation scripts keyed by ID
 func (s *System) Automations() map[string]*Automation {
 	out := make(map[string]*Automation)
@@ -346,3 +577,103 @@ func (s *System) AddUser(u *User) {
 	s.users[u.ID] = u
 	s.mutex.Unlock()
 }
+
+// ErrLastAdmin is returned when a change would leave the system without an admin
+var ErrLastAdmin = errors.New("the system must have at least one admin")
+
+// DeleteUser removes the user from the system. ErrLastAdmin is returned if the user is the
+// only admin
+func (s *System) DeleteUser(u *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isLastAdmin(u) {
+		return ErrLastAdmin
+	}
+	delete(s.users, u.ID)
+	return nil
+}
+
+// UpdateUser calls fn with a copy of the user, if fn returns nil the copy replaces the user. fn
+// is called while the system is locked, so it must only change the fields being updated and must
+// not call other System methods, validate the changes before calling UpdateUser. ErrLastAdmin is
+// returned if the change would remove the admin role from the only admin
+func (s *System) UpdateUser(u *User, fn func(u *User) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated := *u
+	if err := fn(&updated); err != nil {
+		return err
+	}
+	if !updated.HasRole(RoleAdmin) && s.isLastAdmin(u) {
+		return ErrLastAdmin
+	}
+	*u = updated
+	return nil
+}
+
+// UseRecoveryCode returns true if the code is one of the user's recovery codes. The code is
+// removed while holding the lock, so two logins can't both use the same code
+func (s *System) UseRecoveryCode(u *User, code string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return u.UseRecoveryCode(code)
+}
+
+// UserSnapshot returns a copy of the user, so it can be read while the user is being updated.
+// Changes to the copy are not made to the user, call UpdateUser
+func (s *System) UserSnapshot(u *User) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := *u
+	return &snapshot
+}
+
+// isLastAdmin returns true if the user is the only admin, the caller must hold the mutex
+func (s *System) isLastAdmin(u *User) bool {
+	if !u.HasRole(RoleAdmin) {
+		return false
+	}
+	for _, other := range s.users {
+		if other.ID != u.ID && other.HasRole(RoleAdmin) {
+			return false
+		}
+	}
+	return true
+}
+
+// UserByID returns the user with the specified ID, nil if not found
+func (s *System) UserByID(ID string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.users[ID]
+}
+
+// UserPrefs returns the preferences of the user. Preferences are changed by calling SetUserPrefs,
+// so the returned value can be read safely while another request is updating them
+func (s *System) UserPrefs(u *User) UserPrefs {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return u.Prefs
+}
+
+// SetUserPrefs replaces the preferences of the user
+func (s *System) SetUserPrefs(u *User, prefs UserPrefs) {
+	s.mutex.Lock()
+	u.Prefs = prefs
+	s.mutex.Unlock()
+}
+
+// UserByLogin returns the user with the specified login, logins are not case sensitive. Returns
+// nil if not found
+func (s *System) UserByLogin(login string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, u := range s.users {
+		if strings.EqualFold(u.Login, login) {
+			return u
+		}
+	}
+	return nil
+}
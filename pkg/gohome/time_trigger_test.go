@@ -32,10 +32,9 @@ func TestSunrise(t *testing.T) {
 
 	wasTriggered := false
 	trigger := &gohome.TimeTrigger{
-		Time:   mt,
-		Mode:   gohome.TimeTriggerModeSunrise,
-		Offset: 0,
-		Days:   gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
+		Time: mt,
+		Mode: gohome.TimeTriggerModeSunrise,
+		Days: gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
 		Triggered: func() {
 			wasTriggered = true
 		},
@@ -62,10 +61,9 @@ func TestSunset(t *testing.T) {
 
 	wasTriggered := false
 	trigger := &gohome.TimeTrigger{
-		Time:   mt,
-		Mode:   gohome.TimeTriggerModeSunset,
-		Offset: 0,
-		Days:   gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
+		Time: mt,
+		Mode: gohome.TimeTriggerModeSunset,
+		Days: gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
 		Triggered: func() {
 			wasTriggered = true
 		},
@@ -112,63 +110,49 @@ func TestExactWithDate(t *testing.T) {
 func TestExactWithoutDate(t *testing.T) {
 	t.Parallel()
 
-	mt := MockTime{
-		// This is a monday
-		now: time.Date(2016, time.December, 5, 10, 10, 0, 0, time.UTC),
-		after: func(d time.Duration) <-chan time.Time {
-			// Return immediately
-			c := make(chan time.Time, 1)
-			c <- time.Now()
-			return c
-		},
+	// This is a monday
+	mt := MockTime{now: time.Date(2016, time.December, 5, 10, 10, 0, 0, time.UTC)}
+
+	// Each time the trigger waits, pretend the time has moved on to just after the
+	// next trigger time, so that the test can run over multiple days. We only want
+	// the trigger to fire on Monday and Friday, it stops waiting on Saturday
+	triggered := make(chan time.Weekday, 7)
+	mt.after = func(d time.Duration) <-chan time.Time {
+		mt.now = mt.now.Add(d + time.Second)
+		c := make(chan time.Time, 1)
+		if mt.now.Weekday() != time.Saturday {
+			c <- mt.now
+		}
+		return c
 	}
 
-	// This is the same as the current mock time
+	// This is the same as the current mock time, moved in to the future
 	at := time.Date(
 		0, 1, 1,
 		mt.Now().Hour(), mt.Now().Minute(), mt.Now().Second(), 0, mt.Now().Location())
-
-	// move in to the future
 	at = at.Add(time.Second)
 
-	evalCount := 0
-	wasTriggered := false
-	eval := func() {
-		// This is called each time the trigger evaluates if it should run
-
-		// If this is not the first time it is evaluating, then pretend we incremented
-		// the current time by 23 hours to the next day (not 24 incase we jump past the
-		// execution time), so that the test can run over multiple days. We only want the
-		// test to execute on Monday and Friday
-		if evalCount > 0 {
-			mt.now = mt.now.Add(time.Hour * 23)
-		}
-
-		// Start on Monday, if eval is one, it means we ran on monday, so we should have got
-		// an execute scene, then also on friday
-		if evalCount == 1 || evalCount == 5 {
-			require.True(t, wasTriggered)
-			wasTriggered = false
-		} else {
-			require.False(t, wasTriggered)
-		}
-		evalCount++
-	}
-
 	trigger := &gohome.TimeTrigger{
-		Time:       &mt,
-		Mode:       gohome.TimeTriggerModeExact,
-		Days:       gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
-		At:         at,
-		Evaluating: eval,
+		Time: &mt,
+		Mode: gohome.TimeTriggerModeExact,
+		Days: gohome.TimeTriggerDaysMon | gohome.TimeTriggerDaysFri,
+		At:   at,
 		Triggered: func() {
-			wasTriggered = true
+			triggered <- mt.now.Weekday()
 		},
 	}
 
 	ch := make(chan evtbus.Event)
 	trigger.StartConsuming(ch)
 
-	time.Sleep(time.Second * 2)
-	require.Equal(t, 6, evalCount)
+	var days []time.Weekday
+	for len(days) < 2 {
+		select {
+		case day := <-triggered:
+			days = append(days, day)
+		case <-time.After(time.Second * 10):
+			require.FailNow(t, "trigger did not fire", "days: %v", days)
+		}
+	}
+	require.Equal(t, []time.Weekday{time.Monday, time.Friday}, days)
 }
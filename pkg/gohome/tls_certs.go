@@ -0,0 +1,134 @@
+package gohome
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// tlsOrganization is the organization the certificates are issued to
+	tlsOrganization = "goHOME"
+
+	// tlsCAValidFor is how long the self signed CA is valid for
+	tlsCAValidFor = 10 * 365 * 24 * time.Hour
+
+	// tlsCertValidFor is how long the server certificate is valid for, browsers reject server
+	// certificates that are valid for longer than 825 days
+	tlsCertValidFor = 825 * 24 * time.Hour
+)
+
+// TLSCertPaths are the files GenerateTLSCerts writes the CA and server certificates and keys to
+type TLSCertPaths struct {
+	// CACert is the CA certificate, users install it in their browsers so the server
+	// certificate is trusted
+	CACert string
+
+	// CAKey is the private key of the CA, it is only needed to create new server certificates
+	CAKey string
+
+	// Cert is the server certificate
+	Cert string
+
+	// Key is the private key of the server certificate
+	Key string
+}
+
+// GenerateTLSCerts creates a self signed CA and a server certificate signed by the CA that is
+// valid for hosts, which can be IP addresses or host names. The certificates are PEM encoded,
+// the private keys are only readable by the current user
+func GenerateTLSCerts(paths TLSCertPaths, hosts []string, now time.Time) error {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caSerial, err := tlsSerialNumber()
+	if err != nil {
+		return err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          caSerial,
+		Subject:               pkix.Name{Organization: []string{tlsOrganization}, CommonName: tlsOrganization + " CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(tlsCAValidFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := tlsSerialNumber()
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{tlsOrganization}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(tlsCertValidFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(paths.CACert, "CERTIFICATE", caDER, 0644); err != nil {
+		return err
+	}
+	if err := writeECKey(paths.CAKey, caKey); err != nil {
+		return err
+	}
+	if err := writePEM(paths.Cert, "CERTIFICATE", certDER, 0644); err != nil {
+		return err
+	}
+	return writeECKey(paths.Key, key)
+}
+
+// tlsSerialNumber returns a random certificate serial number
+func tlsSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writeECKey(path string, key *ecdsa.PrivateKey) error {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, "EC PRIVATE KEY", b, 0600)
+}
+
+func writePEM(path, blockType string, b []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), perm)
+}
@@ -0,0 +1,54 @@
+package gohome_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTLSCerts(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "gohome-tls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	paths := gohome.TLSCertPaths{
+		CACert: filepath.Join(dir, "ca.crt"),
+		CAKey:  filepath.Join(dir, "ca.key"),
+		Cert:   filepath.Join(dir, "server.crt"),
+		Key:    filepath.Join(dir, "server.key"),
+	}
+	now := time.Now()
+	require.Nil(t, gohome.GenerateTLSCerts(paths, []string{"192.168.0.10", "gohome.local"}, now))
+
+	// The private keys are only readable by the owner
+	info, err := os.Stat(paths.Key)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	pair, err := tls.LoadX509KeyPair(paths.Cert, paths.Key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.Nil(t, err)
+
+	caPEM, err := ioutil.ReadFile(paths.CACert)
+	require.Nil(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	// The server certificate is trusted for each host once the CA is installed
+	for _, host := range []string{"192.168.0.10", "gohome.local"} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, CurrentTime: now})
+		require.Nil(t, err, host)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "192.168.0.11", Roots: roots, CurrentTime: now})
+	require.NotNil(t, err)
+}
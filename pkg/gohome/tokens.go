@@ -0,0 +1,305 @@
+package gohome
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/clock"
+	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/validation"
+)
+
+// tokenLastUsedResolution is how much the last used time has to change before the tokens need to
+// be saved, so every request doesn't cause a write
+const tokenLastUsedResolution = time.Minute
+
+// Token is a long lived API token, used by scripts and integrations that can't log in. Requests
+// made with a token act as the user that owns it, limited to the token's role
+type Token struct {
+	// ID identifies the token, it is a hash of the token secret so it can be shown to users and
+	// saved to disk without allowing anyone to use the token
+	ID string `json:"id"`
+
+	// Name describes what the token is used for, such as "kitchen dashboard", it is unique for
+	// each user
+	Name string `json:"name"`
+
+	// UserID is the ID of the user that owns the token
+	UserID string `json:"userId"`
+
+	// Role limits what the token can do to a role lower than the owner's role. If empty the token
+	// has the same role as its owner
+	Role string `json:"role"`
+
+	// Created is when the token was created
+	Created time.Time `json:"created"`
+
+	// LastUsed is the last time the token was used, zero if it has never been used
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// User returns the user the token acts as, which is the owner with the role limited to the role
+// of the token. The returned user is a copy and must only be used to check permissions
+func (t Token) User(owner *User) *User {
+	// The owner's role may have been lowered after the token was created, the token never has
+	// more access than its owner
+	if t.Role == "" || t.Role == owner.Role || !owner.HasRole(t.Role) {
+		return owner
+	}
+
+	u := *owner
+	u.Role = t.Role
+	return &u
+}
+
+// Tokens manages the API tokens. Tokens are saved to Path, if Path is empty tokens are only
+// kept in memory
+type Tokens struct {
+	// Path is the file the tokens are saved to
+	Path string
+
+	// Time is the source of the current time, used to record when tokens are used
+	Time clock.Time
+
+	mutex  sync.RWMutex
+	tokens map[string]*Token
+	dirty  bool
+	done   chan bool
+}
+
+// NewTokens returns a newly instantiated Tokens instance
+func NewTokens(path string) *Tokens {
+	return &Tokens{
+		Path:   path,
+		Time:   clock.SystemTime{},
+		tokens: make(map[string]*Token),
+	}
+}
+
+// TokenID returns the ID of the token that the token secret belongs to
+func TokenID(secret string) string {
+	return SessionID(secret)
+}
+
+// Load loads the saved tokens, if there are no saved tokens there is no error
+func (t *Tokens) Load() error {
+	if t.Path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(t.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []*Token
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.tokens = make(map[string]*Token)
+	for _, token := range saved {
+		t.tokens[token.ID] = token
+	}
+	return nil
+}
+
+// Add creates a new token for the user and returns the token secret back to the caller, the
+// secret is the only way to use the token so it is never saved and can't be retrieved later.
+// role can be empty for the token to have the same role as the user. If the name or role are
+// not valid a *validation.Errors is returned. You must call Save() at some point to persist the
+// tokens to disk
+func (t *Tokens) Add(user *User, name, role string) (string, Token, error) {
+	if name == "" {
+		return "", Token{}, validation.NewErrors("Name", "required field", false)
+	}
+	if role != "" {
+		if !IsValidRole(role) {
+			return "", Token{}, validation.NewErrors("Role", fmt.Sprintf("invalid role, must be one of [%s|%s|%s]",
+				RoleAdmin, RoleMember, RoleGuest), false)
+		}
+		if !user.HasRole(role) {
+			return "", Token{}, validation.NewErrors("Role", "the role can't be higher than the role of the user", false)
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, token := range t.tokens {
+		if token.UserID == user.ID && token.Name == name {
+			return "", Token{}, validation.NewErrors("Name", "the user already has a token with this name", false)
+		}
+	}
+
+	token := &Token{
+		ID:      TokenID(secret),
+		Name:    name,
+		UserID:  user.ID,
+		Role:    role,
+		Created: t.Time.Now(),
+	}
+	t.tokens[token.ID] = token
+	t.dirty = true
+	return secret, *token, nil
+}
+
+// Get returns the token the secret belongs to and records that the token was used. If the secret
+// is not valid it returns false as the second return value
+func (t *Tokens) Get(secret string) (Token, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	token, ok := t.tokens[TokenID(secret)]
+	if !ok {
+		return Token{}, false
+	}
+
+	now := t.Time.Now()
+	if now.Sub(token.LastUsed) >= tokenLastUsedResolution {
+		token.LastUsed = now
+		t.dirty = true
+	}
+	return *token, true
+}
+
+// Valid returns true if the token with the specified ID exists. Unlike Get it doesn't record that
+// the token was used
+func (t *Tokens) Valid(ID string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	_, ok := t.tokens[ID]
+	return ok
+}
+
+// UserTokens returns all of the tokens owned by the user, newest first
+func (t *Tokens) UserTokens(userID string) []Token {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var out []Token
+	for _, token := range t.tokens {
+		if token.UserID == userID {
+			out = append(out, *token)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.After(out[j].Created)
+	})
+	return out
+}
+
+// Delete removes the token with the specified ID, returns false if the token was not found
+func (t *Tokens) Delete(ID string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.tokens[ID]; !ok {
+		return false
+	}
+	delete(t.tokens, ID)
+	t.dirty = true
+	return true
+}
+
+// DeleteUserTokens removes all of the tokens owned by the user, returns the number of tokens removed
+func (t *Tokens) DeleteUserTokens(userID string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	count := 0
+	for ID, token := range t.tokens {
+		if token.UserID == userID {
+			delete(t.tokens, ID)
+			count++
+		}
+	}
+	if count > 0 {
+		t.dirty = true
+	}
+	return count
+}
+
+// Save persists the tokens to disk, if nothing has changed since the last save nothing is written
+func (t *Tokens) Save() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.Path == "" || !t.dirty {
+		return nil
+	}
+
+	tokens := make([]*Token, 0, len(t.tokens))
+	for _, token := range t.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file then rename it, so a crash never leaves a partially written file
+	tmpPath := filepath.Join(filepath.Dir(t.Path), "."+filepath.Base(t.Path)+".tmp")
+	if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, t.Path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	t.dirty = false
+	return nil
+}
+
+// Start periodically saves when the tokens were last used, until Stop is called
+func (t *Tokens) Start() {
+	t.done = make(chan bool)
+	done := t.done
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.Time.After(sessionPurgePeriod):
+			}
+
+			if err := t.Save(); err != nil {
+				log.E("Tokens - failed to save tokens: %s", err)
+			}
+		}
+	}()
+}
+
+// Stop stops saving the tokens periodically and saves any changes
+func (t *Tokens) Stop() {
+	if t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+	if err := t.Save(); err != nil {
+		log.E("Tokens - failed to save tokens: %s", err)
+	}
+}
@@ -0,0 +1,71 @@
+package gohome_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokensPersistAndLimitRole(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "gohome-tokens")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2017, time.January, 10, 10, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "tokens.json")
+	tokens := gohome.NewTokens(path)
+	tokens.Time = MockTime{now: start}
+
+	member := &gohome.User{ID: "user1", Login: "bob", Role: gohome.RoleMember}
+	secret, token, err := tokens.Add(member, "kitchen dashboard", gohome.RoleGuest)
+	require.Nil(t, err)
+	require.Equal(t, gohome.TokenID(secret), token.ID)
+	require.True(t, token.LastUsed.IsZero())
+
+	// Names are unique for each user and tokens can't have more access than the user
+	_, _, err = tokens.Add(member, "kitchen dashboard", "")
+	require.NotNil(t, err)
+	_, _, err = tokens.Add(member, "cron", gohome.RoleAdmin)
+	require.NotNil(t, err)
+	_, _, err = tokens.Add(member, "", "")
+	require.NotNil(t, err)
+	require.Nil(t, tokens.Save())
+
+	// Only a hash of the secret is saved
+	b, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), secret)
+
+	loaded := gohome.NewTokens(path)
+	loaded.Time = MockTime{now: start.Add(time.Hour)}
+	require.Nil(t, loaded.Load())
+
+	token, ok := loaded.Get(secret)
+	require.True(t, ok)
+	require.Equal(t, "kitchen dashboard", token.Name)
+	require.Equal(t, start.Add(time.Hour), token.LastUsed)
+	_, ok = loaded.Get("not a token")
+	require.False(t, ok)
+
+	user := token.User(member)
+	require.Equal(t, gohome.RoleGuest, user.Role)
+	require.Equal(t, gohome.RoleMember, member.Role)
+
+	// If the owner's role is lowered, the token doesn't keep the higher role
+	guest := &gohome.User{ID: "user1", Login: "bob", Role: gohome.RoleGuest}
+	require.Equal(t, guest, gohome.Token{Role: gohome.RoleMember}.User(guest))
+
+	require.True(t, loaded.Valid(token.ID))
+	require.True(t, loaded.Delete(token.ID))
+	_, ok = loaded.Get(secret)
+	require.False(t, ok)
+	require.False(t, loaded.Valid(token.ID))
+	require.Equal(t, 0, len(loaded.UserTokens("user1")))
+}
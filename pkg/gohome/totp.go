@@ -0,0 +1,165 @@
+package gohome
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPIssuer is the name authenticator apps show next to the user's login
+	TOTPIssuer = "goHOME"
+
+	// RecoveryCodeCount is the number of recovery codes a user gets when they enrol
+	RecoveryCodeCount = 10
+
+	// totpPeriod is how long each TOTP code is valid for
+	totpPeriod = 30 * time.Second
+
+	// totpDigits is the number of digits in a TOTP code
+	totpDigits = 6
+
+	// totpSkew is the number of periods before and after the current one that codes are accepted
+	// for, so clocks that are slightly out of sync still work
+	totpSkew = 1
+)
+
+// totpEncoding is how TOTP secrets are encoded, authenticator apps expect unpadded base32
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random TOTP secret, base32 encoded
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPCode returns the RFC 6238 code for the secret at the time counter, a counter is the number
+// of periods since the unix epoch
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// TOTPCounter returns the TOTP time counter for the time
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// TOTPURI returns the otpauth URI for the user's TOTP secret, authenticator apps can add the
+// account by scanning it as a QR code
+func (u *User) TOTPURI() string {
+	label := url.PathEscape(TOTPIssuer + ":" + u.Login)
+	params := url.Values{}
+	params.Set("secret", u.TOTPSecret)
+	params.Set("issuer", TOTPIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// VerifyTOTP checks the code against the user's TOTP secret at the time now. If the code is valid
+// the time counter it matched is returned, callers should reject codes whose counter is not after
+// the last one used so a code can't be used twice
+func (u *User) VerifyTOTP(code string, now time.Time) (int64, bool) {
+	if u.TOTPSecret == "" || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPCounter(now)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected, err := TOTPCode(u.TOTPSecret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes with new ones, the codes are returned
+// so they can be shown to the user once, only hashes of the codes are kept
+func (u *User) GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = recoveryCodeHash(codes[i])
+	}
+	u.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// UseRecoveryCode returns true if the code is one of the user's recovery codes, the code is
+// removed so it can't be used again. The user must be saved after a code is used
+func (u *User) UseRecoveryCode(code string) bool {
+	hash := recoveryCodeHash(code)
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes
+func (u *User) DisableTOTP() {
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.RecoveryCodes = nil
+}
+
+// recoveryCodeHash returns the hash of a recovery code, codes are not case sensitive and the
+// dash is optional so they are easy to type
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// TOTPLimited returns the user to check the permissions of a logged in session against. If admins
+// must use two factor authentication and the user is an admin who hasn't enrolled, they only have
+// the permissions of a member until they enrol. The returned user must only be used to check
+// permissions
+func (u *User) TOTPLimited(requireAdminTOTP bool) *User {
+	if !requireAdminTOTP || u.TOTPEnabled || !u.HasRole(RoleAdmin) {
+		return u
+	}
+
+	limited := *u
+	limited.Role = RoleMember
+	return &limited
+}
@@ -0,0 +1,99 @@
+package gohome_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6238 test vectors, truncated to 6 digits. The secret is "12345678901234567890" base32 encoded
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	code, err := gohome.TOTPCode(secret, gohome.TOTPCounter(time.Unix(59, 0)))
+	require.Nil(t, err)
+	require.Equal(t, "287082", code)
+	code, err = gohome.TOTPCode(secret, gohome.TOTPCounter(time.Unix(1111111109, 0)))
+	require.Nil(t, err)
+	require.Equal(t, "081804", code)
+
+	user := &gohome.User{ID: "user1", Login: "bob", Role: gohome.RoleAdmin, TOTPSecret: secret}
+	now := time.Unix(1111111109, 0)
+	counter, ok := user.VerifyTOTP("081804", now)
+	require.True(t, ok)
+	require.Equal(t, gohome.TOTPCounter(now), counter)
+
+	// Codes from the periods either side of the current one are accepted, for clock skew
+	_, ok = user.VerifyTOTP("081804", now.Add(30*time.Second))
+	require.True(t, ok)
+	_, ok = user.VerifyTOTP("081804", now.Add(5*time.Minute))
+	require.False(t, ok)
+	_, ok = user.VerifyTOTP("", now)
+	require.False(t, ok)
+
+	require.Equal(t, "otpauth://totp/goHOME:bob?algorithm=SHA1&digits=6&issuer=goHOME&period=30&secret="+secret, user.TOTPURI())
+
+	// Admins that must enrol only have member permissions until they do
+	require.Equal(t, gohome.RoleMember, user.TOTPLimited(true).Role)
+	require.Equal(t, gohome.RoleAdmin, user.TOTPLimited(false).Role)
+	user.TOTPEnabled = true
+	require.Equal(t, gohome.RoleAdmin, user.TOTPLimited(true).Role)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	t.Parallel()
+
+	user := &gohome.User{ID: "user1", Login: "bob"}
+	codes, err := user.GenerateRecoveryCodes()
+	require.Nil(t, err)
+	require.Equal(t, gohome.RecoveryCodeCount, len(codes))
+	require.NotContains(t, user.RecoveryCodes, codes[0])
+
+	// Codes can only be used once, and are not case sensitive
+	require.True(t, user.UseRecoveryCode(codes[0]))
+	require.False(t, user.UseRecoveryCode(codes[0]))
+	require.True(t, user.UseRecoveryCode(" "+strings.ToUpper(codes[1])))
+	require.False(t, user.UseRecoveryCode("not-a-code"))
+	require.Equal(t, gohome.RecoveryCodeCount-2, len(user.RecoveryCodes))
+
+	user.DisableTOTP()
+	require.False(t, user.UseRecoveryCode(codes[2]))
+}
+
+func TestSystemUseRecoveryCodeOnlyOnce(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("totp")
+	user := &gohome.User{ID: "user1", Login: "bob", Role: gohome.RoleAdmin}
+	sys.AddUser(user)
+	codes, err := user.GenerateRecoveryCodes()
+	require.Nil(t, err)
+
+	// Logins racing to use the same code, only one of them can succeed
+	var wg sync.WaitGroup
+	used := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			used <- sys.UseRecoveryCode(user, codes[0])
+		}()
+	}
+	wg.Wait()
+	close(used)
+
+	count := 0
+	for ok := range used {
+		if ok {
+			count++
+		}
+	}
+	require.Equal(t, 1, count)
+	require.Equal(t, gohome.RecoveryCodeCount-1, len(sys.UserSnapshot(user).RecoveryCodes))
+	require.False(t, sys.UseRecoveryCode(user, codes[0]))
+}
@@ -2,8 +2,11 @@ package gohome
 
 import (
 	"encoding/base64"
+	"fmt"
 	"math/rand"
+	"strings"
 
+	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/validation"
 	errExt "github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
@@ -13,13 +16,119 @@ import (
 type UserPrefs struct {
 	// UI are user UI preference settings
 	UI UIPrefs
+
+	// TempUnit is the unit temperatures are displayed in for the user, either attr.UTCelcius
+	// or attr.UTFarenheit. If empty, temperatures are shown in the units the hardware uses
+	TempUnit string
 }
 
 // UIPrefs contains preferences for the UI
 type UIPrefs struct {
-	// HiddenZones is a map keyed by zone IDs for zones that should
+	// HiddenFeatures is a map keyed by feature IDs for features that should
 	// not be displayed in the UI.
-	HiddenZones map[string]bool
+	HiddenFeatures map[string]bool
+
+	// FeatureOrder is the order the user wants features displayed in, features not
+	// in the list are displayed after the ordered features
+	FeatureOrder []string
+
+	// FavoriteScenes are the IDs of the scenes the user wants quick access to, in order
+	FavoriteScenes []string
+
+	// LandingAreaID is the ID of the area the UI shows when the user first opens the app,
+	// if empty the root area is shown
+	LandingAreaID string
+}
+
+// Prune returns a copy of the preferences without the IDs of features, scenes and areas that
+// have been removed from the system, so preferences saved before they were removed still validate
+func (p UserPrefs) Prune(sys *System) UserPrefs {
+	if p.UI.HiddenFeatures != nil {
+		hidden := make(map[string]bool)
+		for featureID, val := range p.UI.HiddenFeatures {
+			if sys.FeatureByID(featureID) != nil {
+				hidden[featureID] = val
+			}
+		}
+		p.UI.HiddenFeatures = hidden
+	}
+
+	if p.UI.FeatureOrder != nil {
+		order := make([]string, 0, len(p.UI.FeatureOrder))
+		for _, featureID := range p.UI.FeatureOrder {
+			if sys.FeatureByID(featureID) != nil {
+				order = append(order, featureID)
+			}
+		}
+		p.UI.FeatureOrder = order
+	}
+
+	if p.UI.FavoriteScenes != nil {
+		scenes := make([]string, 0, len(p.UI.FavoriteScenes))
+		for _, sceneID := range p.UI.FavoriteScenes {
+			if sys.SceneByID(sceneID) != nil {
+				scenes = append(scenes, sceneID)
+			}
+		}
+		p.UI.FavoriteScenes = scenes
+	}
+
+	if p.UI.LandingAreaID != "" && sys.AreaByID(p.UI.LandingAreaID) == nil {
+		p.UI.LandingAreaID = ""
+	}
+	return p
+}
+
+// Validate verifies the preferences are in a good state, all of the IDs must reference
+// items that exist in the system
+func (p *UserPrefs) Validate(sys *System) *validation.Errors {
+	errors := &validation.Errors{}
+
+	if p.TempUnit != "" && !attr.IsTempUnit(p.TempUnit) {
+		errors.Add(fmt.Sprintf("invalid unit, must be one of [%s|%s]", attr.UTCelcius, attr.UTFarenheit), "TempUnit")
+	}
+
+	for featureID := range p.UI.HiddenFeatures {
+		if sys.FeatureByID(featureID) == nil {
+			errors.Add(fmt.Sprintf("invalid feature ID: %s", featureID), "HiddenFeatures")
+			break
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, featureID := range p.UI.FeatureOrder {
+		if sys.FeatureByID(featureID) == nil {
+			errors.Add(fmt.Sprintf("invalid feature ID: %s", featureID), "FeatureOrder")
+			break
+		}
+		if seen[featureID] {
+			errors.Add(fmt.Sprintf("duplicate feature ID: %s", featureID), "FeatureOrder")
+			break
+		}
+		seen[featureID] = true
+	}
+
+	seen = make(map[string]bool)
+	for _, sceneID := range p.UI.FavoriteScenes {
+		if sys.SceneByID(sceneID) == nil {
+			errors.Add(fmt.Sprintf("invalid scene ID: %s", sceneID), "FavoriteScenes")
+			break
+		}
+		if seen[sceneID] {
+			errors.Add(fmt.Sprintf("duplicate scene ID: %s", sceneID), "FavoriteScenes")
+			break
+		}
+		seen[sceneID] = true
+	}
+
+	if p.UI.LandingAreaID != "" && sys.AreaByID(p.UI.LandingAreaID) == nil {
+		errors.Add(fmt.Sprintf("invalid area ID: %s", p.UI.LandingAreaID), "LandingAreaID")
+	}
+
+	if errors.Has() {
+		return errors
+	}
+	return nil
 }
 
 // User represents a user of the system
@@ -29,14 +138,42 @@ type User struct {
 	Prefs     UserPrefs
 	HashedPwd string
 	Salt      string
+
+	// Role is what the user is allowed to do, one of RoleAdmin, RoleMember or RoleGuest
+	Role string
+
+	// Grants restrict the user to the specified areas, features and scenes. Guests can only
+	// access what they have been granted, members with no grants can access everything
+	Grants []Grant
+
+	// TOTPSecret is the base32 encoded secret used to generate two factor authentication codes,
+	// empty if the user has not started enrolling
+	TOTPSecret string
+
+	// TOTPEnabled is true once the user has confirmed their authenticator app works, after which
+	// they must enter a code or a recovery code when they log in
+	TOTPEnabled bool
+
+	// RecoveryCodes are hashes of the single use codes the user can log in with if they lose
+	// their authenticator app
+	RecoveryCodes []string
 }
 
-// Validate verifies the user object is in a good state
-func (u *User) Validate() *validation.Errors {
+// Validate verifies the user object is in a good state. Logins must be unique in the system, they
+// are not case sensitive
+func (u *User) Validate(sys *System) *validation.Errors {
 	errors := &validation.Errors{}
 
 	if u.Login == "" {
 		errors.Add("required field", "Login")
+	} else if strings.Contains(u.Login, "/") {
+		errors.Add("the login can't contain a /", "Login")
+	} else if other := sys.UserByLogin(u.Login); other != nil && other.ID != u.ID {
+		errors.Add("a user with this login already exists", "Login")
+	}
+
+	if !IsValidRole(u.Role) {
+		errors.Add(fmt.Sprintf("invalid role, must be one of [%s|%s|%s]", RoleAdmin, RoleMember, RoleGuest), "Role")
 	}
 
 	if errors.Has() {
@@ -0,0 +1,41 @@
+package gohome
+
+import (
+	"strings"
+
+	"github.com/go-home-iot/event-bus"
+)
+
+// UserLockedOutTrigger is a trigger that fires when logins are locked out because of too many
+// failed login attempts
+type UserLockedOutTrigger struct {
+	// Login if not empty, the trigger only fires when this login is locked out
+	Login     string
+	Triggered func()
+}
+
+func (t *UserLockedOutTrigger) ConsumerName() string {
+	return "UserLockedOutTrigger"
+}
+
+func (t *UserLockedOutTrigger) StartConsuming(ch chan evtbus.Event) {
+	go func() {
+		for evt := range ch {
+			lockedOutEvt, ok := evt.(*UserLockedOutEvt)
+			if !ok {
+				continue
+			}
+
+			if t.Login == "" || strings.ToLower(t.Login) == strings.ToLower(lockedOutEvt.Login) {
+				t.Triggered()
+			}
+		}
+	}()
+}
+
+func (t *UserLockedOutTrigger) StopConsuming() {
+}
+
+func (t *UserLockedOutTrigger) Trigger() {
+	t.Triggered()
+}
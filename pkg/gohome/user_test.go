@@ -0,0 +1,118 @@
+package gohome_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserValidateUniqueLogin(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("users")
+	bob := &gohome.User{ID: "user1", Login: "Bob", Role: gohome.RoleMember}
+	require.Nil(t, bob.Validate(sys))
+	sys.AddUser(bob)
+	require.Nil(t, bob.Validate(sys))
+
+	// Logins are not case sensitive, the same as when logging in
+	other := &gohome.User{ID: "user2", Login: "bob", Role: gohome.RoleMember}
+	require.NotNil(t, other.Validate(sys))
+	require.Equal(t, bob, sys.UserByLogin("BOB"))
+
+	other.Login = "alice"
+	require.Nil(t, other.Validate(sys))
+	other.Login = "alice/bob"
+	require.NotNil(t, other.Validate(sys))
+
+	require.Nil(t, sys.DeleteUser(bob))
+	require.Nil(t, sys.UserByLogin("bob"))
+	require.Nil(t, sys.UserByID("user1"))
+}
+
+func TestSystemUpdateUserKeepsAnAdmin(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("users")
+	alice := &gohome.User{ID: "user1", Login: "alice", Role: gohome.RoleAdmin}
+	bob := &gohome.User{ID: "user2", Login: "bob", Role: gohome.RoleAdmin}
+	sys.AddUser(alice)
+	sys.AddUser(bob)
+
+	demote := func(u *gohome.User) error {
+		u.Role = gohome.RoleMember
+		return nil
+	}
+
+	// Only one of the admins can be demoted, even if they are demoted at the same time
+	errs := make(chan error, 2)
+	go func() { errs <- sys.UpdateUser(alice, demote) }()
+	go func() { errs <- sys.UpdateUser(bob, demote) }()
+	err1, err2 := <-errs, <-errs
+	require.True(t, (err1 == nil) != (err2 == nil))
+	require.True(t, err1 == gohome.ErrLastAdmin || err2 == gohome.ErrLastAdmin)
+
+	admin := alice
+	if !alice.HasRole(gohome.RoleAdmin) {
+		admin = bob
+	}
+	require.True(t, admin.HasRole(gohome.RoleAdmin))
+	require.Equal(t, gohome.ErrLastAdmin, sys.DeleteUser(admin))
+	require.NotNil(t, sys.UserByID(admin.ID))
+
+	// Preferences saved after the snapshot was taken are kept by the update
+	snapshot := sys.UserSnapshot(admin)
+	snapshot.Login = "carol"
+	sys.SetUserPrefs(admin, gohome.UserPrefs{TempUnit: attr.UTCelcius})
+	require.Nil(t, sys.UpdateUser(admin, func(u *gohome.User) error {
+		u.Login = snapshot.Login
+		return nil
+	}))
+	require.Equal(t, "carol", admin.Login)
+	require.Equal(t, attr.UTCelcius, sys.UserPrefs(admin).TempUnit)
+
+	// Nothing is changed if fn fails
+	require.NotNil(t, sys.UpdateUser(admin, func(u *gohome.User) error {
+		u.Login = "dave"
+		return errors.New("invalid")
+	}))
+	require.Equal(t, "carol", admin.Login)
+}
+
+func TestUserPrefsPruneRemovedItems(t *testing.T) {
+	t.Parallel()
+
+	sys := gohome.NewSystem("prefs")
+	scene := &gohome.Scene{ID: "scene1", Name: "Evening"}
+	sys.AddScene(scene)
+
+	prefs := gohome.UserPrefs{
+		UI: gohome.UIPrefs{
+			HiddenFeatures: map[string]bool{"removed": true},
+			FeatureOrder:   []string{"removed"},
+			FavoriteScenes: []string{"scene1", "removed"},
+			LandingAreaID:  "removed",
+		},
+	}
+	require.NotNil(t, prefs.Validate(sys))
+
+	// Preferences that reference removed items can still be changed once they are pruned
+	pruned := prefs.Prune(sys)
+	require.Nil(t, pruned.Validate(sys))
+	require.Equal(t, 0, len(pruned.UI.HiddenFeatures))
+	require.Equal(t, []string{}, pruned.UI.FeatureOrder)
+	require.Equal(t, []string{"scene1"}, pruned.UI.FavoriteScenes)
+	require.Equal(t, "", pruned.UI.LandingAreaID)
+
+	// The original preferences are not modified
+	require.True(t, prefs.UI.HiddenFeatures["removed"])
+	require.Equal(t, []string{"scene1", "removed"}, prefs.UI.FavoriteScenes)
+
+	user := &gohome.User{ID: "user1", Login: "bob"}
+	sys.AddUser(user)
+	sys.SetUserPrefs(user, pruned)
+	require.Equal(t, pruned, sys.UserPrefs(user))
+}
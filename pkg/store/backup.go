@@ -0,0 +1,531 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	errExt "github.com/pkg/errors"
+)
+
+// BackupVersion is the version of the backup file format, backups created by a newer version
+// of goHOME can't be restored
+const BackupVersion = 1
+
+// MaxBackupSize is the maximum number of uncompressed bytes read from a backup
+const MaxBackupSize = 256 * 1024 * 1024
+
+const (
+	backupManifestName  = "manifest.json"
+	backupConfigName    = "config.json"
+	backupSystemName    = "system"
+	backupKeyName       = "gohome.key"
+	backupEventsName    = "events.json"
+	backupAutomationDir = "automation/"
+	backupHistoryDir    = "history/"
+)
+
+// ErrBackupTooLarge is returned when a backup is larger than MaxBackupSize
+var ErrBackupTooLarge = errors.New("backup is too large")
+
+// BackupOptions specifies the optional contents of a backup, the system file, config, key file
+// and automation scripts are always included
+type BackupOptions struct {
+	// IncludeEvents includes the event log
+	IncludeEvents bool
+
+	// IncludeHistory includes the attribute history
+	IncludeHistory bool
+}
+
+// BackupManifest describes the contents of a backup
+type BackupManifest struct {
+	Version       int          `json:"version"`
+	Created       time.Time    `json:"created"`
+	SystemVersion string       `json:"systemVersion"`
+	StoreType     string       `json:"storeType"`
+	Files         []BackupFile `json:"files"`
+}
+
+// BackupFile is a file in a backup, the checksum is used to verify the file before it is restored
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup is a backup that has been read and verified, ready to be restored
+type Backup struct {
+	Manifest BackupManifest
+
+	// Config is the config of the system that was backed up
+	Config gohome.Config
+
+	files map[string][]byte
+}
+
+// CreateBackup writes a gzipped tar file containing the system file, config, key file and automation
+// scripts to w, plus the event log and history if they are specified in opts. A manifest containing
+// the checksum of every file is written as the last entry
+func CreateBackup(w io.Writer, cfg *gohome.Config, opts BackupOptions) (*BackupManifest, error) {
+	manifest := &BackupManifest{
+		Version:   BackupVersion,
+		Created:   time.Now().UTC(),
+		StoreType: storeType(cfg.StoreType),
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, b []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(b)),
+			ModTime:  manifest.Created,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(b)
+		manifest.Files = append(manifest.Files, BackupFile{
+			Name:   name,
+			Size:   int64(len(b)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		return nil
+	}
+
+	// Add all of the files in the directory, missing directories are skipped
+	addDir := func(prefix, dir string) error {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil
+		}
+		return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return add(prefix+filepath.ToSlash(rel), b)
+		})
+	}
+
+	cfgBytes, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := add(backupConfigName, cfgBytes); err != nil {
+		return nil, err
+	}
+
+	system, err := ioutil.ReadFile(cfg.SystemPath)
+	if err != nil {
+		return nil, errExt.Wrap(err, "failed to read system file")
+	}
+	sysJSON, err := systemFileToJSON(manifest.StoreType, system)
+	if err != nil {
+		return nil, errExt.Wrap(err, "failed to read system file")
+	}
+	if manifest.SystemVersion, err = systemFileVersion(sysJSON); err != nil {
+		return nil, err
+	}
+	if err := add(backupSystemName, system); err != nil {
+		return nil, err
+	}
+
+	// Without the key the device credentials in the system file can't be read
+	key, err := ioutil.ReadFile(cfg.KeyFilePath())
+	if err == nil {
+		err = add(backupKeyName, key)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errExt.Wrap(err, "failed to read key file")
+	}
+
+	if err := addDir(backupAutomationDir, cfg.AutomationPath); err != nil {
+		return nil, errExt.Wrap(err, "failed to read automation scripts")
+	}
+
+	if opts.IncludeEvents {
+		events, err := ioutil.ReadFile(cfg.EventLogPath)
+		if err == nil {
+			err = add(backupEventsName, events)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errExt.Wrap(err, "failed to read event log")
+		}
+	}
+
+	if opts.IncludeHistory {
+		if err := addDir(backupHistoryDir, cfg.HistoryDirPath()); err != nil {
+			return nil, errExt.Wrap(err, "failed to read history")
+		}
+	}
+
+	// The manifest describes the other files so it has to be written last
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:     backupManifestName,
+		Mode:     0600,
+		Size:     int64(len(b)),
+		ModTime:  manifest.Created,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ReadBackup reads a backup created by CreateBackup and verifies it can be restored, every file
+// must match its checksum in the manifest and the system file must be readable with the key
+// file in the backup. Nothing is written to disk.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errExt.Wrap(err, "not a gzip file")
+	}
+	defer gz.Close()
+
+	// Guard against small files that decompress to something huge
+	limited := &io.LimitedReader{R: gz, N: MaxBackupSize + 1}
+	tr := tar.NewReader(limited)
+
+	backup := &Backup{files: make(map[string][]byte)}
+	var manifestBytes []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errExt.Wrap(err, "invalid tar file")
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unsupported entry: %s", hdr.Name)
+		}
+		if !validBackupName(hdr.Name) {
+			return nil, fmt.Errorf("invalid file name: %s", hdr.Name)
+		}
+
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if limited.N <= 0 {
+			return nil, ErrBackupTooLarge
+		}
+
+		if hdr.Name == backupManifestName {
+			manifestBytes = b
+			continue
+		}
+		if _, ok := backup.files[hdr.Name]; ok {
+			return nil, fmt.Errorf("duplicate file: %s", hdr.Name)
+		}
+		backup.files[hdr.Name] = b
+	}
+
+	if manifestBytes == nil {
+		return nil, fmt.Errorf("missing %s", backupManifestName)
+	}
+	if err := json.Unmarshal(manifestBytes, &backup.Manifest); err != nil {
+		return nil, errExt.Wrap(err, "invalid manifest")
+	}
+	if err := backup.verify(); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// verify checks the files against the manifest and makes sure the config and system file can be read
+func (b *Backup) verify() error {
+	m := b.Manifest
+	if m.Version <= 0 || m.Version > BackupVersion {
+		return fmt.Errorf("unsupported backup version: %d, this version of goHOME supports up to %d", m.Version, BackupVersion)
+	}
+
+	inManifest := make(map[string]bool)
+	for _, f := range m.Files {
+		content, ok := b.files[f.Name]
+		if !ok {
+			return fmt.Errorf("missing file: %s", f.Name)
+		}
+
+		sum := sha256.Sum256(content)
+		if int64(len(content)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return fmt.Errorf("checksum mismatch, file is corrupt: %s", f.Name)
+		}
+		inManifest[f.Name] = true
+	}
+	for name := range b.files {
+		if !inManifest[name] {
+			return fmt.Errorf("file not in manifest: %s", name)
+		}
+	}
+
+	cfgBytes, ok := b.files[backupConfigName]
+	if !ok {
+		return fmt.Errorf("missing %s", backupConfigName)
+	}
+	if err := json.Unmarshal(cfgBytes, &b.Config); err != nil {
+		return errExt.Wrap(err, "invalid config file")
+	}
+
+	system, ok := b.files[backupSystemName]
+	if !ok {
+		return fmt.Errorf("missing system file")
+	}
+	if err := validateSystemFile(m.StoreType, system, b.files[backupKeyName]); err != nil {
+		return errExt.Wrap(err, "invalid system file")
+	}
+	return nil
+}
+
+// ErrPartialRestore is returned by Restore when some of the files were replaced but then another
+// file couldn't be. The files on disk may not work together, e.g. the key may not decrypt the
+// system file, so the running system must not save any changes until the backup is restored again
+var ErrPartialRestore = errors.New("the backup was only partially restored")
+
+// Restore writes the contents of the backup to the locations specified in cfg, existing files are
+// replaced. The backup must have been created with the same store type as cfg. Every file is
+// written to a temp file first and the existing files are only replaced once all of them have been
+// written, so if anything fails nothing is changed. If a file can't be replaced after other files
+// have been, an error wrapping ErrPartialRestore is returned. The system file and key file are
+// backed up before they are replaced. A running server must be restarted to load the restored
+// system.
+func (b *Backup) Restore(cfg *gohome.Config) error {
+	if storeType(cfg.StoreType) != b.Manifest.StoreType {
+		return fmt.Errorf("the backup uses the %s store, the config uses the %s store",
+			b.Manifest.StoreType, storeType(cfg.StoreType))
+	}
+
+	type restoreFile struct {
+		name   string
+		dest   string
+		perm   os.FileMode
+		backup bool
+		tmp    string
+	}
+
+	names := make([]string, 0, len(b.files))
+	for name := range b.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*restoreFile
+	for _, name := range names {
+		var dest string
+		switch {
+		case strings.HasPrefix(name, backupAutomationDir):
+			dest = filepath.Join(cfg.AutomationPath, filepath.FromSlash(strings.TrimPrefix(name, backupAutomationDir)))
+		case strings.HasPrefix(name, backupHistoryDir):
+			dest = filepath.Join(cfg.HistoryDirPath(), filepath.FromSlash(strings.TrimPrefix(name, backupHistoryDir)))
+		case name == backupEventsName:
+			dest = cfg.EventLogPath
+		default:
+			continue
+		}
+		files = append(files, &restoreFile{name: name, dest: dest, perm: 0644})
+	}
+
+	// The key and system file are replaced last, so the running system isn't changed unless
+	// everything else was restored
+	if _, ok := b.files[backupKeyName]; ok {
+		files = append(files, &restoreFile{name: backupKeyName, dest: cfg.KeyFilePath(), perm: 0600, backup: true})
+	}
+	files = append(files, &restoreFile{name: backupSystemName, dest: cfg.SystemPath, perm: 0644, backup: true})
+
+	defer func() {
+		for _, f := range files {
+			if f.tmp != "" {
+				os.Remove(f.tmp)
+			}
+		}
+	}()
+
+	for _, f := range files {
+		if !f.backup {
+			if err := os.MkdirAll(filepath.Dir(f.dest), 0755); err != nil {
+				return err
+			}
+		}
+		tmp, err := stageFile(f.dest, b.files[f.name], f.perm)
+		if err != nil {
+			return errExt.Wrapf(err, "failed to restore %s", f.name)
+		}
+		f.tmp = tmp
+	}
+
+	for i, f := range files {
+		var err error
+		if f.backup {
+			err = replaceFile(f.dest, f.tmp)
+		} else {
+			err = os.Rename(f.tmp, f.dest)
+		}
+		if err != nil {
+			// If the temp file is still there this file wasn't replaced either
+			if _, statErr := os.Stat(f.tmp); i == 0 && statErr == nil {
+				return errExt.Wrapf(err, "failed to restore %s", f.name)
+			}
+			return errExt.Wrapf(ErrPartialRestore, "failed to restore %s: %s", f.name, err)
+		}
+		f.tmp = ""
+		log.V("restored %s to %s", f.name, f.dest)
+	}
+	return nil
+}
+
+// ConfigFile returns the contents of the config file in the backup
+func (b *Backup) ConfigFile() []byte {
+	return b.files[backupConfigName]
+}
+
+// validBackupName returns true if the name is one of the files we put in a backup, names must
+// not be able to write outside of the directories they are restored to
+func validBackupName(name string) bool {
+	switch name {
+	case backupManifestName, backupConfigName, backupSystemName, backupKeyName, backupEventsName:
+		return true
+	}
+
+	if !strings.HasPrefix(name, backupAutomationDir) && !strings.HasPrefix(name, backupHistoryDir) {
+		return false
+	}
+	if path.Clean(name) != name || strings.Contains(name, "..") || strings.Contains(name, "\\") {
+		return false
+	}
+	return true
+}
+
+// storeType returns the store type, an empty value means the default JSON store
+func storeType(t string) string {
+	if t == "" {
+		return StoreTypeJSON
+	}
+	return t
+}
+
+// systemFileToJSON returns the contents of a system file saved by the store in the JSON file format
+func systemFileToJSON(storeType string, b []byte) ([]byte, error) {
+	switch storeType {
+	case StoreTypeJSON:
+		return b, nil
+	case StoreTypeKV:
+		records, err := readKVRecords(b)
+		if err != nil {
+			return nil, err
+		}
+		return kvRecordsToSystem(records)
+	default:
+		return nil, fmt.Errorf("unsupported store type: %s", storeType)
+	}
+}
+
+func systemFileVersion(b []byte) (string, error) {
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "", err
+	}
+	if v.Version == "" {
+		return initialVersion, nil
+	}
+	return v.Version, nil
+}
+
+// validateSystemFile verifies the system file was not saved by a newer version of goHOME and that
+// all of the device credentials and user TOTP secrets can be decrypted with the key. The file is migrated when the
+// restored system is loaded
+func validateSystemFile(storeType string, b, key []byte) error {
+	sysJSON, err := systemFileToJSON(storeType, b)
+	if err != nil {
+		return err
+	}
+
+	version, err := systemFileVersion(sysJSON)
+	if err != nil {
+		return err
+	}
+	cmp, err := compareVersions(version, SystemVersion)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return errExt.Wrapf(ErrNewerVersion, "version %s, this version of goHOME supports up to %s", version, SystemVersion)
+	}
+
+	var s systemJSON
+	if err := json.Unmarshal(sysJSON, &s); err != nil {
+		return err
+	}
+
+	if key != nil {
+		if key, err = decodeKey(key); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.Devices {
+		if d.Auth == nil {
+			continue
+		}
+		for _, val := range []string{d.Auth.Password, d.Auth.Token} {
+			if _, err := decryptSecretWithKey(key, val); err != nil {
+				return errExt.Wrapf(err, "device: %s", d.ID)
+			}
+		}
+	}
+	for _, u := range s.Users {
+		if _, err := decryptSecretWithKey(key, u.TOTPSecret); err != nil {
+			return errExt.Wrapf(err, "user: %s", u.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxBackups is the number of backups of the system file that are kept, each time the system
+// is saved the previous version is backed up and the oldest backups over this limit are removed.
+// Set to 0 to disable backups
+var MaxBackups = 5
+
+// ErrLockTimeout is returned when the lock on a file could not be acquired, because another
+// process is holding it
+var ErrLockTimeout = errors.New("timed out waiting for file lock")
+
+const (
+	backupSuffix     = ".backup-"
+	backupTimeFormat = "20060102-150405.000000000"
+	lockSuffix       = ".lock"
+
+	// lockTimeout is how long we wait to get a file lock before giving up
+	lockTimeout = time.Second * 10
+
+	// staleLockAge is the age after which a lock file is assumed to have been left behind by a
+	// process that crashed, a save never takes anywhere near this long
+	staleLockAge = time.Second * 30
+)
+
+// fileMutexes stops multiple goroutines in this process saving the same file at the same time,
+// the lock file stops other processes, such as ghadmin, doing the same
+var fileMutexes = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// lockFile acquires an exclusive lock on the path, the returned func must be called to release it
+func lockFile(path string) (func(), error) {
+	path = filepath.Clean(path)
+
+	fileMutexes.Lock()
+	mutex, ok := fileMutexes.m[path]
+	if !ok {
+		mutex = &sync.Mutex{}
+		fileMutexes.m[path] = mutex
+	}
+	fileMutexes.Unlock()
+	mutex.Lock()
+
+	lockPath := path + lockSuffix
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d", os.Getpid())
+			f.Close()
+			break
+		}
+
+		if !os.IsExist(err) {
+			mutex.Unlock()
+			return nil, err
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			mutex.Unlock()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	return func() {
+		os.Remove(lockPath)
+		mutex.Unlock()
+	}, nil
+}
+
+// writeFileAtomic writes the data to path so that the file either contains the old contents or
+// the new contents, never a partial write, even if the process dies or the power is cut. The
+// data is written to a temp file which is flushed to disk, then renamed over the original file.
+// Before the file is replaced a timestamped backup of the old file is made.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmpPath, err := stageFile(path, b, perm)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(path, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// stageFile writes the data to a temp file in the same directory as path and flushes it to disk,
+// so it can be renamed over path by replaceFile. If anything fails the temp file is removed
+func stageFile(path string, b []byte, perm os.FileMode) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+
+	// If anything fails, don't leave the temp file behind
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return "", err
+	}
+	success = true
+	return tmpPath, nil
+}
+
+// replaceFile renames the temp file created by stageFile over path, after making a timestamped
+// backup of the old file. The temp file is left for the caller to remove if this fails
+func replaceFile(path, tmpPath string) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := backupFile(path); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Flush the directory so the rename itself is persisted, not supported on all platforms
+	// so failures are ignored
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return pruneBackups(path, MaxBackups)
+}
+
+// backupFile copies the current contents of path to a new timestamped backup file, if the
+// file doesn't exist yet there is nothing to backup
+func backupFile(path string) error {
+	if MaxBackups <= 0 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	backupPath := path + backupSuffix + time.Now().UTC().Format(backupTimeFormat)
+	f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// backupFiles returns the paths of all the backups of the file, newest first
+func backupFiles(path string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(path) + backupSuffix
+	var backups []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), prefix) {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(path), file.Name()))
+	}
+
+	// The timestamp format sorts lexically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// pruneBackups removes the oldest backups of the file so that at most max remain, if max is
+// zero backups are disabled and any existing backups are left alone
+func pruneBackups(path string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	backups, err := backupFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for i := max; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBackups removes all of the backups of the file
+func removeBackups(path string) error {
+	backups, err := backupFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups {
+		if err := os.Remove(backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
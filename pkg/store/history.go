@@ -0,0 +1,660 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	errExt "github.com/pkg/errors"
+)
+
+// ErrRevisionNotFound is returned when a revision is not in the configuration history
+var ErrRevisionNotFound = errors.New("revision not found")
+
+const revisionPrefix = "rev-"
+
+// Change describes who made a change to the system and how it was made
+type Change struct {
+	// User is the login of the user that made the change, empty if the change was not made by a user
+	User string `json:"user"`
+
+	// Origin is how the change was made, such as the API call e.g. "DELETE /api/v1/devices/123"
+	Origin string `json:"origin"`
+}
+
+// ChangeRecorder is implemented by stores that record who made each change
+type ChangeRecorder interface {
+	// WithChange returns a store that records c as the cause of the changes saved through it
+	WithChange(c Change) Store
+}
+
+// WithChange returns a store that records c as the cause of the changes saved through it. If the
+// store doesn't record changes it is returned as is
+func WithChange(s Store, c Change) Store {
+	if recorder, ok := s.(ChangeRecorder); ok {
+		return recorder.WithChange(c)
+	}
+	return s
+}
+
+// Revision is an entry in the configuration history, a snapshot of the system taken after it was saved
+type Revision struct {
+	Rev    int       `json:"rev"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Change
+}
+
+// revisionFile is the format of the files in the history directory
+type revisionFile struct {
+	Revision
+	System json.RawMessage `json:"system"`
+}
+
+// History keeps a snapshot of the system each time it is saved, so changes can be reviewed and
+// rolled back. Each revision is saved to its own file in Dir, once there are more than
+// MaxRevisions the oldest revisions are removed
+type History struct {
+	Dir          string
+	MaxRevisions int
+
+	mutex   sync.Mutex
+	nextRev int
+}
+
+// NewHistory returns a history that saves revisions in dir, which is created if it doesn't exist
+func NewHistory(dir string, maxRevisions int) (*History, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	h := &History{Dir: dir, MaxRevisions: maxRevisions}
+	revs, err := h.revisionNumbers()
+	if err != nil {
+		return nil, err
+	}
+	h.nextRev = 1
+	if len(revs) > 0 {
+		h.nextRev = revs[len(revs)-1] + 1
+	}
+	return h, nil
+}
+
+// Record saves a snapshot of the system as a new revision
+func (h *History) Record(sys *gohome.System, c Change, action string) (*Revision, error) {
+	b, err := MarshalSystem(sys)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	rev := revisionFile{
+		Revision: Revision{
+			Rev:    h.nextRev,
+			Time:   time.Now(),
+			Action: action,
+			Change: c,
+		},
+		System: b,
+	}
+
+	out, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots contain password hashes and encrypted credentials, so only the owner can read them
+	if err := ioutil.WriteFile(h.revisionPath(rev.Rev), out, 0600); err != nil {
+		return nil, err
+	}
+	h.nextRev++
+
+	if err := h.prune(); err != nil {
+		log.E("failed to remove old revisions from the configuration history: %s", err)
+	}
+	return &rev.Revision, nil
+}
+
+// Revisions returns up to limit revisions, newest first. If limit is <= 0 all revisions are returned
+func (h *History) Revisions(limit int) ([]Revision, error) {
+	revs, err := h.revisionNumbers()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Revision
+	for i := len(revs) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+
+		rev, err := h.read(revs[i])
+		if err != nil {
+			log.E("failed to read revision %d: %s", revs[i], err)
+			continue
+		}
+		out = append(out, rev.Revision)
+	}
+	return out, nil
+}
+
+// Snapshot returns the system as it was in the revision, in the system file format
+func (h *History) Snapshot(rev int) ([]byte, error) {
+	r, err := h.read(rev)
+	if err != nil {
+		return nil, err
+	}
+	return r.System, nil
+}
+
+// Diff returns the changes made to the system in the revision, compared to the previous revision.
+// If there is no previous revision the whole system is reported as added
+func (h *History) Diff(rev int) ([]DiffEntry, error) {
+	after, err := h.Snapshot(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var before []byte
+	revs, err := h.revisionNumbers()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(revs) - 1; i >= 0; i-- {
+		if revs[i] < rev {
+			if before, err = h.Snapshot(revs[i]); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return DiffSystems(before, after)
+}
+
+func (h *History) read(rev int) (*revisionFile, error) {
+	b, err := ioutil.ReadFile(h.revisionPath(rev))
+	if os.IsNotExist(err) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r revisionFile
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, errExt.Wrapf(err, "invalid revision file for revision %d", rev)
+	}
+	return &r, nil
+}
+
+func (h *History) revisionPath(rev int) string {
+	return filepath.Join(h.Dir, fmt.Sprintf("%s%08d.json", revisionPrefix, rev))
+}
+
+// revisionNumbers returns the numbers of all the revisions in the history, oldest first
+func (h *History) revisionNumbers() ([]int, error) {
+	files, err := ioutil.ReadDir(h.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []int
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, revisionPrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		rev, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, revisionPrefix), ".json"))
+		if err != nil {
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	sort.Ints(revs)
+	return revs, nil
+}
+
+// prune removes the oldest revisions so at most MaxRevisions remain
+func (h *History) prune() error {
+	if h.MaxRevisions <= 0 {
+		return nil
+	}
+
+	revs, err := h.revisionNumbers()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(revs)-h.MaxRevisions; i++ {
+		if err := os.Remove(h.revisionPath(revs[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistoryStore wraps a store, recording a revision in the configuration history each time the
+// system is saved
+type HistoryStore struct {
+	Store
+	History *History
+
+	change Change
+}
+
+// NewHistoryStore returns a store that saves changes to s and records each change in history
+func NewHistoryStore(s Store, history *History) *HistoryStore {
+	return &HistoryStore{Store: s, History: history}
+}
+
+// WithChange returns a store that records c as the cause of the changes saved through it
+func (s *HistoryStore) WithChange(c Change) Store {
+	return &HistoryStore{Store: s.Store, History: s.History, change: c}
+}
+
+// record adds a revision, the change has already been saved so failing to record it is logged
+// but not returned as an error
+func (s *HistoryStore) record(sys *gohome.System, action string) {
+	if _, err := s.History.Record(sys, s.change, action); err != nil {
+		log.E("failed to record %s in the configuration history: %s", action, err)
+	}
+}
+
+// SaveSystem saves the whole system
+func (s *HistoryStore) SaveSystem(sys *gohome.System) error {
+	if err := s.Store.SaveSystem(sys); err != nil {
+		return err
+	}
+	s.record(sys, "save system")
+	return nil
+}
+
+// SaveDevice saves the device and all of its features
+func (s *HistoryStore) SaveDevice(sys *gohome.System, d *gohome.Device) error {
+	if err := s.Store.SaveDevice(sys, d); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("save device %s", d.ID))
+	return nil
+}
+
+// DeleteDevice removes the device and all of its features
+func (s *HistoryStore) DeleteDevice(sys *gohome.System, d *gohome.Device) error {
+	if err := s.Store.DeleteDevice(sys, d); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("delete device %s", d.ID))
+	return nil
+}
+
+// SaveScene saves the scene and all of its commands
+func (s *HistoryStore) SaveScene(sys *gohome.System, scn *gohome.Scene) error {
+	if err := s.Store.SaveScene(sys, scn); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("save scene %s", scn.ID))
+	return nil
+}
+
+// DeleteScene removes the scene
+func (s *HistoryStore) DeleteScene(sys *gohome.System, scn *gohome.Scene) error {
+	if err := s.Store.DeleteScene(sys, scn); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("delete scene %s", scn.ID))
+	return nil
+}
+
+// SaveUser saves the user and their preferences
+func (s *HistoryStore) SaveUser(sys *gohome.System, u *gohome.User) error {
+	if err := s.Store.SaveUser(sys, u); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("save user %s", u.ID))
+	return nil
+}
+
+// DeleteUser removes the user
+func (s *HistoryStore) DeleteUser(sys *gohome.System, u *gohome.User) error {
+	if err := s.Store.DeleteUser(sys, u); err != nil {
+		return err
+	}
+	s.record(sys, fmt.Sprintf("delete user %s", u.ID))
+	return nil
+}
+
+// SaveAreas saves the area hierarchy
+func (s *HistoryStore) SaveAreas(sys *gohome.System) error {
+	if err := s.Store.SaveAreas(sys); err != nil {
+		return err
+	}
+	s.record(sys, "save areas")
+	return nil
+}
+
+// SetReadOnly makes the wrapped store read only, returns false if it can't be made read only
+func (s *HistoryStore) SetReadOnly(readOnly bool) bool {
+	return SetReadOnly(s.Store, readOnly)
+}
+
+// ReadOnly returns true if the wrapped store has been made read only
+func (s *HistoryStore) ReadOnly() bool {
+	return IsReadOnly(s.Store)
+}
+
+// Rollback changes the system back to how it was in the revision and saves it, the rollback is
+// recorded as a new revision so it can itself be rolled back
+func (s *HistoryStore) Rollback(sys *gohome.System, rev int) (*Revision, error) {
+	// The system can't be saved, so don't change it
+	if s.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	snapshot, err := s.History.Snapshot(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RollbackSystem(sys, snapshot); err != nil {
+		return nil, errExt.Wrapf(err, "failed to rollback to revision %d", rev)
+	}
+	if err := s.Store.SaveSystem(sys); err != nil {
+		return nil, err
+	}
+	return s.History.Record(sys, s.change, fmt.Sprintf("rollback to revision %d", rev))
+}
+
+// DiffEntry is a single difference between two versions of the system
+type DiffEntry struct {
+	// Kind is the type of item that changed, one of system, device, scene, user or area
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Op is one of added, removed or modified
+	Op string `json:"op"`
+
+	// Fields are the names of the fields that were modified
+	Fields []string `json:"fields,omitempty"`
+}
+
+// DiffSystems returns the differences between two system files, before can be nil. Only the names
+// of modified fields are returned, never their values, so secrets are not exposed
+func DiffSystems(before, after []byte) ([]DiffEntry, error) {
+	a, err := diffableSystem(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := diffableSystem(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]DiffEntry, 0)
+	if before != nil {
+		var fields []string
+		for _, key := range []string{"name", "description"} {
+			if !reflect.DeepEqual(a[key], b[key]) {
+				fields = append(fields, key)
+			}
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, DiffEntry{Kind: "system", Name: fmt.Sprintf("%v", b["name"]), Op: "modified", Fields: fields})
+		}
+	}
+
+	collections := []struct {
+		key  string
+		kind string
+	}{
+		{"devices", "device"},
+		{"scenes", "scene"},
+		{"users", "user"},
+		{"areas", "area"},
+	}
+	for _, c := range collections {
+		diffs = append(diffs, diffItems(c.kind, a[c.key], b[c.key])...)
+	}
+	return diffs, nil
+}
+
+// diffableSystem parses a system file, migrating it to the current version so revisions saved by
+// older versions can be compared
+func diffableSystem(b []byte) (map[string]interface{}, error) {
+	if b == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	migrated, _, err := migrateSystem(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var sys map[string]interface{}
+	if err := json.Unmarshal(migrated, &sys); err != nil {
+		return nil, err
+	}
+
+	devices, _ := sys["devices"].([]interface{})
+	for _, d := range devices {
+		if device, ok := d.(map[string]interface{}); ok {
+			normalizeDevice(device)
+		}
+	}
+	return sys, nil
+}
+
+// normalizeDevice removes the differences between two saves of a device that are not changes to
+// its configuration. Encrypted values use a random nonce so they are decrypted, otherwise every
+// save would look like a change, and attribute values are removed since they change as the
+// device is used
+func normalizeDevice(device map[string]interface{}) {
+	if auth, ok := device["auth"].(map[string]interface{}); ok {
+		for _, key := range []string{"password", "token"} {
+			if val, ok := auth[key].(string); ok {
+				if plain, err := decryptSecret(val); err == nil {
+					auth[key] = plain
+				}
+			}
+		}
+	}
+
+	features, _ := device["features"].([]interface{})
+	for _, f := range features {
+		ft, _ := f.(map[string]interface{})
+		attrs, _ := ft["attrs"].(map[string]interface{})
+		for _, a := range attrs {
+			if attribute, ok := a.(map[string]interface{}); ok {
+				delete(attribute, "value")
+			}
+		}
+	}
+}
+
+// comparableDevice returns the device in a form that can be compared to another save of the device
+func comparableDevice(d deviceJSON) (map[string]interface{}, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var device map[string]interface{}
+	if err := json.Unmarshal(b, &device); err != nil {
+		return nil, err
+	}
+	normalizeDevice(device)
+	return device, nil
+}
+
+// RollbackSystem changes the running system to match a snapshot from the configuration history.
+// Devices that were added, removed or changed since the snapshot are stopped and the saved
+// versions are recreated and initialized, devices that haven't changed keep running. Scenes and
+// areas are replaced with the saved versions. Users are not rolled back, so a rollback never
+// restores an old password. The whole snapshot is loaded and verified before the running system
+// is changed, so if the snapshot is invalid the system is left as is
+func RollbackSystem(sys *gohome.System, snapshot []byte) error {
+	migrated, _, err := migrateSystem(snapshot)
+	if err != nil {
+		return err
+	}
+
+	var saved systemJSON
+	if err := json.Unmarshal(migrated, &saved); err != nil {
+		return errExt.Wrap(err, "invalid snapshot")
+	}
+
+	// Loading the snapshot as a separate system verifies the devices, scenes and areas, the
+	// scenes only contain IDs so they can be used as is in the running system
+	staged, err := loadSystem(migrated)
+	if err != nil {
+		return errExt.Wrap(err, "invalid snapshot")
+	}
+
+	// Devices that don't exist in the running system, or whose configuration is different, have
+	// to be recreated, the other devices keep running
+	devices := make(map[string]*gohome.Device)
+	var recreated []*gohome.Device
+	for _, d := range saved.Devices {
+		if live := sys.DeviceByID(d.ID); live != nil {
+			liveJSON, err := deviceToJSON(live)
+			if err != nil {
+				return err
+			}
+			a, err := comparableDevice(liveJSON)
+			if err != nil {
+				return err
+			}
+			b, err := comparableDevice(d)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(a, b) {
+				devices[d.ID] = live
+				continue
+			}
+		}
+
+		// The device is created with the running system, so it uses the running extensions
+		dev, err := newDevice(sys, d)
+		if err != nil {
+			return err
+		}
+		dev.Features = staged.DeviceByID(d.ID).Features
+		devices[d.ID] = dev
+		recreated = append(recreated, dev)
+	}
+
+	features := make(map[string]*feature.Feature)
+	for _, d := range devices {
+		for _, f := range d.Features {
+			features[f.ID] = f
+		}
+	}
+
+	// Without saved areas the snapshot has a new root area, the same as when it is loaded
+	root := staged.Area
+	if len(saved.Areas) > 0 {
+		root, err = buildAreas(saved.Areas, func(ID string) *feature.Feature { return features[ID] })
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nothing can fail from here, so the running system is changed
+	for _, d := range sys.Devices() {
+		if devices[d.ID] != d {
+			log.V("rollback: removing device %s", d)
+			sys.StopDevice(d)
+		}
+	}
+
+	// Hubs may have been recreated, so every device has to point to the current hub instance
+	for _, d := range saved.Devices {
+		if d.HubID != "" {
+			devices[d.ID].Hub = devices[d.HubID]
+		}
+	}
+
+	sys.Replace(saved.Name, saved.Description, devices, staged.Scenes(), root)
+
+	for _, dev := range recreated {
+		log.V("rollback: initializing device %s", dev.ID)
+		if err := sys.InitDevice(dev); err != nil {
+			log.E("rollback: failed to initialize device %s: %s", dev.ID, err)
+		}
+	}
+	return nil
+}
+
+func diffItems(kind string, before, after interface{}) []DiffEntry {
+	index := func(items interface{}) (map[string]map[string]interface{}, []string) {
+		byID := make(map[string]map[string]interface{})
+		var ids []string
+		list, _ := items.([]interface{})
+		for _, i := range list {
+			item, ok := i.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := item["id"].(string)
+			byID[id] = item
+			ids = append(ids, id)
+		}
+		return byID, ids
+	}
+	name := func(item map[string]interface{}) string {
+		if n, ok := item["name"].(string); ok {
+			return n
+		}
+		n, _ := item["login"].(string)
+		return n
+	}
+
+	a, aIDs := index(before)
+	b, bIDs := index(after)
+
+	var diffs []DiffEntry
+	for _, id := range bIDs {
+		newItem := b[id]
+		oldItem, ok := a[id]
+		if !ok {
+			diffs = append(diffs, DiffEntry{Kind: kind, ID: id, Name: name(newItem), Op: "added"})
+			continue
+		}
+
+		var fields []string
+		for key := range newItem {
+			if !reflect.DeepEqual(oldItem[key], newItem[key]) {
+				fields = append(fields, key)
+			}
+		}
+		for key := range oldItem {
+			if _, ok := newItem[key]; !ok {
+				fields = append(fields, key)
+			}
+		}
+		if len(fields) > 0 {
+			sort.Strings(fields)
+			diffs = append(diffs, DiffEntry{Kind: kind, ID: id, Name: name(newItem), Op: "modified", Fields: fields})
+		}
+	}
+	for _, id := range aIDs {
+		if _, ok := b[id]; !ok {
+			diffs = append(diffs, DiffEntry{Kind: kind, ID: id, Name: name(a[id]), Op: "removed"})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,59 @@
+package store
+
+import "github.com/markdaws/gohome/pkg/gohome"
+
+// JSONStore saves the whole system to a single JSON file. Every change rewrites the whole file,
+// which is simple and easy to read and edit by hand
+type JSONStore struct {
+	Path string
+}
+
+// NewJSONStore returns a store that saves the system to the JSON file at path
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{Path: path}
+}
+
+// Load loads the system from the JSON file
+func (s *JSONStore) Load() (*gohome.System, error) {
+	return LoadSystem(s.Path)
+}
+
+// SaveSystem saves the whole system to the JSON file
+func (s *JSONStore) SaveSystem(sys *gohome.System) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// SaveDevice saves the device, the whole system is written to the JSON file
+func (s *JSONStore) SaveDevice(sys *gohome.System, d *gohome.Device) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// DeleteDevice removes the device, the whole system is written to the JSON file
+func (s *JSONStore) DeleteDevice(sys *gohome.System, d *gohome.Device) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// SaveScene saves the scene, the whole system is written to the JSON file
+func (s *JSONStore) SaveScene(sys *gohome.System, scn *gohome.Scene) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// DeleteScene removes the scene, the whole system is written to the JSON file
+func (s *JSONStore) DeleteScene(sys *gohome.System, scn *gohome.Scene) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// SaveUser saves the user, the whole system is written to the JSON file
+func (s *JSONStore) SaveUser(sys *gohome.System, u *gohome.User) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// DeleteUser removes the user, the whole system is written to the JSON file
+func (s *JSONStore) DeleteUser(sys *gohome.System, u *gohome.User) error {
+	return SaveSystem(s.Path, sys)
+}
+
+// SaveAreas saves the areas, the whole system is written to the JSON file
+func (s *JSONStore) SaveAreas(sys *gohome.System) error {
+	return SaveSystem(s.Path, sys)
+}
@@ -9,6 +9,7 @@ type systemJSON struct {
 	Scenes      []sceneJSON  `json:"scenes"`
 	Devices     []deviceJSON `json:"devices"`
 	Users       []userJSON   `json:"users"`
+	Areas       []areaJSON   `json:"areas"`
 }
 
 type areaJSON struct {
@@ -21,10 +22,31 @@ type areaJSON struct {
 }
 
 type userJSON struct {
-	ID        string `json:"id"`
-	Login     string `json:"login"`
-	HashedPwd string `json:"hashedPwd"`
-	Salt      string `json:"salt"`
+	ID        string        `json:"id"`
+	Login     string        `json:"login"`
+	HashedPwd string        `json:"hashedPwd"`
+	Salt      string        `json:"salt"`
+	Prefs     userPrefsJSON `json:"prefs"`
+	Role      string        `json:"role"`
+	Grants    []grantJSON   `json:"grants"`
+
+	// TOTPSecret is encrypted with the key file, like device credentials
+	TOTPSecret    string   `json:"totpSecret"`
+	TOTPEnabled   bool     `json:"totpEnabled"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type grantJSON struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type userPrefsJSON struct {
+	TempUnit       string          `json:"tempUnit"`
+	HiddenFeatures map[string]bool `json:"hiddenFeatures"`
+	FeatureOrder   []string        `json:"featureOrder"`
+	FavoriteScenes []string        `json:"favoriteScenes"`
+	LandingAreaID  string          `json:"landingAreaId"`
 }
 
 type sceneJSON struct {
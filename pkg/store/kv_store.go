@@ -0,0 +1,443 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	errExt "github.com/pkg/errors"
+)
+
+const (
+	kvOpPut    = "put"
+	kvOpDelete = "del"
+
+	kvKeyMeta   = "meta"
+	kvKeyAreas  = "areas"
+	kvKeyDevice = "device/"
+	kvKeyScene  = "scene/"
+	kvKeyUser   = "user/"
+
+	// kvMinCompactRecords is the number of records that have to be appended to the file
+	// before it is considered for compaction
+	kvMinCompactRecords = 100
+)
+
+// kvRecord is a single line in the key value file, a put sets the value of the key, a delete
+// removes the key
+type kvRecord struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// kvMeta is the value of the meta record, it holds the system values that aren't in any other record
+type kvMeta struct {
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// KVStore saves each device, scene and user as a separate record in an append only key value
+// file. A change only appends the records that changed to the end of the file, instead of
+// rewriting the whole system. Once enough records have been replaced, the file is compacted
+// so it only contains the latest value of each record.
+type KVStore struct {
+	Path string
+
+	mutex    sync.Mutex
+	records  map[string]json.RawMessage
+	appended int
+}
+
+// NewKVStore returns a store that saves the system to the key value file at path
+func NewKVStore(path string) *KVStore {
+	return &KVStore{Path: path}
+}
+
+// Load loads the system from the key value file. If the file is corrupt the newest backup, made
+// each time the file is compacted, is loaded instead
+func (s *KVStore) Load() (*gohome.System, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log.V("loading system from %s", s.Path)
+
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+
+	sys, err := s.load(b)
+	if err == nil {
+		return sys, nil
+	}
+	if errExt.Cause(err) == ErrNewerVersion {
+		return nil, err
+	}
+	log.E("failed to load system file %s: %s", s.Path, err)
+
+	backups, backupErr := backupFiles(s.Path)
+	if backupErr != nil {
+		return nil, err
+	}
+
+	for _, backupPath := range backups {
+		b, backupErr := ioutil.ReadFile(backupPath)
+		if backupErr != nil {
+			continue
+		}
+
+		sys, backupErr := s.load(b)
+		if backupErr != nil {
+			log.E("failed to load system backup %s: %s", backupPath, backupErr)
+			continue
+		}
+
+		// Future changes are appended, so the corrupt file has to be replaced
+		if backupErr = s.compact(); backupErr != nil {
+			return nil, backupErr
+		}
+
+		log.E("loaded system from backup: %s, changes made after the backup was taken have been lost", backupPath)
+		return sys, nil
+	}
+	return nil, err
+}
+
+// load creates a system from the contents of a key value file, files saved by older versions
+// are migrated to the current version and rewritten, a copy of the original file is kept
+func (s *KVStore) load(b []byte) (*gohome.System, error) {
+	records, err := readKVRecords(b)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := kvRecordsToSystem(records)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, version, err := migrateSystem(raw)
+	if err == ErrNewerVersion {
+		return nil, errExt.Wrapf(err, "version %s, this version of goHOME supports up to %s", version, SystemVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sys, err := loadSystem(migrated)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == SystemVersion {
+		s.records = records
+		s.appended = 0
+		return sys, nil
+	}
+
+	var sysJSON systemJSON
+	if err := json.Unmarshal(migrated, &sysJSON); err != nil {
+		return nil, err
+	}
+	if s.records, err = kvRecordsFromSystem(&sysJSON); err != nil {
+		return nil, err
+	}
+
+	originalPath := s.Path + ".v" + version
+	if err := ioutil.WriteFile(originalPath, b, 0600); err != nil {
+		return nil, errExt.Wrap(err, "failed to backup system file before upgrading")
+	}
+	log.V("backed up system file version %s to %s", version, originalPath)
+
+	if err := s.compact(); err != nil {
+		return nil, errExt.Wrap(err, "failed to save upgraded system file")
+	}
+	log.V("upgraded system file from version %s to %s", version, SystemVersion)
+	return sys, nil
+}
+
+// SaveSystem replaces the contents of the file with all of the records in the system
+func (s *KVStore) SaveSystem(sys *gohome.System) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.saveSystem(sys)
+}
+
+func (s *KVStore) saveSystem(sys *gohome.System) error {
+	sysJSON, err := systemToJSON(sys)
+	if err != nil {
+		return err
+	}
+
+	records, err := kvRecordsFromSystem(sysJSON)
+	if err != nil {
+		return err
+	}
+
+	s.records = records
+	return s.compact()
+}
+
+// SaveDevice saves the device record
+func (s *KVStore) SaveDevice(sys *gohome.System, d *gohome.Device) error {
+	dJSON, err := deviceToJSON(d)
+	if err != nil {
+		return err
+	}
+	return s.putRecord(sys, kvKeyDevice+d.ID, dJSON)
+}
+
+// DeleteDevice removes the device record
+func (s *KVStore) DeleteDevice(sys *gohome.System, d *gohome.Device) error {
+	return s.deleteRecord(sys, kvKeyDevice+d.ID)
+}
+
+// SaveScene saves the scene record
+func (s *KVStore) SaveScene(sys *gohome.System, scn *gohome.Scene) error {
+	sJSON, err := sceneToJSON(scn)
+	if err != nil {
+		return err
+	}
+	return s.putRecord(sys, kvKeyScene+scn.ID, sJSON)
+}
+
+// DeleteScene removes the scene record
+func (s *KVStore) DeleteScene(sys *gohome.System, scn *gohome.Scene) error {
+	return s.deleteRecord(sys, kvKeyScene+scn.ID)
+}
+
+// SaveUser saves the user record
+func (s *KVStore) SaveUser(sys *gohome.System, u *gohome.User) error {
+	user, err := userToJSON(sys, u)
+	if err != nil {
+		return err
+	}
+	return s.putRecord(sys, kvKeyUser+u.ID, user)
+}
+
+// DeleteUser removes the user record
+func (s *KVStore) DeleteUser(sys *gohome.System, u *gohome.User) error {
+	return s.deleteRecord(sys, kvKeyUser+u.ID)
+}
+
+// SaveAreas saves the areas record, which contains the whole area hierarchy
+func (s *KVStore) SaveAreas(sys *gohome.System) error {
+	return s.putRecord(sys, kvKeyAreas, saveAreas(sys))
+}
+
+func (s *KVStore) putRecord(sys *gohome.System, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.appendRecord(sys, kvRecord{Op: kvOpPut, Key: key, Value: b})
+}
+
+func (s *KVStore) deleteRecord(sys *gohome.System, key string) error {
+	return s.appendRecord(sys, kvRecord{Op: kvOpDelete, Key: key})
+}
+
+// appendRecord adds the record to the end of the file. If the system has not been loaded or saved
+// yet there is nothing to append to, so the whole system is saved instead
+func (s *KVStore) appendRecord(sys *gohome.System, r kvRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.records[kvKeyMeta]; !ok {
+		return s.saveSystem(sys)
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := appendLine(s.Path, line); err != nil {
+		return err
+	}
+
+	if r.Op == kvOpDelete {
+		delete(s.records, r.Key)
+	} else {
+		s.records[r.Key] = r.Value
+	}
+	s.appended++
+
+	// Once the file contains more old values than current values, rewrite it
+	if s.appended >= kvMinCompactRecords && s.appended > len(s.records) {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file so it only contains the current value of each record
+func (s *KVStore) compact() error {
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		line, err := json.Marshal(kvRecord{Op: kvOpPut, Key: key, Value: s.records[key]})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeFileAtomic(s.Path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	s.appended = 0
+	return nil
+}
+
+// appendLine appends the line to the file and flushes it to disk
+func appendLine(path string, line []byte) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readKVRecords replays all of the records in the file, returning the current value of each key.
+// If the process died part way through appending a record the last line is incomplete, the change
+// was never acknowledged so it is ignored
+func readKVRecords(b []byte) (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage)
+
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var r kvRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			if i == len(lines)-1 {
+				log.E("ignoring incomplete record at the end of the system file")
+				break
+			}
+			return nil, fmt.Errorf("invalid record on line %d: %s", i+1, err)
+		}
+
+		switch r.Op {
+		case kvOpPut:
+			records[r.Key] = r.Value
+		case kvOpDelete:
+			delete(records, r.Key)
+		default:
+			return nil, fmt.Errorf("invalid record on line %d, unknown op: %s", i+1, r.Op)
+		}
+	}
+
+	if _, ok := records[kvKeyMeta]; !ok {
+		return nil, fmt.Errorf("missing %s record", kvKeyMeta)
+	}
+	return records, nil
+}
+
+// kvRecordsToSystem combines the records in to the system file format, so files are migrated
+// and loaded the same way no matter which store they were saved by
+func kvRecordsToSystem(records map[string]json.RawMessage) ([]byte, error) {
+	sys := make(map[string]interface{})
+	if err := json.Unmarshal(records[kvKeyMeta], &sys); err != nil {
+		return nil, errExt.Wrap(err, "invalid meta record")
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var devices, scenes, users []json.RawMessage
+	for _, key := range keys {
+		switch {
+		case strings.HasPrefix(key, kvKeyDevice):
+			devices = append(devices, records[key])
+		case strings.HasPrefix(key, kvKeyScene):
+			scenes = append(scenes, records[key])
+		case strings.HasPrefix(key, kvKeyUser):
+			users = append(users, records[key])
+		}
+	}
+
+	sys["devices"] = devices
+	sys["scenes"] = scenes
+	sys["users"] = users
+	if areas, ok := records[kvKeyAreas]; ok {
+		sys["areas"] = areas
+	}
+	return json.Marshal(sys)
+}
+
+// kvRecordsFromSystem splits the system in to records
+func kvRecordsFromSystem(sys *systemJSON) (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage)
+	put := func(key string, value interface{}) error {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		records[key] = b
+		return nil
+	}
+
+	err := put(kvKeyMeta, kvMeta{
+		Version:     sys.Version,
+		Name:        sys.Name,
+		Description: sys.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range sys.Devices {
+		if err := put(kvKeyDevice+d.ID, d); err != nil {
+			return nil, err
+		}
+	}
+	for _, scn := range sys.Scenes {
+		if err := put(kvKeyScene+scn.ID, scn); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range sys.Users {
+		if err := put(kvKeyUser+u.ID, u); err != nil {
+			return nil, err
+		}
+	}
+	if err := put(kvKeyAreas, sys.Areas); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
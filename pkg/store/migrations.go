@@ -0,0 +1,273 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+// SystemVersion is the version of the system file format written by SaveSystem. When the format
+// changes, bump this value and add a migration to the end of the migrations list that upgrades
+// files from the previous version
+const SystemVersion = "0.8.0"
+
+// initialVersion is the version assumed for files that don't have a version value
+const initialVersion = "0.1.0"
+
+// encryptedSecretsVersion is the first version that encrypts device passwords and tokens, older
+// files contain them in plain text
+const encryptedSecretsVersion = "0.5.0"
+
+// ErrNewerVersion is returned when trying to load a system file that was written by a newer
+// version of goHOME, we don't know how to read it and saving would lose information
+var ErrNewerVersion = fmt.Errorf("system file was created by a newer version of goHOME")
+
+// migration upgrades the raw JSON of a system file from one version to the next
+type migration struct {
+	From    string
+	To      string
+	Migrate func(sys map[string]interface{}) error
+}
+
+// migrations is the ordered chain of migrations, each migration must start at the version the
+// previous one finished at, the last one must finish at SystemVersion
+var migrations = []migration{
+	{
+		// Older files could contain null instead of empty lists
+		From: "0.1.0",
+		To:   "0.2.0",
+		Migrate: func(sys map[string]interface{}) error {
+			for _, key := range []string{"scenes", "devices", "users"} {
+				if sys[key] == nil {
+					sys[key] = []interface{}{}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Areas are saved, older files only had the root area which was recreated on every load,
+		// so an empty list means a new root area is created
+		From: "0.2.0",
+		To:   "0.3.0",
+		Migrate: func(sys map[string]interface{}) error {
+			if sys["areas"] == nil {
+				sys["areas"] = []interface{}{}
+			}
+			return nil
+		},
+	},
+	{
+		// User preferences are saved, previously they were lost on every restart
+		From: "0.3.0",
+		To:   "0.4.0",
+		Migrate: func(sys map[string]interface{}) error {
+			users, _ := sys["users"].([]interface{})
+			for _, u := range users {
+				user, ok := u.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid user entry")
+				}
+				if user["prefs"] == nil {
+					user["prefs"] = map[string]interface{}{}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Device passwords and tokens are encrypted, previously they were plain text
+		From: "0.4.0",
+		To:   "0.5.0",
+		Migrate: func(sys map[string]interface{}) error {
+			devices, _ := sys["devices"].([]interface{})
+			for _, d := range devices {
+				device, ok := d.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid device entry")
+				}
+				auth, ok := device["auth"].(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				for _, key := range []string{"password", "token"} {
+					val, _ := auth[key].(string)
+					if strings.HasPrefix(val, encryptedPrefix) {
+						continue
+					}
+					encrypted, err := encryptSecret(val)
+					if err != nil {
+						return err
+					}
+					auth[key] = encrypted
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Scene commands are saved with the same attributes as the REST API, featureSetAttrs
+		// commands used to save the feature ID as featureId instead of id
+		From: "0.5.0",
+		To:   "0.6.0",
+		Migrate: func(sys map[string]interface{}) error {
+			scenes, _ := sys["scenes"].([]interface{})
+			for _, s := range scenes {
+				scene, ok := s.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid scene entry")
+				}
+
+				commands, _ := scene["commands"].([]interface{})
+				for _, c := range commands {
+					command, ok := c.(map[string]interface{})
+					if !ok {
+						return fmt.Errorf("invalid command entry")
+					}
+					attrs, ok := command["attributes"].(map[string]interface{})
+					if !ok || command["type"] != "featureSetAttrs" {
+						continue
+					}
+					if featureID, ok := attrs["featureId"]; ok {
+						attrs["id"] = featureID
+						delete(attrs, "featureId")
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Users have roles, previously every user could do everything so existing users are admins
+		From: "0.6.0",
+		To:   "0.7.0",
+		Migrate: func(sys map[string]interface{}) error {
+			users, _ := sys["users"].([]interface{})
+			for _, u := range users {
+				user, ok := u.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid user entry")
+				}
+				if role, _ := user["role"].(string); role == "" {
+					user["role"] = "admin"
+				}
+				if user["grants"] == nil {
+					user["grants"] = []interface{}{}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Users can enrol in two factor authentication, existing users are not enrolled
+		From: "0.7.0",
+		To:   "0.8.0",
+		Migrate: func(sys map[string]interface{}) error {
+			users, _ := sys["users"].([]interface{})
+			for _, u := range users {
+				user, ok := u.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid user entry")
+				}
+				user["totpSecret"] = ""
+				user["totpEnabled"] = false
+				user["recoveryCodes"] = []interface{}{}
+			}
+			return nil
+		},
+	},
+}
+
+// migrateSystem upgrades the contents of a system file to the current version. It returns the
+// upgraded file contents and the version of the file before it was upgraded, if the file is
+// already at the current version the bytes are returned unmodified
+func migrateSystem(b []byte) ([]byte, string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, "", err
+	}
+
+	version := initialVersion
+	if v, ok := raw["version"].(string); ok && v != "" {
+		version = v
+	}
+
+	cmp, err := compareVersions(version, SystemVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	if cmp > 0 {
+		return nil, version, ErrNewerVersion
+	}
+	if cmp == 0 {
+		return b, version, nil
+	}
+
+	current := version
+	for _, m := range migrations {
+		if m.From != current {
+			continue
+		}
+
+		log.V("migrating system file from version %s to %s", m.From, m.To)
+		if err := m.Migrate(raw); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate system file from version %s to %s: %s", m.From, m.To, err)
+		}
+		current = m.To
+		raw["version"] = current
+	}
+
+	if current != SystemVersion {
+		return nil, version, fmt.Errorf("no migration found from version %s to %s", current, SystemVersion)
+	}
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, version, err
+	}
+	return out, version, nil
+}
+
+// compareVersions compares two versions in the format major.minor.patch, returns -1 if a is
+// older than b, 0 if they are the same and 1 if a is newer than b
+func compareVersions(a, b string) (int, error) {
+	aParts, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range aParts {
+		switch {
+		case aParts[i] < bParts[i]:
+			return -1, nil
+		case aParts[i] > bParts[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseVersion(v string) ([3]int, error) {
+	var parts [3]int
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, fmt.Errorf("invalid version: %s", v)
+	}
+
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("invalid version: %s", v)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/markdaws/gohome/pkg/gohome"
+)
+
+// ErrReadOnly is returned when a change is saved to a store that has been made read only, such as
+// after a backup has been restored and the server is restarting to load it
+var ErrReadOnly = errors.New("the system is read only, changes can't be saved")
+
+// ReadOnlyToggler is implemented by stores that can be made read only
+type ReadOnlyToggler interface {
+	// SetReadOnly stops the store saving changes, ErrReadOnly is returned instead. Saves that
+	// are in progress finish before it returns. Returns false if the store can't be made read only
+	SetReadOnly(readOnly bool) bool
+
+	// ReadOnly returns true if the store has been made read only
+	ReadOnly() bool
+}
+
+// SetReadOnly makes the store read only, or lets it save changes again. Returns false if the store
+// can't be made read only
+func SetReadOnly(s Store, readOnly bool) bool {
+	if toggler, ok := s.(ReadOnlyToggler); ok {
+		return toggler.SetReadOnly(readOnly)
+	}
+	return false
+}
+
+// IsReadOnly returns true if the store has been made read only
+func IsReadOnly(s Store) bool {
+	if toggler, ok := s.(ReadOnlyToggler); ok {
+		return toggler.ReadOnly()
+	}
+	return false
+}
+
+// ReadOnlyStore wraps a store so it can be made read only
+type ReadOnlyStore struct {
+	Store
+
+	mutex    sync.RWMutex
+	readOnly bool
+}
+
+// NewReadOnlyStore returns a store that saves changes to s until it is made read only
+func NewReadOnlyStore(s Store) *ReadOnlyStore {
+	return &ReadOnlyStore{Store: s}
+}
+
+// SetReadOnly stops the store saving changes, saves that are in progress finish first
+func (s *ReadOnlyStore) SetReadOnly(readOnly bool) bool {
+	s.mutex.Lock()
+	s.readOnly = readOnly
+	s.mutex.Unlock()
+	return true
+}
+
+// ReadOnly returns true if the store has been made read only
+func (s *ReadOnlyStore) ReadOnly() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readOnly
+}
+
+// save calls fn unless the store is read only, the store can't be made read only until fn returns
+func (s *ReadOnlyStore) save(fn func() error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	return fn()
+}
+
+// SaveSystem saves the whole system
+func (s *ReadOnlyStore) SaveSystem(sys *gohome.System) error {
+	return s.save(func() error { return s.Store.SaveSystem(sys) })
+}
+
+// SaveDevice saves the device and all of its features
+func (s *ReadOnlyStore) SaveDevice(sys *gohome.System, d *gohome.Device) error {
+	return s.save(func() error { return s.Store.SaveDevice(sys, d) })
+}
+
+// DeleteDevice removes the device and all of its features
+func (s *ReadOnlyStore) DeleteDevice(sys *gohome.System, d *gohome.Device) error {
+	return s.save(func() error { return s.Store.DeleteDevice(sys, d) })
+}
+
+// SaveScene saves the scene and all of its commands
+func (s *ReadOnlyStore) SaveScene(sys *gohome.System, scn *gohome.Scene) error {
+	return s.save(func() error { return s.Store.SaveScene(sys, scn) })
+}
+
+// DeleteScene removes the scene
+func (s *ReadOnlyStore) DeleteScene(sys *gohome.System, scn *gohome.Scene) error {
+	return s.save(func() error { return s.Store.DeleteScene(sys, scn) })
+}
+
+// SaveUser saves the user and their preferences
+func (s *ReadOnlyStore) SaveUser(sys *gohome.System, u *gohome.User) error {
+	return s.save(func() error { return s.Store.SaveUser(sys, u) })
+}
+
+// DeleteUser removes the user
+func (s *ReadOnlyStore) DeleteUser(sys *gohome.System, u *gohome.User) error {
+	return s.save(func() error { return s.Store.DeleteUser(sys, u) })
+}
+
+// SaveAreas saves the area hierarchy
+func (s *ReadOnlyStore) SaveAreas(sys *gohome.System) error {
+	return s.save(func() error { return s.Store.SaveAreas(sys) })
+}
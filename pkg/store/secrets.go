@@ -0,0 +1,249 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+// KeySize is the size in bytes of the key used to encrypt secrets, AES-256
+const KeySize = 32
+
+// encryptedPrefix marks values in the system file that are encrypted, so we can tell them apart
+// from plain text values saved by older versions
+const encryptedPrefix = "enc:v1:"
+
+// ErrMissingKey is returned when the system file contains secrets, but no encryption key has
+// been set by calling SetEncryptionKey
+var ErrMissingKey = errors.New("missing encryption key, device credentials cannot be read or saved")
+
+// encryptionKey is the key used to encrypt secrets such as device passwords in the system file.
+// It lives in a separate key file so a copy of the system file alone does not leak the secrets
+var encryptionKey []byte
+
+// SetEncryptionKey sets the key used to encrypt and decrypt secrets in the system file, it must
+// be called before LoadSystem or SaveSystem if the system contains any device credentials
+func SetEncryptionKey(key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("invalid encryption key, must be %d bytes", KeySize)
+	}
+	encryptionKey = key
+	return nil
+}
+
+// GenerateKeyFile creates a new random encryption key and writes it to path, only the owner can
+// read the file. If the file already exists an error is returned, replacing the key would make
+// the secrets in the system file unreadable
+func GenerateKeyFile(path string) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Write([]byte(base64.StdEncoding.EncodeToString(key)))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// LoadKeyFile reads an encryption key created by GenerateKeyFile
+func LoadKeyFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := decodeKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file: %s", path)
+	}
+	return key, nil
+}
+
+// decodeKey decodes the contents of a key file
+func decodeKey(b []byte) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("invalid key")
+	}
+	return key, nil
+}
+
+// LoadOrCreateKeyFile reads the encryption key from path, if the file does not exist a new key
+// is generated and saved to path
+func LoadOrCreateKeyFile(path string) ([]byte, error) {
+	key, err := LoadKeyFile(path)
+	if os.IsNotExist(err) {
+		log.V("key file not found, creating a new key file at: %s", path)
+		return GenerateKeyFile(path)
+	}
+	return key, err
+}
+
+// encryptSecret encrypts the value with the encryption key, empty values are not encrypted
+func encryptSecret(val string) (string, error) {
+	if val == "" {
+		return "", nil
+	}
+	if encryptionKey == nil {
+		return "", ErrMissingKey
+	}
+
+	gcm, err := newGCM(encryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(val), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a value encrypted by encryptSecret. Values that are not encrypted, saved
+// before secrets were encrypted, are returned as is and will be encrypted the next time the
+// system is saved
+func decryptSecret(val string) (string, error) {
+	return decryptSecretWithKey(encryptionKey, val)
+}
+
+func decryptSecretWithKey(key []byte, val string) (string, error) {
+	if !strings.HasPrefix(val, encryptedPrefix) {
+		return val, nil
+	}
+	if key == nil {
+		return "", ErrMissingKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(val, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %s", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value, the key file does not match the system file")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// redactedValue replaces secrets when the system file is shown to users
+const redactedValue = "********"
+
+// RedactSystem returns a copy of the contents of a system file with all of the secrets, such as
+// device credentials and password hashes, replaced so it is safe to show to users
+func RedactSystem(b []byte) ([]byte, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	redact := func(item interface{}, keys ...string) {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, key := range keys {
+			if val, ok := m[key].(string); ok && val != "" {
+				m[key] = redactedValue
+			}
+		}
+	}
+
+	devices, _ := raw["devices"].([]interface{})
+	for _, d := range devices {
+		if device, ok := d.(map[string]interface{}); ok {
+			redact(device["auth"], "password", "token")
+		}
+	}
+
+	users, _ := raw["users"].([]interface{})
+	for _, u := range users {
+		redact(u, "hashedPwd", "salt", "totpSecret")
+		if user, ok := u.(map[string]interface{}); ok {
+			if codes, ok := user["recoveryCodes"].([]interface{}); ok {
+				for i := range codes {
+					codes[i] = redactedValue
+				}
+			}
+		}
+	}
+
+	return json.MarshalIndent(raw, "", "  ")
+}
+
+// scrubPlainSecrets returns a copy of the contents of a system file saved before secrets were
+// encrypted, with the plain text device passwords and tokens removed. Returns false if the file
+// didn't contain any plain text secrets, in which case the contents are returned as is
+func scrubPlainSecrets(b []byte) ([]byte, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, false, err
+	}
+
+	scrubbed := false
+	devices, _ := raw["devices"].([]interface{})
+	for _, d := range devices {
+		device, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		auth, ok := device["auth"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"password", "token"} {
+			if val, _ := auth[key].(string); val != "" && !strings.HasPrefix(val, encryptedPrefix) {
+				auth[key] = ""
+				scrubbed = true
+			}
+		}
+	}
+	if !scrubbed {
+		return b, false, nil
+	}
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	return out, true, err
+}
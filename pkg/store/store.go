@@ -10,9 +10,11 @@ import (
 	"github.com/go-home-iot/connection-pool"
 	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/feature"
 	"github.com/markdaws/gohome/pkg/gohome"
 	"github.com/markdaws/gohome/pkg/intg"
 	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/validation"
 
 	errExt "github.com/pkg/errors"
 )
@@ -20,7 +22,61 @@ import (
 // ErrFileNotFound is returned when the specified path cannot be found
 var ErrFileNotFound = errors.New("file not found")
 
-// LoadSystem loads a gohome data file from the specified path
+// Store persists a system. When part of the system changes, the most specific method should be
+// called so stores that save items separately only have to write the items that changed
+type Store interface {
+	// Load loads the system, ErrFileNotFound is returned if the system has never been saved
+	Load() (*gohome.System, error)
+
+	// SaveSystem saves the whole system, replacing everything that was previously saved
+	SaveSystem(sys *gohome.System) error
+
+	// SaveDevice saves the device and all of its features
+	SaveDevice(sys *gohome.System, d *gohome.Device) error
+
+	// DeleteDevice removes the device and all of its features
+	DeleteDevice(sys *gohome.System, d *gohome.Device) error
+
+	// SaveScene saves the scene and all of its commands
+	SaveScene(sys *gohome.System, s *gohome.Scene) error
+
+	// DeleteScene removes the scene
+	DeleteScene(sys *gohome.System, s *gohome.Scene) error
+
+	// SaveUser saves the user and their preferences
+	SaveUser(sys *gohome.System, u *gohome.User) error
+
+	// DeleteUser removes the user
+	DeleteUser(sys *gohome.System, u *gohome.User) error
+
+	// SaveAreas saves the area hierarchy and the features assigned to each area
+	SaveAreas(sys *gohome.System) error
+}
+
+const (
+	// StoreTypeJSON saves the whole system to a single JSON file
+	StoreTypeJSON = "json"
+
+	// StoreTypeKV saves each item in the system as a separate record in a key value file
+	StoreTypeKV = "kv"
+)
+
+// New returns the store specified in the config, the system is saved at cfg.SystemPath
+func New(cfg *gohome.Config) (Store, error) {
+	switch cfg.StoreType {
+	case "", StoreTypeJSON:
+		return NewJSONStore(cfg.SystemPath), nil
+	case StoreTypeKV:
+		return NewKVStore(cfg.SystemPath), nil
+	default:
+		return nil, fmt.Errorf("unsupported store type: %s, must be %s or %s", cfg.StoreType, StoreTypeJSON, StoreTypeKV)
+	}
+}
+
+// LoadSystem loads a gohome data file from the specified path. Files saved by older versions
+// are migrated to the current version, the original file is backed up before it is upgraded.
+// If the file is corrupt, the newest backup that can be loaded is used instead. Files saved
+// by a newer version are not loaded, ErrNewerVersion is returned.
 func LoadSystem(path string) (*gohome.System, error) {
 
 	log.V("loading system from %s", path)
@@ -30,8 +86,97 @@ func LoadSystem(path string) (*gohome.System, error) {
 		return nil, ErrFileNotFound
 	}
 
+	migrated, version, err := migrateSystem(b)
+	if err == ErrNewerVersion {
+		return nil, errExt.Wrapf(err, "version %s, this version of goHOME supports up to %s", version, SystemVersion)
+	}
+
+	var sys *gohome.System
+	if err == nil {
+		sys, err = loadSystem(migrated)
+	}
+	if err == nil {
+		if version != SystemVersion {
+			if err := upgradeSystemFile(path, b, migrated, version); err != nil {
+				return nil, err
+			}
+		}
+		return sys, nil
+	}
+	log.E("failed to load system file %s: %s", path, err)
+
+	backups, backupErr := backupFiles(path)
+	if backupErr != nil {
+		return nil, err
+	}
+
+	for _, backupPath := range backups {
+		b, backupErr := ioutil.ReadFile(backupPath)
+		if backupErr != nil {
+			continue
+		}
+
+		b, _, backupErr = migrateSystem(b)
+		if backupErr != nil {
+			log.E("failed to migrate system backup %s: %s", backupPath, backupErr)
+			continue
+		}
+
+		sys, backupErr := loadSystem(b)
+		if backupErr != nil {
+			log.E("failed to load system backup %s: %s", backupPath, backupErr)
+			continue
+		}
+
+		log.E("loaded system from backup: %s, changes made after the backup was taken have been lost", backupPath)
+		return sys, nil
+	}
+	return nil, err
+}
+
+// upgradeSystemFile saves a copy of the original file, which is never removed, then replaces
+// the system file with the migrated contents. Files saved before secrets were encrypted contain
+// plain text device credentials, so they are removed from the copy and the backups of the old
+// file are deleted once the encrypted file has been written
+func upgradeSystemFile(path string, original, migrated []byte, version string) error {
+	plainSecrets := false
+	if cmp, err := compareVersions(version, encryptedSecretsVersion); err == nil && cmp < 0 {
+		scrubbed, ok, err := scrubPlainSecrets(original)
+		if err != nil {
+			return errExt.Wrap(err, "failed to remove plain text secrets from the system file backup")
+		}
+		original = scrubbed
+		plainSecrets = ok
+	}
+
+	originalPath := path + ".v" + version
+	if err := ioutil.WriteFile(originalPath, original, 0600); err != nil {
+		return errExt.Wrap(err, "failed to backup system file before upgrading")
+	}
+	if plainSecrets {
+		log.V("backed up system file version %s to %s, device passwords and tokens have been removed from the backup", version, originalPath)
+	} else {
+		log.V("backed up system file version %s to %s", version, originalPath)
+	}
+
+	if err := writeFileAtomic(path, migrated, 0644); err != nil {
+		return errExt.Wrap(err, "failed to save upgraded system file")
+	}
+	log.V("upgraded system file from version %s to %s", version, SystemVersion)
+
+	if plainSecrets {
+		if err := removeBackups(path); err != nil {
+			return errExt.Wrap(err, "failed to remove system file backups containing plain text secrets")
+		}
+		log.V("removed system file backups containing plain text secrets")
+	}
+	return nil
+}
+
+// loadSystem creates a system from the contents of a system file
+func loadSystem(b []byte) (*gohome.System, error) {
 	var s systemJSON
-	err = json.Unmarshal(b, &s)
+	err := json.Unmarshal(b, &s)
 	if err != nil {
 		log.V("failed to unmarshal system json: %s", err)
 		return nil, err
@@ -42,86 +187,158 @@ func LoadSystem(path string) (*gohome.System, error) {
 
 	// Load all devices into global device list
 	for _, d := range s.Devices {
-		var auth *gohome.Auth
-		if d.Auth != nil {
-			auth = &gohome.Auth{
-				Login:    d.Auth.Login,
-				Password: d.Auth.Password,
-				Token:    d.Auth.Token,
-			}
+		dev, err := newDevice(sys, d)
+		if err != nil {
+			return nil, err
 		}
+		sys.AddDevice(dev)
+	}
 
-		log.V("loaded Device: ID:%s, Name:%s, Model:%s, Address:%s", d.ID, d.Name, d.ModelNumber, d.Address)
-
-		dev := gohome.NewDevice(
-			d.ID,
-			d.Name,
-			d.Description,
-			d.ModelNumber,
-			d.ModelName,
-			d.SoftwareVersion,
-			d.Address,
-			nil,
-			nil,
-			nil,
-			auth)
-
-		cmdBuilder := sys.Extensions.FindCmdBuilder(sys, dev)
-		dev.CmdBuilder = cmdBuilder
-
-		if d.ConnPool != nil {
-			network := sys.Extensions.FindNetwork(sys, dev)
-			if network == nil {
-				return nil, fmt.Errorf("unsupported model number, no discoverer found: %s", d.ModelNumber)
-			}
+	// Have to go back through patching up devices to point to their child devices
+	// since we only store device ID pointers in the JSON
+	for _, d := range s.Devices {
+		if err := loadDeviceFeatures(sys, d); err != nil {
+			return nil, err
+		}
+	}
 
-			connFactory, err := network.NewConnection(sys, dev)
-			if err != nil {
-				return nil, err
-			}
+	if err := loadScenes(sys, s.Scenes); err != nil {
+		return nil, err
+	}
+
+	if err := loadAreas(sys, s.Areas); err != nil {
+		return nil, err
+	}
+
+	for _, u := range s.Users {
+		user := &gohome.User{
+			ID:        u.ID,
+			Login:     u.Login,
+			HashedPwd: u.HashedPwd,
+			Salt:      u.Salt,
+			Prefs: gohome.UserPrefs{
+				TempUnit: u.Prefs.TempUnit,
+				UI: gohome.UIPrefs{
+					HiddenFeatures: u.Prefs.HiddenFeatures,
+					FeatureOrder:   u.Prefs.FeatureOrder,
+					FavoriteScenes: u.Prefs.FavoriteScenes,
+					LandingAreaID:  u.Prefs.LandingAreaID,
+				},
+			},
+			Role:        u.Role,
+			TOTPEnabled: u.TOTPEnabled,
+		}
+		if len(u.RecoveryCodes) > 0 {
+			user.RecoveryCodes = u.RecoveryCodes
+		}
+		for _, grant := range u.Grants {
+			user.Grants = append(user.Grants, gohome.Grant{Type: grant.Type, ID: grant.ID})
+		}
+
+		totpSecret, err := decryptSecret(u.TOTPSecret)
+		if err != nil {
+			return nil, errExt.Wrapf(err, "failed to decrypt the TOTP secret of user: %s", u.ID)
+		}
+		user.TOTPSecret = totpSecret
+		sys.AddUser(user)
+	}
 
-			dev.Connections = pool.NewPool(pool.Config{
-				Name:          d.ConnPool.Name,
-				Size:          int(d.ConnPool.PoolSize),
-				NewConnection: connFactory,
+	return sys, nil
+}
 
-				//TODO: Need to store this in the system file, let imports decide this
-				RetryDuration: time.Second * 10,
-			})
+// newDevice creates a device from its saved JSON, the hub and features are added by loadDeviceFeatures
+// once all of the devices have been created
+func newDevice(sys *gohome.System, d deviceJSON) (*gohome.Device, error) {
+	var auth *gohome.Auth
+	if d.Auth != nil {
+		password, err := decryptSecret(d.Auth.Password)
+		if err != nil {
+			return nil, errExt.Wrapf(err, "failed to read password for device: %s", d.ID)
+		}
+		token, err := decryptSecret(d.Auth.Token)
+		if err != nil {
+			return nil, errExt.Wrapf(err, "failed to read token for device: %s", d.ID)
 		}
 
-		sys.AddDevice(dev)
+		auth = &gohome.Auth{
+			Login:    d.Auth.Login,
+			Password: password,
+			Token:    token,
+		}
 	}
 
-	// Have to go back through patching up devices to point to their child devices
-	// since we only store device ID pointers in the JSON
-	for _, d := range s.Devices {
-		dev := sys.DeviceByID(d.ID)
+	log.V("loaded Device: ID:%s, Name:%s, Model:%s, Address:%s", d.ID, d.Name, d.ModelNumber, d.Address)
+
+	dev := gohome.NewDevice(
+		d.ID,
+		d.Name,
+		d.Description,
+		d.ModelNumber,
+		d.ModelName,
+		d.SoftwareVersion,
+		d.Address,
+		nil,
+		nil,
+		nil,
+		auth)
+
+	cmdBuilder := sys.Extensions.FindCmdBuilder(sys, dev)
+	dev.CmdBuilder = cmdBuilder
+
+	if d.ConnPool != nil {
+		network := sys.Extensions.FindNetwork(sys, dev)
+		if network == nil {
+			return nil, fmt.Errorf("unsupported model number, no discoverer found: %s", d.ModelNumber)
+		}
 
-		// If the device has a hub we have to correctly set up that relationship
-		if d.HubID != "" {
-			hub := sys.DeviceByID(d.HubID)
-			if hub == nil {
-				return nil, fmt.Errorf("invalid hub ID: %s", d.HubID)
-			}
-			dev.Hub = hub
+		connFactory, err := network.NewConnection(sys, dev)
+		if err != nil {
+			return nil, err
 		}
 
-		dev.Features = d.Features
-		for _, f := range d.Features {
-			// When deserializing from JSON, the int32 and float32 types are converted
-			// to float64 so need to massage them back
-			attr.FixJSON(f.Attrs)
+		dev.Connections = pool.NewPool(pool.Config{
+			Name:          d.ConnPool.Name,
+			Size:          int(d.ConnPool.PoolSize),
+			NewConnection: connFactory,
+
+			//TODO: Need to store this in the system file, let imports decide this
+			RetryDuration: time.Second * 10,
+		})
+	}
+	return dev, nil
+}
+
+// loadDeviceFeatures sets the hub of the device and adds the features of the device to the system
+func loadDeviceFeatures(sys *gohome.System, d deviceJSON) error {
+	dev := sys.DeviceByID(d.ID)
 
-			log.V("loaded feature: ID:%s, Name:%s, Address: %s, Type:%s",
-				f.ID, f.Name, f.Address, f.Type)
-			sys.AddFeature(f)
+	// If the device has a hub we have to correctly set up that relationship
+	if d.HubID != "" {
+		hub := sys.DeviceByID(d.HubID)
+		if hub == nil {
+			return fmt.Errorf("invalid hub ID: %s", d.HubID)
 		}
+		dev.Hub = hub
+	}
+
+	dev.Features = d.Features
+	for _, f := range d.Features {
+		// When deserializing from JSON, the int32 and float32 types are converted
+		// to float64 so need to massage them back
+		attr.FixJSON(f.Attrs)
+
+		log.V("loaded feature: ID:%s, Name:%s, Address: %s, Type:%s",
+			f.ID, f.Name, f.Address, f.Type)
+		sys.AddFeature(f)
 	}
+	return nil
+}
 
+// loadScenes adds the scenes to the system, replacing any scenes with the same ID
+func loadScenes(sys *gohome.System, scenes []sceneJSON) error {
 	// First we have to load each scene, but without the commands, since a scene could have a
 	// sceneSet command referencing a scene which hasn't been loaded yet
-	for _, scn := range s.Scenes {
+	for _, scn := range scenes {
 		scene := &gohome.Scene{
 			Address:     scn.Address,
 			ID:          scn.ID,
@@ -134,7 +351,7 @@ func LoadSystem(path string) (*gohome.System, error) {
 		)
 	}
 
-	for _, scn := range s.Scenes {
+	for _, scn := range scenes {
 		scene := sys.SceneByID(scn.ID)
 		if scene == nil {
 			log.V("missing scene with ID: %s", scn.ID)
@@ -143,183 +360,294 @@ func LoadSystem(path string) (*gohome.System, error) {
 
 		scene.Commands = make([]cmd.Command, len(scn.Commands))
 		for i, command := range scn.Commands {
-			var finalCmd cmd.Command
-			switch command.Type {
-			case "sceneSet":
-				scn := sys.SceneByID(command.Attributes["SceneID"].(string))
-				if scn == nil {
-					return nil, fmt.Errorf("invalid scene ID: %s", command.Attributes["SceneID"].(string))
-				}
-				finalCmd = &cmd.SceneSet{
-					ID:        command.ID,
-					SceneID:   scn.ID,
-					SceneName: scn.Name,
-				}
-
-			case "featureSetAttrs":
-				interfaceAttrs := command.Attributes["attrs"].(map[string]interface{})
-
-				// Need to convert these to map[string]*attr.Attribute, don't see an easy way to
-				// do that, so just marshal then unmarshal back to the type we want since commands
-				// are generic
-				b, err := json.Marshal(interfaceAttrs)
-				if err != nil {
-					return nil, errExt.Wrap(err, "failed to retrieve attrs field")
-				}
-
-				attrs := make(map[string]*attr.Attribute)
-				err = json.Unmarshal(b, &attrs)
-				if err != nil {
-					return nil, errExt.Wrap(err, "failed to unmarshal attrs")
-				}
-				attr.FixJSON(attrs)
-
-				featureID := command.Attributes["featureId"].(string)
-				f := sys.FeatureByID(featureID)
-				if f == nil {
-					return nil, fmt.Errorf("invalid feature ID: %s", featureID)
-				}
-
-				finalCmd = &cmd.FeatureSetAttrs{
-					ID:          command.ID,
-					FeatureID:   f.ID,
-					FeatureName: f.Name,
-					FeatureType: f.Type,
-					Attrs:       attrs,
-				}
-
-			default:
-				return nil, fmt.Errorf("unknown command type %s", command.Type)
+			finalCmd, err := cmd.Decode(command.Type, command.ID, command.Attributes, sys)
+			if valErrs, ok := err.(*validation.Errors); ok {
+				return fmt.Errorf("invalid %s command %s in scene %s: %s", command.Type, command.ID, scene.ID, valErrs.Errors[0].Error())
+			} else if err != nil {
+				return errExt.Wrapf(err, "invalid command %s in scene %s", command.ID, scene.ID)
 			}
 			scene.Commands[i] = finalCmd
 		}
 	}
+	return nil
+}
 
-	for _, u := range s.Users {
-		user := &gohome.User{
-			ID:        u.ID,
-			Login:     u.Login,
-			HashedPwd: u.HashedPwd,
-			Salt:      u.Salt,
-		}
-		sys.AddUser(user)
+// SaveSystem saves the specified system to disk
+func SaveSystem(savePath string, s *gohome.System) error {
+	b, err := MarshalSystem(s)
+	if err != nil {
+		return err
 	}
+	return writeFileAtomic(savePath, b, 0644)
+}
 
-	return sys, nil
+// MarshalSystem returns the system in the system file format, device credentials are encrypted
+func MarshalSystem(s *gohome.System) ([]byte, error) {
+	out, err := systemToJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(out, "", "  ")
 }
 
-// SaveSystem saves the specified system to disk
-func SaveSystem(savePath string, s *gohome.System) error {
-	out := systemJSON{
-		Version:     "0.1.0",
+func systemToJSON(s *gohome.System) (*systemJSON, error) {
+	out := &systemJSON{
+		Version:     SystemVersion,
 		Name:        s.Name,
 		Description: s.Description,
 	}
 
 	scenes := s.Scenes()
-	out.Scenes = make([]sceneJSON, len(scenes))
-	var i = 0
+	out.Scenes = make([]sceneJSON, 0, len(scenes))
 	for _, scene := range scenes {
-		out.Scenes[i] = sceneJSON{
-			Address:     scene.Address,
-			ID:          scene.ID,
-			Name:        scene.Name,
-			Description: scene.Description,
-		}
-
-		cmds := make([]commandJSON, len(scene.Commands))
-		for j, sCmd := range scene.Commands {
-			switch xCmd := sCmd.(type) {
-			case *cmd.SceneSet:
-				cmds[j] = commandJSON{
-					ID:   xCmd.ID,
-					Type: "sceneSet",
-					Attributes: map[string]interface{}{
-						"SceneID": xCmd.SceneID,
-					},
-				}
-
-			case *cmd.FeatureSetAttrs:
-				cmds[j] = commandJSON{
-					ID:   xCmd.ID,
-					Type: "featureSetAttrs",
-					Attributes: map[string]interface{}{
-						"featureId": xCmd.FeatureID,
-						"attrs":     xCmd.Attrs,
-					},
-				}
-			default:
-				return fmt.Errorf("unknown command type")
-			}
+		sJSON, err := sceneToJSON(scene)
+		if err != nil {
+			return nil, err
 		}
-
-		out.Scenes[i].Commands = cmds
-		i++
+		out.Scenes = append(out.Scenes, sJSON)
 	}
 
-	i = 0
 	devices := s.Devices()
-	out.Devices = make([]deviceJSON, len(devices))
+	out.Devices = make([]deviceJSON, 0, len(devices))
 	for _, device := range devices {
-		hub := device.Hub
-		var hubID = ""
-		if hub != nil {
-			hubID = hub.ID
-		}
-
-		var poolJSON *connPoolJSON
-		if device.Connections != nil {
-			config := device.Connections.Config
-			poolJSON = &connPoolJSON{
-				Name:     config.Name,
-				PoolSize: int32(config.Size),
-			}
+		d, err := deviceToJSON(device)
+		if err != nil {
+			return nil, err
 		}
-		d := deviceJSON{
-			ID:              device.ID,
-			Address:         device.Address,
-			Name:            device.Name,
-			Description:     device.Description,
-			HubID:           hubID,
-			ModelNumber:     device.ModelNumber,
-			ModelName:       device.ModelName,
-			SoftwareVersion: device.SoftwareVersion,
-			ConnPool:        poolJSON,
-		}
-
-		if device.Auth != nil {
-			auth := device.Auth
-			d.Auth = &authJSON{
-				Login:    auth.Login,
-				Password: auth.Password,
-				Token:    auth.Token,
-			}
+		out.Devices = append(out.Devices, d)
+	}
+
+	users := s.Users()
+	out.Users = make([]userJSON, 0, len(users))
+	for _, u := range users {
+		user, err := userToJSON(s, u)
+		if err != nil {
+			return nil, err
 		}
+		out.Users = append(out.Users, user)
+	}
 
-		d.Features = device.Features
+	out.Areas = saveAreas(s)
+	return out, nil
+}
 
-		out.Devices[i] = d
-		i++
+func sceneToJSON(scene *gohome.Scene) (sceneJSON, error) {
+	out := sceneJSON{
+		Address:     scene.Address,
+		ID:          scene.ID,
+		Name:        scene.Name,
+		Description: scene.Description,
 	}
 
-	i = 0
-	users := s.Users()
-	out.Users = make([]userJSON, len(users))
-	for _, u := range users {
-		out.Users[i] = userJSON{
-			ID:        u.ID,
-			Login:     u.Login,
-			HashedPwd: u.HashedPwd,
-			Salt:      u.Salt,
+	cmds := make([]commandJSON, len(scene.Commands))
+	for j, sCmd := range scene.Commands {
+		cmdType, attrs, err := cmd.Encode(sCmd)
+		if err != nil {
+			return out, err
+		}
+		cmds[j] = commandJSON{
+			ID:         sCmd.GetID(),
+			Type:       cmdType,
+			Attributes: attrs,
+		}
+	}
+
+	out.Commands = cmds
+	return out, nil
+}
+
+func deviceToJSON(device *gohome.Device) (deviceJSON, error) {
+	hub := device.Hub
+	var hubID = ""
+	if hub != nil {
+		hubID = hub.ID
+	}
+
+	var poolJSON *connPoolJSON
+	if device.Connections != nil {
+		config := device.Connections.Config
+		poolJSON = &connPoolJSON{
+			Name:     config.Name,
+			PoolSize: int32(config.Size),
+		}
+	}
+	d := deviceJSON{
+		ID:              device.ID,
+		Address:         device.Address,
+		Name:            device.Name,
+		Description:     device.Description,
+		HubID:           hubID,
+		ModelNumber:     device.ModelNumber,
+		ModelName:       device.ModelName,
+		SoftwareVersion: device.SoftwareVersion,
+		ConnPool:        poolJSON,
+	}
+
+	if device.Auth != nil {
+		auth := device.Auth
+		password, err := encryptSecret(auth.Password)
+		if err != nil {
+			return d, errExt.Wrapf(err, "failed to save password for device: %s", device.ID)
+		}
+		token, err := encryptSecret(auth.Token)
+		if err != nil {
+			return d, errExt.Wrapf(err, "failed to save token for device: %s", device.ID)
+		}
+
+		d.Auth = &authJSON{
+			Login:    auth.Login,
+			Password: password,
+			Token:    token,
 		}
-		i++
 	}
 
-	b, err := json.MarshalIndent(out, "", "  ")
+	d.Features = device.Features
+	return d, nil
+}
+
+func userToJSON(sys *gohome.System, u *gohome.User) (userJSON, error) {
+	// The user may be updated by another request while it is being saved
+	u = sys.UserSnapshot(u)
+
+	grants := make([]grantJSON, len(u.Grants))
+	for i, grant := range u.Grants {
+		grants[i] = grantJSON{Type: grant.Type, ID: grant.ID}
+	}
+
+	totpSecret, err := encryptSecret(u.TOTPSecret)
+	if err != nil {
+		return userJSON{}, errExt.Wrapf(err, "failed to encrypt the TOTP secret of user: %s", u.ID)
+	}
+
+	recoveryCodes := u.RecoveryCodes
+	if recoveryCodes == nil {
+		recoveryCodes = []string{}
+	}
+	prefs := u.Prefs
+
+	return userJSON{
+		ID:        u.ID,
+		Login:     u.Login,
+		HashedPwd: u.HashedPwd,
+		Salt:      u.Salt,
+		Prefs: userPrefsJSON{
+			TempUnit:       prefs.TempUnit,
+			HiddenFeatures: prefs.UI.HiddenFeatures,
+			FeatureOrder:   prefs.UI.FeatureOrder,
+			FavoriteScenes: prefs.UI.FavoriteScenes,
+			LandingAreaID:  prefs.UI.LandingAreaID,
+		},
+		Role:          u.Role,
+		Grants:        grants,
+		TOTPSecret:    totpSecret,
+		TOTPEnabled:   u.TOTPEnabled,
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// loadAreas rebuilds the area hierarchy from the saved areas. If there are no saved areas the
+// system keeps the default root area
+func loadAreas(sys *gohome.System, saved []areaJSON) error {
+	if len(saved) == 0 {
+		return nil
+	}
+
+	root, err := buildAreas(saved, sys.FeatureByID)
 	if err != nil {
 		return err
 	}
+	sys.SetRootArea(root)
+	return nil
+}
+
+// buildAreas creates the area hierarchy from the saved areas and returns the root area, the
+// features in each area are found by calling featureByID
+func buildAreas(saved []areaJSON, featureByID func(ID string) *feature.Feature) (*gohome.Area, error) {
+	areas := make(map[string]*gohome.Area)
+	for _, a := range saved {
+		area := &gohome.Area{
+			ID:          a.ID,
+			Name:        a.Name,
+			Description: a.Description,
+		}
+		for _, featureID := range a.FeatureIDs {
+			f := featureByID(featureID)
+			if f == nil {
+				log.V("area %s contains unknown feature ID: %s, skipping", a.ID, featureID)
+				continue
+			}
+			area.AddFeature(f)
+		}
+		areas[a.ID] = area
+	}
+
+	var root *gohome.Area
+	for _, a := range saved {
+		area := areas[a.ID]
+		if a.ParentID == "" {
+			if root != nil {
+				return nil, fmt.Errorf("multiple root areas: %s, %s", root.ID, a.ID)
+			}
+			root = area
+		}
+
+		// Child order is stored in the parent so the UI shows areas in the order the user chose
+		for _, childID := range a.AreaIDs {
+			child, ok := areas[childID]
+			if !ok {
+				return nil, fmt.Errorf("invalid area ID: %s", childID)
+			}
+			if child.Parent != nil {
+				return nil, fmt.Errorf("area %s has multiple parents", childID)
+			}
+			area.AddArea(child)
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("missing root area")
+	}
+	for _, a := range saved {
+		if a.ParentID != "" && areas[a.ID].Parent == nil {
+			return nil, fmt.Errorf("area %s is not a child of its parent: %s", a.ID, a.ParentID)
+		}
+	}
+
+	return root, nil
+}
+
+// saveAreas flattens the area hierarchy, parents are always written before their children. The
+// areas are read while they are locked, so the saved hierarchy is consistent
+func saveAreas(sys *gohome.System) []areaJSON {
+	var out []areaJSON
+
+	var save func(a *gohome.Area)
+	save = func(a *gohome.Area) {
+		var parentID string
+		if a.Parent != nil {
+			parentID = a.Parent.ID
+		}
 
-	err = ioutil.WriteFile(savePath, b, 0644)
-	return err
+		aJSON := areaJSON{
+			ID:          a.ID,
+			Name:        a.Name,
+			Description: a.Description,
+			ParentID:    parentID,
+			FeatureIDs:  make([]string, 0, len(a.Features)),
+			AreaIDs:     make([]string, len(a.Areas)),
+		}
+		for _, f := range a.Features {
+			aJSON.FeatureIDs = append(aJSON.FeatureIDs, f.ID)
+		}
+		for i, child := range a.Areas {
+			aJSON.AreaIDs[i] = child.ID
+		}
+		out = append(out, aJSON)
+
+		for _, child := range a.Areas {
+			save(child)
+		}
+	}
+	sys.ReadAreas(save)
+	return out
 }
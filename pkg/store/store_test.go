@@ -0,0 +1,768 @@
+package store_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/store"
+	errExt "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func backups(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+
+	var names []string
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".backup-") {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+func TestSaveSystemRotatesBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	sys := gohome.NewSystem("test")
+	for i := 0; i < store.MaxBackups+3; i++ {
+		require.Nil(t, store.SaveSystem(savePath, sys))
+	}
+
+	require.Equal(t, store.MaxBackups, len(backups(t, dir)))
+
+	// Only the system file and the backups should be left, no temp or lock files
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, store.MaxBackups+1, len(files))
+}
+
+func TestLoadSystemFallsBackToBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, gohome.NewSystem("first")))
+	require.Nil(t, store.SaveSystem(savePath, gohome.NewSystem("second")))
+
+	// Simulate the file being corrupted part way through a write
+	require.Nil(t, ioutil.WriteFile(savePath, []byte(`{"name": "trunc`), 0644))
+
+	sys, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, "first", sys.Name)
+
+	_, err = store.LoadSystem(filepath.Join(dir, "missing.json"))
+	require.Equal(t, store.ErrFileNotFound, err)
+}
+
+func TestLoadSystemMigratesOldVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	original := []byte(`{"version": "0.1.0", "name": "old", "scenes": null, "devices": null, "users": null}`)
+	require.Nil(t, ioutil.WriteFile(savePath, original, 0644))
+
+	sys, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, "old", sys.Name)
+
+	// The original is kept, and the file is saved in the current version
+	b, err := ioutil.ReadFile(savePath + ".v0.1.0")
+	require.Nil(t, err)
+	require.Equal(t, original, b)
+
+	b, err = ioutil.ReadFile(savePath)
+	require.Nil(t, err)
+	require.Contains(t, string(b), `"version": "`+store.SystemVersion+`"`)
+}
+
+func TestLoadSystemRemovesPlainSecretsWhenEncrypting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := store.GenerateKeyFile(filepath.Join(dir, "gohome.key"))
+	require.Nil(t, err)
+	require.Nil(t, store.SetEncryptionKey(key))
+
+	savePath := filepath.Join(dir, "gohome.json")
+	original := []byte(`{"version": "0.4.0", "name": "old", "scenes": [], "users": [], "devices": [
+		{"id": "dev1", "name": "device", "auth": {"login": "admin", "password": "hunter2", "token": "abc123"}}]}`)
+	require.Nil(t, ioutil.WriteFile(savePath, original, 0644))
+	require.Nil(t, ioutil.WriteFile(savePath+".backup-20170101-000000.000000000", original, 0600))
+
+	sys, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, "hunter2", sys.DeviceByID("dev1").Auth.Password)
+
+	// The copy of the original and the backups must not contain the plain text credentials
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		require.Nil(t, err)
+		require.NotContains(t, string(b), "hunter2", f.Name())
+		require.NotContains(t, string(b), "abc123", f.Name())
+		require.NotContains(t, f.Name(), ".backup-")
+	}
+
+	b, err := ioutil.ReadFile(savePath + ".v0.4.0")
+	require.Nil(t, err)
+	require.Contains(t, string(b), `"login": "admin"`)
+}
+
+func TestLoadSystemRefusesNewerVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, ioutil.WriteFile(savePath, []byte(`{"version": "99.0.0", "name": "new"}`), 0644))
+
+	_, err = store.LoadSystem(savePath)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), store.ErrNewerVersion.Error())
+}
+
+func TestSaveSystemAreas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sys := gohome.NewSystem("areas")
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	light.DeviceID = dev.ID
+	dev.AddFeature(light)
+	sys.AddDevice(dev)
+	sys.AddFeature(light)
+
+	upstairs := &gohome.Area{ID: "upstairs", Name: "Upstairs"}
+	bedroom := &gohome.Area{ID: "bedroom", Name: "Bedroom"}
+	require.Nil(t, sys.AddArea(upstairs, nil))
+	require.Nil(t, sys.AddArea(bedroom, upstairs))
+	sys.SetFeatureArea(light, bedroom)
+
+	// Can't move an area inside one of its children
+	require.NotNil(t, sys.AddArea(upstairs, bedroom))
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, sys.Area.ID, loaded.Area.ID)
+	require.Equal(t, 3, len(loaded.Areas()))
+
+	loadedBedroom := loaded.AreaByID("bedroom")
+	require.NotNil(t, loadedBedroom)
+	require.Equal(t, "upstairs", loadedBedroom.Parent.ID)
+	require.Equal(t, loaded.Area, loadedBedroom.Parent.Parent)
+	require.Equal(t, 1, len(loaded.AreaByID("upstairs").AllFeatures()))
+
+	// Deleting an area moves its contents up to the parent
+	require.Nil(t, loaded.DeleteArea(loaded.AreaByID("upstairs")))
+	require.Equal(t, loaded.Area, loadedBedroom.Parent)
+	require.NotNil(t, loaded.DeleteArea(loaded.Area))
+}
+
+func TestSaveSystemUserPrefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sys := gohome.NewSystem("prefs")
+	sys.AddUser(&gohome.User{
+		ID:    "user1",
+		Login: "bob",
+		Prefs: gohome.UserPrefs{
+			TempUnit: "celcius",
+			UI: gohome.UIPrefs{
+				HiddenFeatures: map[string]bool{"f1": true},
+				FeatureOrder:   []string{"f2", "f1"},
+				FavoriteScenes: []string{"s1"},
+				LandingAreaID:  sys.Area.ID,
+			},
+		},
+	})
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, sys.UserByID("user1").Prefs, loaded.UserByID("user1").Prefs)
+}
+
+func TestSaveSystemEncryptsDeviceAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := store.GenerateKeyFile(filepath.Join(dir, "gohome.key"))
+	require.Nil(t, err)
+	require.Nil(t, store.SetEncryptionKey(key))
+
+	sys := gohome.NewSystem("secrets")
+	auth := &gohome.Auth{Login: "admin", Password: "hunter2", Token: "abc123"}
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, auth)
+	sys.AddDevice(dev)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	b, err := ioutil.ReadFile(savePath)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), "hunter2")
+	require.NotContains(t, string(b), "abc123")
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, *auth, *loaded.DeviceByID("dev1").Auth)
+
+	redacted, err := store.RedactSystem(b)
+	require.Nil(t, err)
+	require.NotContains(t, string(redacted), "enc:v1:")
+	require.Contains(t, string(redacted), `"login": "admin"`)
+}
+
+func TestKVStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.db")
+	kv := store.NewKVStore(savePath)
+	_, err = kv.Load()
+	require.Equal(t, store.ErrFileNotFound, err)
+
+	sys := gohome.NewSystem("kv")
+	require.Nil(t, kv.SaveSystem(sys))
+
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	sys.AddDevice(dev)
+	require.Nil(t, kv.SaveDevice(sys, dev))
+
+	scene := &gohome.Scene{ID: "scene1", Name: "Scene 1"}
+	sys.AddScene(scene)
+	require.Nil(t, kv.SaveScene(sys, scene))
+
+	user := &gohome.User{ID: "user1", Login: "bob"}
+	sys.AddUser(user)
+	require.Nil(t, kv.SaveUser(sys, user))
+
+	sys.DeleteScene(scene)
+	require.Nil(t, kv.DeleteScene(sys, scene))
+
+	// Simulate the process dying part way through appending a record
+	f, err := os.OpenFile(savePath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.Nil(t, err)
+	_, err = f.WriteString(`{"op":"put","key":"scene/scene2","val`)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	loaded, err := store.NewKVStore(savePath).Load()
+	require.Nil(t, err)
+	require.Equal(t, "kv", loaded.Name)
+	require.NotNil(t, loaded.DeviceByID("dev1"))
+	require.Equal(t, "bob", loaded.UserByID("user1").Login)
+	require.Equal(t, 0, len(loaded.Scenes()))
+}
+
+func TestKVStoreCompacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	savePath := filepath.Join(dir, "gohome.db")
+	kv := store.NewKVStore(savePath)
+
+	sys := gohome.NewSystem("kv")
+	user := &gohome.User{ID: "user1", Login: "bob"}
+	sys.AddUser(user)
+	require.Nil(t, kv.SaveSystem(sys))
+
+	for i := 0; i < 250; i++ {
+		require.Nil(t, kv.SaveUser(sys, user))
+	}
+
+	// Only the latest value of each record should be left, plus whatever was appended since
+	// the file was last compacted
+	b, err := ioutil.ReadFile(savePath)
+	require.Nil(t, err)
+	require.True(t, strings.Count(string(b), "\n") < 100)
+
+	loaded, err := store.NewKVStore(savePath).Load()
+	require.Nil(t, err)
+	require.Equal(t, "bob", loaded.UserByID("user1").Login)
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := gohome.NewDefaultConfig(filepath.Join(dir, "src"), "")
+	require.Nil(t, os.MkdirAll(filepath.Join(src.AutomationPath, "lights"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(src.AutomationPath, "lights", "on.yaml"), []byte("name: on"), 0644))
+	require.Nil(t, ioutil.WriteFile(src.EventLogPath, []byte("{}"), 0644))
+
+	key, err := store.GenerateKeyFile(src.KeyFilePath())
+	require.Nil(t, err)
+	require.Nil(t, store.SetEncryptionKey(key))
+
+	sys := gohome.NewSystem("backup")
+	auth := &gohome.Auth{Login: "admin", Password: "hunter2"}
+	sys.AddDevice(gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, auth))
+	require.Nil(t, store.SaveSystem(src.SystemPath, sys))
+
+	var buf bytes.Buffer
+	manifest, err := store.CreateBackup(&buf, src, store.BackupOptions{IncludeEvents: true})
+	require.Nil(t, err)
+	require.Equal(t, store.SystemVersion, manifest.SystemVersion)
+	require.Equal(t, 5, len(manifest.Files))
+
+	backup, err := store.ReadBackup(bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, src.SystemPath, backup.Config.SystemPath)
+
+	dest := gohome.NewDefaultConfig(filepath.Join(dir, "dest"), "")
+	require.Nil(t, os.MkdirAll(filepath.Dir(dest.SystemPath), 0755))
+	require.Nil(t, backup.Restore(dest))
+
+	b, err := ioutil.ReadFile(filepath.Join(dest.AutomationPath, "lights", "on.yaml"))
+	require.Nil(t, err)
+	require.Equal(t, "name: on", string(b))
+
+	loaded, err := store.LoadSystem(dest.SystemPath)
+	require.Nil(t, err)
+	require.Equal(t, "hunter2", loaded.DeviceByID("dev1").Auth.Password)
+
+	// Nothing is replaced if any of the files can't be written, here the system file
+	failed := *dest
+	failed.AutomationPath = filepath.Join(dir, "failed", "automation")
+	failed.KeyPath = filepath.Join(dir, "failed", "gohome.key")
+	failed.SystemPath = filepath.Join(dir, "missing", "gohome.json")
+	require.Nil(t, os.MkdirAll(filepath.Dir(failed.KeyPath), 0755))
+	err = backup.Restore(&failed)
+	require.NotNil(t, err)
+	require.NotEqual(t, store.ErrPartialRestore, errExt.Cause(err))
+	_, err = os.Stat(filepath.Join(failed.AutomationPath, "lights", "on.yaml"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(failed.KeyPath)
+	require.True(t, os.IsNotExist(err))
+	tmpFiles, err := filepath.Glob(filepath.Join(dir, "failed", "*", "*", "*.tmp-*"))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tmpFiles))
+
+	// A backup with a different key can't decrypt the credentials, so must be rejected
+	otherKey, err := store.GenerateKeyFile(filepath.Join(dir, "other.key"))
+	require.Nil(t, err)
+	require.Nil(t, store.SetEncryptionKey(otherKey))
+	require.Nil(t, store.SaveSystem(src.SystemPath, sys))
+	buf.Reset()
+	_, err = store.CreateBackup(&buf, src, store.BackupOptions{})
+	require.Nil(t, err)
+	_, err = store.ReadBackup(bytes.NewReader(buf.Bytes()))
+	require.NotNil(t, err)
+}
+
+func TestReadBackupRejectsInvalidFiles(t *testing.T) {
+	write := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, content := range files {
+			require.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte(content))
+			require.Nil(t, err)
+		}
+		require.Nil(t, tw.Close())
+		require.Nil(t, gz.Close())
+		return buf.Bytes()
+	}
+
+	_, err := store.ReadBackup(bytes.NewReader(write(map[string]string{"automation/../../etc/passwd": "x"})))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "invalid file name")
+
+	_, err = store.ReadBackup(bytes.NewReader(write(map[string]string{"config.json": "{}"})))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "missing manifest.json")
+
+	manifest := `{"version": 1, "storeType": "json", "files": [{"name": "config.json", "size": 2, "sha256": "bad"}]}`
+	_, err = store.ReadBackup(bytes.NewReader(write(map[string]string{"config.json": "{}", "manifest.json": manifest})))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "checksum mismatch")
+
+	_, err = store.ReadBackup(bytes.NewReader([]byte("not a backup")))
+	require.NotNil(t, err)
+}
+
+func TestSaveSystemSceneCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sys := gohome.NewSystem("scenes")
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	light.DeviceID = dev.ID
+	dev.AddFeature(light)
+	sys.AddDevice(dev)
+	sys.AddFeature(light)
+
+	onOff, _, _ := feature.LightZoneCloneAttrs(light)
+	first := &gohome.Scene{ID: "scene1", Name: "First"}
+	second := &gohome.Scene{ID: "scene2", Name: "Second"}
+	require.Nil(t, second.AddCommand(&cmd.SceneSet{ID: "cmd1", SceneID: first.ID, SceneName: first.Name}))
+	require.Nil(t, first.AddCommand(&cmd.FeatureSetAttrs{
+		ID:        "cmd2",
+		FeatureID: light.ID,
+		Attrs:     map[string]*attr.Attribute{onOff.LocalID: onOff},
+	}))
+	sys.AddScene(first)
+	sys.AddScene(second)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+
+	sceneSet := loaded.SceneByID("scene2").Commands[0].(*cmd.SceneSet)
+	require.Equal(t, "First", sceneSet.SceneName)
+
+	setAttrs := loaded.SceneByID("scene1").Commands[0].(*cmd.FeatureSetAttrs)
+	require.Equal(t, light.ID, setAttrs.FeatureID)
+	require.Equal(t, light.Type, setAttrs.FeatureType)
+	require.NotNil(t, setAttrs.Attrs[onOff.LocalID])
+
+	// Commands in files saved before the registry used featureId for the feature
+	original := `{"version": "0.5.0", "name": "old", "scenes": [{"id": "scene1", "commands": [
+		{"id": "cmd1", "type": "featureSetAttrs", "attributes": {"featureId": "` + light.ID + `", "attrs": {}}}]}],
+		"devices": [{"id": "dev1", "features": [{"id": "` + light.ID + `", "type": "LightZone", "deviceId": "dev1"}]}],
+		"users": [], "areas": []}`
+	require.Nil(t, ioutil.WriteFile(savePath, []byte(original), 0644))
+
+	loaded, err = store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, light.ID, loaded.SceneByID("scene1").Commands[0].(*cmd.FeatureSetAttrs).FeatureID)
+}
+
+func TestHistoryStoreRecordsAndRollsBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	history, err := store.NewHistory(filepath.Join(dir, "config-history"), 3)
+	require.Nil(t, err)
+	hs := store.NewHistoryStore(store.NewJSONStore(filepath.Join(dir, "gohome.json")), history)
+
+	sys := gohome.NewSystem("history")
+	require.Nil(t, hs.SaveSystem(sys))
+
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	sys.AddDevice(dev)
+	change := store.Change{User: "bob", Origin: "POST /api/v1/devices"}
+	require.Nil(t, store.WithChange(hs, change).SaveDevice(sys, dev))
+
+	scene := &gohome.Scene{ID: "scene1", Name: "Scene 1"}
+	sys.AddScene(scene)
+	require.Nil(t, hs.SaveScene(sys, scene))
+
+	revs, err := history.Revisions(0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(revs))
+	require.Equal(t, 3, revs[0].Rev)
+	require.Equal(t, change, revs[1].Change)
+
+	diff, err := history.Diff(2)
+	require.Nil(t, err)
+	require.Equal(t, []store.DiffEntry{{Kind: "device", ID: "dev1", Name: "device", Op: "added"}}, diff)
+
+	dev.Name = "renamed"
+	require.Nil(t, hs.SaveDevice(sys, dev))
+	diff, err = history.Diff(4)
+	require.Nil(t, err)
+	require.Equal(t, []store.DiffEntry{{Kind: "device", ID: "dev1", Name: "renamed", Op: "modified", Fields: []string{"name"}}}, diff)
+
+	// Only the newest revisions are kept
+	_, err = history.Snapshot(1)
+	require.Equal(t, store.ErrRevisionNotFound, err)
+
+	rev, err := hs.Rollback(sys, 2)
+	require.Nil(t, err)
+	require.Equal(t, 5, rev.Rev)
+	require.Equal(t, "device", sys.DeviceByID("dev1").Name)
+	require.Nil(t, sys.SceneByID("scene1"))
+
+	loaded, err := hs.Load()
+	require.Nil(t, err)
+	require.Equal(t, "device", loaded.DeviceByID("dev1").Name)
+	require.Equal(t, 0, len(loaded.Scenes()))
+}
+
+func TestRollbackSystemIsAllOrNothing(t *testing.T) {
+	sys := gohome.NewSystem("current")
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	light.DeviceID = dev.ID
+	dev.AddFeature(light)
+	sys.AddDevice(dev)
+	sys.AddFeature(light)
+	kitchen := &gohome.Area{ID: "kitchen", Name: "Kitchen"}
+	require.Nil(t, sys.AddArea(kitchen, nil))
+	sys.SetFeatureArea(light, kitchen)
+
+	// The hub doesn't exist, so nothing in the running system is changed
+	invalid := `{"version": "` + store.SystemVersion + `", "name": "invalid", "scenes": [], "users": [], "areas": [],
+		"devices": [{"id": "dev2", "name": "device 2", "hubId": "missing", "features": []}]}`
+	require.NotNil(t, store.RollbackSystem(sys, []byte(invalid)))
+	require.Equal(t, "current", sys.Name)
+	require.Equal(t, dev, sys.DeviceByID("dev1"))
+	require.Equal(t, light, sys.FeatureByID("light1"))
+	require.Nil(t, sys.DeviceByID("dev2"))
+	require.NotNil(t, sys.AreaByID("kitchen"))
+
+	// Snapshots without any areas have a new root area, the current areas are removed
+	noAreas := `{"version": "0.2.0", "name": "old", "scenes": [], "users": [], "devices": []}`
+	require.Nil(t, store.RollbackSystem(sys, []byte(noAreas)))
+	require.Equal(t, "old", sys.Name)
+	require.Nil(t, sys.DeviceByID("dev1"))
+	require.Nil(t, sys.FeatureByID("light1"))
+	require.Nil(t, sys.AreaByID("kitchen"))
+	require.Equal(t, 1, len(sys.Areas()))
+	require.Equal(t, 0, len(sys.Area.Features))
+}
+
+func TestReadOnlyStoreRejectsChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	history, err := store.NewHistory(filepath.Join(dir, "config-history"), 10)
+	require.Nil(t, err)
+	hs := store.NewHistoryStore(store.NewReadOnlyStore(store.NewJSONStore(filepath.Join(dir, "gohome.json"))), history)
+
+	sys := gohome.NewSystem("read only")
+	require.Nil(t, hs.SaveSystem(sys))
+
+	// The history store passes the read only state through to the store it wraps
+	require.True(t, store.SetReadOnly(hs, true))
+	require.True(t, store.IsReadOnly(store.WithChange(hs, store.Change{User: "bob"})))
+
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, nil)
+	sys.AddDevice(dev)
+	require.Equal(t, store.ErrReadOnly, hs.SaveDevice(sys, dev))
+	_, err = hs.Rollback(sys, 1)
+	require.Equal(t, store.ErrReadOnly, err)
+
+	// Nothing was saved or recorded while it was read only
+	loaded, err := hs.Load()
+	require.Nil(t, err)
+	require.Nil(t, loaded.DeviceByID("dev1"))
+	revs, err := history.Revisions(0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(revs))
+
+	require.True(t, store.SetReadOnly(hs, false))
+	require.Nil(t, hs.SaveDevice(sys, dev))
+
+	require.False(t, store.SetReadOnly(store.NewJSONStore(filepath.Join(dir, "gohome.json")), true))
+}
+
+func TestExportImportYAML(t *testing.T) {
+	sys := gohome.NewSystem("yaml")
+	dev := gohome.NewDevice("dev1", "device", "", "model", "", "", "address", nil, nil, nil, &gohome.Auth{Password: "hunter2"})
+	light := feature.NewLightZone("light1", feature.LightZoneModeBinary)
+	light.DeviceID = dev.ID
+	light.AutomationID = "kitchen_light"
+	light.Name = "Kitchen"
+	dev.AddFeature(light)
+	sys.AddDevice(dev)
+	sys.AddFeature(light)
+	sys.Area.AddFeature(light)
+
+	onOff, _, _ := feature.LightZoneCloneAttrs(light)
+	onOff.Value = attr.OnOffOn
+	scene := &gohome.Scene{ID: "scene1", Name: "Evening"}
+	require.Nil(t, scene.AddCommand(&cmd.FeatureSetAttrs{
+		ID:        "cmd1",
+		FeatureID: light.ID,
+		Attrs:     map[string]*attr.Attribute{onOff.LocalID: onOff},
+	}))
+	sys.AddScene(scene)
+
+	b, err := store.ExportYAML(sys)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), "hunter2")
+	require.NotContains(t, string(b), "light1")
+	require.Contains(t, string(b), "feature: kitchen_light")
+
+	again, err := store.ExportYAML(sys)
+	require.Nil(t, err)
+	require.Equal(t, string(b), string(again))
+
+	// A partial file only changes the parts it contains
+	partial := `
+scenes:
+- name: Evening
+  description: lights on
+- name: Movie
+  commands:
+  - type: sceneSet
+    scene: Evening
+  - type: featureSetAttrs
+    feature: kitchen_light
+    values:
+      onoff: 1
+areas:
+  name: Home
+  areas:
+  - name: Kitchen
+    features: [kitchen_light]
+`
+	require.Nil(t, store.ImportYAML(sys, []byte(partial)))
+	require.Equal(t, "yaml", sys.Name)
+	require.Equal(t, "lights on", sys.SceneByID("scene1").Description)
+	require.Equal(t, 1, len(sys.SceneByID("scene1").Commands))
+	require.Equal(t, 2, len(sys.Scenes()))
+
+	var movie *gohome.Scene
+	for _, scn := range sys.Scenes() {
+		if scn.Name == "Movie" {
+			movie = scn
+		}
+	}
+	require.NotNil(t, movie)
+	require.Equal(t, "scene1", movie.Commands[0].(*cmd.SceneSet).SceneID)
+	setAttrs := movie.Commands[1].(*cmd.FeatureSetAttrs)
+	require.Equal(t, light.ID, setAttrs.FeatureID)
+	require.Equal(t, attr.OnOffOff, setAttrs.Attrs[onOff.LocalID].Value)
+	require.Equal(t, "Kitchen", sys.FeatureArea(light.ID).Name)
+
+	// Fields left out of the file keep their current values
+	dev.Description = "hallway"
+	light.Description = "ceiling"
+	sys.SceneByID("scene1").Address = "12"
+	partial = `
+devices:
+- name: device
+  features:
+  - id: light1
+    name: Kitchen lights
+scenes:
+- name: Evening
+`
+	require.Nil(t, store.ImportYAML(sys, []byte(partial)))
+	require.Equal(t, "hallway", dev.Description)
+	require.Equal(t, "Kitchen lights", light.Name)
+	require.Equal(t, "kitchen_light", light.AutomationID)
+	require.Equal(t, "ceiling", light.Description)
+	require.Equal(t, "12", sys.SceneByID("scene1").Address)
+	require.Equal(t, "lights on", sys.SceneByID("scene1").Description)
+
+	// Names left out of the file are not changed, devices and features are found by ID
+	partial = `
+devices:
+- id: dev1
+  features:
+  - id: light1
+    description: pendant
+`
+	require.Nil(t, store.ImportYAML(sys, []byte(partial)))
+	require.Equal(t, "device", dev.Name)
+	require.Equal(t, "Kitchen lights", light.Name)
+	require.Equal(t, "pendant", light.Description)
+
+	// An empty value clears the field
+	require.Nil(t, store.ImportYAML(sys, []byte("devices:\n- name: device\n  description: \"\"\n")))
+	require.Equal(t, "", dev.Description)
+
+	require.NotNil(t, store.ImportYAML(sys, []byte("devices:\n- name: unknown\n")))
+}
+
+func TestSaveSystemUserRoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sys := gohome.NewSystem("roles")
+	sys.AddUser(&gohome.User{
+		ID:     "user1",
+		Login:  "babysitter",
+		Role:   gohome.RoleGuest,
+		Grants: []gohome.Grant{{Type: gohome.GrantArea, ID: sys.Area.ID}},
+	})
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, *sys.UserByID("user1"), *loaded.UserByID("user1"))
+
+	// Users saved before roles existed could do everything, so they are admins
+	original := `{"version": "0.6.0", "name": "old", "scenes": [], "devices": [], "areas": [],
+		"users": [{"id": "user1", "login": "bob", "prefs": {}}]}`
+	require.Nil(t, ioutil.WriteFile(savePath, []byte(original), 0644))
+
+	loaded, err = store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, gohome.RoleAdmin, loaded.UserByID("user1").Role)
+}
+
+func TestSaveSystemEncryptsTOTPSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohome-store")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := store.GenerateKeyFile(filepath.Join(dir, "gohome.key"))
+	require.Nil(t, err)
+	require.Nil(t, store.SetEncryptionKey(key))
+
+	sys := gohome.NewSystem("totp")
+	user := &gohome.User{ID: "user1", Login: "bob", Role: gohome.RoleAdmin, TOTPEnabled: true}
+	secret, err := gohome.GenerateTOTPSecret()
+	require.Nil(t, err)
+	user.TOTPSecret = secret
+	codes, err := user.GenerateRecoveryCodes()
+	require.Nil(t, err)
+	sys.AddUser(user)
+
+	savePath := filepath.Join(dir, "gohome.json")
+	require.Nil(t, store.SaveSystem(savePath, sys))
+
+	b, err := ioutil.ReadFile(savePath)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), secret)
+	require.NotContains(t, string(b), codes[0])
+
+	loaded, err := store.LoadSystem(savePath)
+	require.Nil(t, err)
+	require.Equal(t, *user, *loaded.UserByID("user1"))
+
+	redacted, err := store.RedactSystem(b)
+	require.Nil(t, err)
+	require.NotContains(t, string(redacted), "enc:v1:")
+	require.NotContains(t, string(redacted), user.RecoveryCodes[0])
+}
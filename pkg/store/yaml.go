@@ -0,0 +1,583 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/go-yaml/yaml"
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/cmd"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/validation"
+	errExt "github.com/pkg/errors"
+)
+
+// The YAML format is meant to be read, edited and diffed by people, so items reference each
+// other by name, or by automation ID for features, instead of by ID. An ID is only included when
+// the name is not unique. Secrets and users are never exported and attribute values are left out
+// since they change as devices are used. Optional fields are pointers, so importing a partial file
+// can tell a field that was left out, which keeps its current value, from one that was cleared.
+
+type yamlSystem struct {
+	Name        string       `yaml:"name,omitempty"`
+	Description string       `yaml:"description,omitempty"`
+	Devices     []yamlDevice `yaml:"devices,omitempty"`
+	Scenes      []yamlScene  `yaml:"scenes,omitempty"`
+	Areas       *yamlArea    `yaml:"areas,omitempty"`
+}
+
+type yamlDevice struct {
+	Name            string        `yaml:"name"`
+	ID              string        `yaml:"id,omitempty"`
+	Description     *string       `yaml:"description,omitempty"`
+	ModelNumber     string        `yaml:"modelNumber,omitempty"`
+	ModelName       string        `yaml:"modelName,omitempty"`
+	SoftwareVersion string        `yaml:"softwareVersion,omitempty"`
+	Address         string        `yaml:"address,omitempty"`
+	Hub             string        `yaml:"hub,omitempty"`
+	Features        []yamlFeature `yaml:"features,omitempty"`
+}
+
+type yamlFeature struct {
+	AID         *string `yaml:"aid,omitempty"`
+	ID          string  `yaml:"id,omitempty"`
+	Name        string  `yaml:"name"`
+	Type        string  `yaml:"type"`
+	Description *string `yaml:"description,omitempty"`
+	Address     string  `yaml:"address,omitempty"`
+}
+
+type yamlScene struct {
+	Name        string        `yaml:"name"`
+	ID          string        `yaml:"id,omitempty"`
+	Description *string       `yaml:"description,omitempty"`
+	Address     *string       `yaml:"address,omitempty"`
+	Commands    []yamlCommand `yaml:"commands"`
+}
+
+type yamlCommand struct {
+	Type string `yaml:"type"`
+
+	// Feature and Values are used by featureSetAttrs commands, values are keyed by attribute local ID
+	Feature string                 `yaml:"feature,omitempty"`
+	Values  map[string]interface{} `yaml:"values,omitempty"`
+
+	// Scene is used by sceneSet commands
+	Scene string `yaml:"scene,omitempty"`
+
+	// Attributes are used by all other types of commands, they are the same as in the system file
+	Attributes map[string]interface{} `yaml:"attributes,omitempty"`
+}
+
+type yamlArea struct {
+	Name        string     `yaml:"name"`
+	ID          string     `yaml:"id,omitempty"`
+	Description string     `yaml:"description,omitempty"`
+	Features    []string   `yaml:"features,omitempty"`
+	Areas       []yamlArea `yaml:"areas,omitempty"`
+}
+
+// ExportYAML returns the system configuration in a human friendly YAML format. Items are sorted
+// so exporting the same system twice gives the same output
+func ExportYAML(sys *gohome.System) ([]byte, error) {
+	out := yamlSystem{
+		Name:        sys.Name,
+		Description: sys.Description,
+	}
+
+	refs := newYAMLRefs(sys)
+	for _, d := range sys.Devices() {
+		yd := yamlDevice{
+			Name:            d.Name,
+			Description:     yamlString(d.Description),
+			ModelNumber:     d.ModelNumber,
+			ModelName:       d.ModelName,
+			SoftwareVersion: d.SoftwareVersion,
+			Address:         d.Address,
+		}
+		if refs.device(d) != d.Name {
+			yd.ID = d.ID
+		}
+		if d.Hub != nil {
+			yd.Hub = refs.device(d.Hub)
+		}
+
+		for _, f := range d.Features {
+			yf := yamlFeature{
+				Name:        f.Name,
+				Type:        f.Type,
+				Description: yamlString(f.Description),
+				Address:     f.Address,
+			}
+			if ref := refs.feature(f.ID); ref != f.ID {
+				yf.AID = yamlString(ref)
+			} else {
+				yf.AID = yamlString(f.AutomationID)
+				yf.ID = f.ID
+			}
+			yd.Features = append(yd.Features, yf)
+		}
+		sort.SliceStable(yd.Features, func(i, j int) bool {
+			a, b := yd.Features[i], yd.Features[j]
+			if aAID, bAID := yamlValue(a.AID), yamlValue(b.AID); aAID != bAID {
+				return aAID < bAID
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.ID < b.ID
+		})
+		out.Devices = append(out.Devices, yd)
+	}
+	sort.Slice(out.Devices, func(i, j int) bool {
+		a, b := out.Devices[i], out.Devices[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+
+	for _, scn := range sys.Scenes() {
+		ys := yamlScene{
+			Name:        scn.Name,
+			Description: yamlString(scn.Description),
+			Address:     yamlString(scn.Address),
+			Commands:    make([]yamlCommand, 0, len(scn.Commands)),
+		}
+		if refs.scene(scn.ID) != scn.Name {
+			ys.ID = scn.ID
+		}
+
+		for _, c := range scn.Commands {
+			yc, err := commandToYAML(refs, c)
+			if err != nil {
+				return nil, errExt.Wrapf(err, "failed to export scene %s", scn.ID)
+			}
+			ys.Commands = append(ys.Commands, yc)
+		}
+		out.Scenes = append(out.Scenes, ys)
+	}
+	sort.Slice(out.Scenes, func(i, j int) bool {
+		a, b := out.Scenes[i], out.Scenes[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+
+	areaNames := make(map[string]int)
+	for _, a := range sys.Areas() {
+		areaNames[a.Name]++
+	}
+	var exportArea func(a *gohome.Area) yamlArea
+	exportArea = func(a *gohome.Area) yamlArea {
+		ya := yamlArea{
+			Name:        a.Name,
+			Description: a.Description,
+		}
+		if areaNames[a.Name] > 1 {
+			ya.ID = a.ID
+		}
+		for _, f := range a.Features {
+			ya.Features = append(ya.Features, refs.feature(f.ID))
+		}
+		sort.Strings(ya.Features)
+
+		// Child areas are kept in the order the user chose
+		for _, child := range a.Areas {
+			ya.Areas = append(ya.Areas, exportArea(child))
+		}
+		return ya
+	}
+	if sys.Area != nil {
+		root := exportArea(sys.Area)
+		out.Areas = &root
+	}
+
+	return yaml.Marshal(out)
+}
+
+// ImportYAML merges a file in the format written by ExportYAML into the system. The file can be
+// partial, only the sections and fields it contains are changed:
+//   - devices: the names and descriptions of existing devices and their features can be changed,
+//     devices can't be added or removed
+//   - scenes: scenes are matched by ID or name, matching scenes are updated, others are added.
+//     Scenes not in the file are left unchanged
+//   - areas: the area hierarchy is replaced with the one in the file
+//
+// If an error is returned the system may be partially changed, so it should not be saved
+func ImportYAML(sys *gohome.System, b []byte) error {
+	var in yamlSystem
+	if err := yaml.Unmarshal(b, &in); err != nil {
+		return errExt.Wrap(err, "invalid YAML")
+	}
+
+	if in.Name != "" {
+		sys.Name = in.Name
+	}
+	if in.Description != "" {
+		sys.Description = in.Description
+	}
+
+	for _, yd := range in.Devices {
+		if err := importDevice(sys, yd); err != nil {
+			return err
+		}
+	}
+
+	if err := importScenes(sys, in.Scenes); err != nil {
+		return err
+	}
+
+	if in.Areas != nil {
+		if err := importAreas(sys, *in.Areas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importDevice(sys *gohome.System, yd yamlDevice) error {
+	var dev *gohome.Device
+	if yd.ID != "" {
+		dev = sys.DeviceByID(yd.ID)
+	} else {
+		for _, d := range sys.Devices() {
+			if d.Name != yd.Name {
+				continue
+			}
+			if dev != nil {
+				return fmt.Errorf("multiple devices are named %q, add the id of the device", yd.Name)
+			}
+			dev = d
+		}
+	}
+	if dev == nil {
+		return fmt.Errorf("unknown device %q, devices can only be added by importing hardware", yd.Name)
+	}
+
+	// Names can't be empty, so a device or feature without a name keeps its current one
+	if yd.Name != "" {
+		dev.Name = yd.Name
+	}
+	if yd.Description != nil {
+		dev.Description = *yd.Description
+	}
+
+	for _, yf := range yd.Features {
+		ref := yf.ID
+		if ref == "" {
+			ref = yamlValue(yf.AID)
+		}
+		f := sys.FeatureByID(ref)
+		if f == nil {
+			f = sys.FeatureByAID(ref)
+		}
+		if f == nil || f.DeviceID != dev.ID {
+			return fmt.Errorf("unknown feature %q in device %q", ref, dev.Name)
+		}
+
+		// A feature referenced by its ID without an aid keeps its automation ID
+		if yf.AID != nil {
+			if AID := *yf.AID; AID != "" && AID != f.AutomationID {
+				if other := sys.FeatureByAID(AID); other != nil && other != f {
+					return fmt.Errorf("automation ID %q is already used by feature %q", AID, other.Name)
+				}
+			}
+			f.AutomationID = *yf.AID
+		}
+		if yf.Name != "" {
+			f.Name = yf.Name
+		}
+		if yf.Description != nil {
+			f.Description = *yf.Description
+		}
+		if errs := f.Validate(); errs != nil {
+			return fmt.Errorf("invalid feature %q in device %q: %s", ref, dev.Name, errs)
+		}
+	}
+	return nil
+}
+
+func importScenes(sys *gohome.System, scenes []yamlScene) error {
+	// All of the scenes are added before their commands, since a sceneSet command could reference
+	// a scene further down the file
+	imported := make([]*gohome.Scene, len(scenes))
+	for i, ys := range scenes {
+		var scene *gohome.Scene
+		if ys.ID != "" {
+			scene = sys.SceneByID(ys.ID)
+		} else {
+			for _, scn := range sys.Scenes() {
+				if scn.Name != ys.Name {
+					continue
+				}
+				if scene != nil {
+					return fmt.Errorf("multiple scenes are named %q, add the id of the scene", ys.Name)
+				}
+				scene = scn
+			}
+		}
+		if scene == nil {
+			scene = &gohome.Scene{ID: ys.ID}
+			if scene.ID == "" {
+				scene.ID = sys.NewID()
+			}
+			sys.AddScene(scene)
+		}
+
+		scene.Name = ys.Name
+		if ys.Description != nil {
+			scene.Description = *ys.Description
+		}
+		if ys.Address != nil {
+			scene.Address = *ys.Address
+		}
+		if errs := scene.Validate(); errs != nil {
+			return fmt.Errorf("invalid scene %q: %s", ys.Name, errs)
+		}
+		imported[i] = scene
+	}
+
+	for i, ys := range scenes {
+		// Leaving out the commands keeps the current commands
+		if ys.Commands == nil {
+			continue
+		}
+
+		commands := make([]cmd.Command, len(ys.Commands))
+		for j, yc := range ys.Commands {
+			c, err := commandFromYAML(sys, yc)
+			if err != nil {
+				return errExt.Wrapf(err, "invalid command %d in scene %q", j+1, ys.Name)
+			}
+			commands[j] = c
+		}
+		imported[i].Commands = commands
+	}
+	return nil
+}
+
+func importAreas(sys *gohome.System, root yamlArea) error {
+	current := sys.Areas()
+	names := make(map[string][]*gohome.Area)
+	for _, a := range current {
+		names[a.Name] = append(names[a.Name], a)
+	}
+
+	// Areas keep their ID if they already exist, so references to them aren't broken
+	var areas []areaJSON
+	var flatten func(ya yamlArea, parentID string) (string, error)
+	flatten = func(ya yamlArea, parentID string) (string, error) {
+		if ya.Name == "" {
+			return "", fmt.Errorf("areas must have a name")
+		}
+
+		ID := ya.ID
+		if ID == "" {
+			if matches := names[ya.Name]; len(matches) == 1 {
+				ID = matches[0].ID
+			} else if len(matches) > 1 {
+				return "", fmt.Errorf("multiple areas are named %q, add the id of the area", ya.Name)
+			} else {
+				ID = sys.NewID()
+			}
+		}
+
+		area := areaJSON{
+			ID:          ID,
+			Name:        ya.Name,
+			Description: ya.Description,
+			ParentID:    parentID,
+			FeatureIDs:  make([]string, 0, len(ya.Features)),
+		}
+		for _, ref := range ya.Features {
+			f := sys.FeatureByID(ref)
+			if f == nil {
+				f = sys.FeatureByAID(ref)
+			}
+			if f == nil {
+				return "", fmt.Errorf("unknown feature %q in area %q", ref, ya.Name)
+			}
+			area.FeatureIDs = append(area.FeatureIDs, f.ID)
+		}
+
+		i := len(areas)
+		areas = append(areas, area)
+		for _, child := range ya.Areas {
+			childID, err := flatten(child, ID)
+			if err != nil {
+				return "", err
+			}
+			areas[i].AreaIDs = append(areas[i].AreaIDs, childID)
+		}
+		return ID, nil
+	}
+
+	if _, err := flatten(root, ""); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range areas {
+		if seen[a.ID] {
+			return fmt.Errorf("area %q is in the file more than once", a.Name)
+		}
+		seen[a.ID] = true
+	}
+	return loadAreas(sys, areas)
+}
+
+// yamlString returns a pointer to s, or nil if s is empty so it is left out of the file
+func yamlString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// yamlValue returns the value of an optional field, empty if it was left out of the file
+func yamlValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func commandToYAML(refs *yamlRefs, c cmd.Command) (yamlCommand, error) {
+	cmdType, attrs, err := cmd.Encode(c)
+	if err != nil {
+		return yamlCommand{}, err
+	}
+
+	yc := yamlCommand{Type: cmdType}
+	switch c := c.(type) {
+	case *cmd.FeatureSetAttrs:
+		yc.Feature = refs.feature(c.FeatureID)
+		yc.Values = make(map[string]interface{})
+		for localID, attribute := range c.Attrs {
+			yc.Values[localID] = attribute.Value
+		}
+	case *cmd.SceneSet:
+		yc.Scene = refs.scene(c.SceneID)
+	default:
+		yc.Attributes = attrs
+	}
+	return yc, nil
+}
+
+func commandFromYAML(sys *gohome.System, yc yamlCommand) (cmd.Command, error) {
+	attrs := yc.Attributes
+	switch yc.Type {
+	case "featureSetAttrs":
+		f := sys.FeatureByID(yc.Feature)
+		if f == nil {
+			f = sys.FeatureByAID(yc.Feature)
+		}
+		if f == nil {
+			return nil, fmt.Errorf("unknown feature %q", yc.Feature)
+		}
+
+		// Only the values are in the file, the rest of each attribute is filled in from the
+		// feature when the command is validated
+		values := make(map[string]*attr.Attribute)
+		for localID, val := range yc.Values {
+			values[localID] = &attr.Attribute{LocalID: localID, Value: val}
+		}
+		attrs = map[string]interface{}{"id": f.ID, "type": f.Type, "attrs": values}
+	case "sceneSet":
+		scene := sys.SceneByID(yc.Scene)
+		if scene == nil {
+			for _, scn := range sys.Scenes() {
+				if scn.Name != yc.Scene {
+					continue
+				}
+				if scene != nil {
+					return nil, fmt.Errorf("multiple scenes are named %q, use the id of the scene", yc.Scene)
+				}
+				scene = scn
+			}
+		}
+		if scene == nil {
+			return nil, fmt.Errorf("unknown scene %q", yc.Scene)
+		}
+		attrs = map[string]interface{}{"SceneID": scene.ID}
+	}
+
+	// YAML and JSON decode numbers differently, commands expect the values they would get from
+	// the system file so convert the attributes to JSON and back
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	var jsonAttrs map[string]interface{}
+	if err := json.Unmarshal(b, &jsonAttrs); err != nil {
+		return nil, err
+	}
+
+	c, err := cmd.Decode(yc.Type, sys.NewID(), jsonAttrs, sys)
+	if valErrs, ok := err.(*validation.Errors); ok {
+		return nil, fmt.Errorf("%s", valErrs.Errors[0].Error())
+	} else if err != nil {
+		return nil, err
+	}
+	if valErrs := cmd.Validate(c, sys); valErrs != nil {
+		return nil, fmt.Errorf("%s", valErrs.Errors[0].Error())
+	}
+	return c, nil
+}
+
+// yamlRefs finds the human friendly names used to reference items in the YAML file, a name can
+// only be used if no other item of the same type has the same name
+type yamlRefs struct {
+	sys         *gohome.System
+	aids        map[string]int
+	sceneNames  map[string]int
+	deviceNames map[string]int
+}
+
+func newYAMLRefs(sys *gohome.System) *yamlRefs {
+	refs := &yamlRefs{
+		sys:         sys,
+		aids:        make(map[string]int),
+		sceneNames:  make(map[string]int),
+		deviceNames: make(map[string]int),
+	}
+	for _, d := range sys.Devices() {
+		refs.deviceNames[d.Name]++
+		for _, f := range d.Features {
+			refs.aids[f.AutomationID]++
+		}
+	}
+	for _, scn := range sys.Scenes() {
+		refs.sceneNames[scn.Name]++
+	}
+	return refs
+}
+
+// feature returns the automation ID of the feature if it is unique, otherwise the feature ID
+func (r *yamlRefs) feature(ID string) string {
+	f := r.sys.FeatureByID(ID)
+	if f != nil && f.AutomationID != "" && r.aids[f.AutomationID] == 1 {
+		return f.AutomationID
+	}
+	return ID
+}
+
+// scene returns the name of the scene if it is unique, otherwise the scene ID
+func (r *yamlRefs) scene(ID string) string {
+	scene := r.sys.SceneByID(ID)
+	if scene != nil && r.sceneNames[scene.Name] == 1 {
+		return scene.Name
+	}
+	return ID
+}
+
+// device returns the name of the device if it is unique, otherwise the device ID
+func (r *yamlRefs) device(d *gohome.Device) string {
+	if r.deviceNames[d.Name] == 1 {
+		return d.Name
+	}
+	return d.ID
+}
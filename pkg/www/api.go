@@ -1,36 +1,171 @@
 package www
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/markdaws/gohome/pkg/gohome"
 	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/store"
 	"github.com/markdaws/gohome/pkg/validation"
 )
 
-func CheckValidSession(sessions *gohome.Sessions) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+type contextKey string
+
+const (
+	// userContextKey is the request context key for the user that owns the session
+	userContextKey contextKey = "user"
+
+	// sessionContextKey is the request context key for the session used to make the request
+	sessionContextKey contextKey = "session"
+
+	// tokenContextKey is the request context key for the API token used to make the request
+	tokenContextKey contextKey = "token"
+
+	// accessContextKey is the request context key for the user to check permissions against
+	accessContextKey contextKey = "access"
+)
+
+// requestUser returns the user that made the request, nil if the user is not known
+func requestUser(r *http.Request) *gohome.User {
+	user, _ := r.Context().Value(userContextKey).(*gohome.User)
+	return user
+}
+
+// requestSession returns the session used to make the request, false if the request has no session
+func requestSession(r *http.Request) (gohome.Session, bool) {
+	session, ok := r.Context().Value(sessionContextKey).(gohome.Session)
+	return session, ok
+}
+
+// requestToken returns the API token used to make the request, false if the request was not made
+// with a token
+func requestToken(r *http.Request) (gohome.Token, bool) {
+	token, ok := r.Context().Value(tokenContextKey).(gohome.Token)
+	return token, ok
+}
+
+// requestAccess returns the user to check permissions against. This is a snapshot of the user that
+// made the request, limited to the role of the API token if the request was made with a token, or
+// to the member role if the user is an admin who must enrol in two factor authentication. Use
+// requestUser to get the user if it is going to be modified
+func requestAccess(r *http.Request) *gohome.User {
+	if user, ok := r.Context().Value(accessContextKey).(*gohome.User); ok {
+		return user
+	}
+	return requestUser(r)
+}
+
+// requestChange describes the change made by the request, so it can be recorded in the
+// configuration history
+func requestChange(r *http.Request) store.Change {
+	c := store.Change{Origin: r.Method + " " + r.URL.Path}
+	if user := requestAccess(r); user != nil {
+		c.User = user.Login
+	}
+	if token, ok := requestToken(r); ok {
+		c.Origin += " (token: " + token.Name + ")"
+	}
+	return c
+}
+
+// requestOrigin returns the origin of commands run by the request, which is the user making the
+// request and the API token they used, if any
+func requestOrigin(r *http.Request) gohome.Origin {
+	var origin gohome.Origin
+	if user := requestAccess(r); user != nil {
+		origin.UserID = user.ID
+		origin.Login = user.Login
+	}
+	if token, ok := requestToken(r); ok {
+		origin.TokenID = token.ID
+	}
+	return origin
+}
+
+// requestOwner returns what identifies the credentials used to make the request, the ID of the
+// API token or of the session. Empty if the request has neither
+func requestOwner(r *http.Request) string {
+	if token, ok := requestToken(r); ok {
+		return "token:" + token.ID
+	}
+	if session, ok := requestSession(r); ok {
+		return "session:" + session.ID
+	}
+	return ""
+}
+
+// CheckValidSession verifies the request has a valid session ID, in the sid query parameter or
+// cookie, or a valid API token in the Authorization header. The user that owns the session or
+// token is added to the request context and can be retrieved by calling requestUser. If
+// requireAdminTOTP is true, admins who haven't enrolled in two factor authentication only have
+// the permissions of a member
+func CheckValidSession(sessions *gohome.Sessions, tokens *gohome.Tokens, system *gohome.System, requireAdminTOTP bool) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			if !strings.HasPrefix(auth, "Bearer ") {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			token, ok := tokens.Get(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+			if !ok {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			// The owner may have been removed since the token was created
+			user := system.UserByID(token.UserID)
+			if user == nil {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), tokenContextKey, token)
+			ctx = context.WithValue(ctx, userContextKey, user)
+			access := system.UserSnapshot(user)
+			ctx = context.WithValue(ctx, accessContextKey, token.User(access.TOTPLimited(requireAdminTOTP)))
+			next(rw, r.WithContext(ctx))
+			return
+		}
+
 		pairs, err := url.ParseQuery(r.URL.RawQuery)
 		if err != nil {
 			rw.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		sid, ok := pairs["sid"]
-		if !ok || len(sid) == 0 {
+		// The web UI can't read the session cookie, so the session ID is either in the sid query
+		// parameter or the cookie
+		var sid string
+		if vals := pairs["sid"]; len(vals) > 0 {
+			sid = vals[0]
+		} else if cookie, err := r.Cookie("sid"); err == nil {
+			sid = cookie.Value
+		}
+		if sid == "" {
 			rw.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		_, ok = sessions.Get(sid[0])
+		session, ok := sessions.Get(sid)
 		if !ok {
 			rw.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		ctx := context.WithValue(r.Context(), sessionContextKey, session)
+		if user := system.UserByID(session.UserID); user != nil {
+			ctx = context.WithValue(ctx, userContextKey, user)
+			access := system.UserSnapshot(user)
+			ctx = context.WithValue(ctx, accessContextKey, access.TOTPLimited(requireAdminTOTP))
+		}
+		r = r.WithContext(ctx)
+
 		// If we got here, the user has a valid session ID, go to next handler
 		next(rw, r)
 	}
@@ -0,0 +1,303 @@
+package www
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/store"
+	"github.com/markdaws/gohome/pkg/validation"
+	errExt "github.com/pkg/errors"
+)
+
+// RegisterAreaHandlers registers all of the area specific API REST routes
+func RegisterAreaHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/areas",
+		apiAreasHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/areas",
+		apiAreaHandlerCreate(s.store, s.system)).Methods("POST")
+	r.HandleFunc("/v1/areas/{id}",
+		apiAreaHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/areas/{id}",
+		apiAreaHandlerUpdate(s.store, s.system)).Methods("PUT")
+	r.HandleFunc("/v1/areas/{id}",
+		apiAreaHandlerDelete(s.store, s.system)).Methods("DELETE")
+	r.HandleFunc("/v1/areas/{id}/features",
+		apiAreaFeaturesHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/areas/{id}/features/{fid}",
+		apiAreaAddFeatureHandler(s.store, s.system)).Methods("PUT")
+	r.HandleFunc("/v1/areas/{id}/features/{fid}",
+		apiAreaRemoveFeatureHandler(s.store, s.system)).Methods("DELETE")
+}
+
+// systemAreaToJSON converts an area in the system to its JSON representation, the areas are
+// locked so other requests can't change the area while it is read
+func systemAreaToJSON(system *gohome.System, area *gohome.Area) jsonArea {
+	var a jsonArea
+	system.ReadAreas(func(*gohome.Area) {
+		a = AreaToJSON(area)
+	})
+	return a
+}
+
+// AreaToJSON converts an area to its JSON representation, child areas and features are
+// referenced by ID
+func AreaToJSON(area *gohome.Area) jsonArea {
+	a := jsonArea{
+		ID:          area.ID,
+		Name:        area.Name,
+		Description: area.Description,
+		AreaIDs:     make([]string, len(area.Areas)),
+		FeatureIDs:  make([]string, len(area.Features)),
+	}
+	if area.Parent != nil {
+		a.ParentID = area.Parent.ID
+	}
+	for i, child := range area.Areas {
+		a.AreaIDs[i] = child.ID
+	}
+	for i, f := range area.Features {
+		a.FeatureIDs[i] = f.ID
+	}
+	return a
+}
+
+func apiAreasHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areas := make(jsonAreas, 0)
+		for _, area := range system.Areas() {
+			areas = append(areas, systemAreaToJSON(system, area))
+		}
+		sort.Sort(areas)
+		resp(apiResponse{Data: areas}, w)
+	}
+}
+
+func apiAreaHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+		resp(apiResponse{Data: systemAreaToJSON(system, area)}, w)
+	}
+}
+
+// apiAreaFeaturesHandler returns all of the features in the area, including the features of all
+// child areas. Pass recursive=false to only return the features directly inside the area
+func apiAreaFeaturesHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+
+		features := system.AreaFeatures(area, r.URL.Query().Get("recursive") != "false")
+
+		// Temperatures are returned in the unit the user has chosen in their preferences
+		user := requestAccess(r)
+		features = featuresToTempUnit(accessibleFeatures(user, system, features), userTempUnit(system, user))
+		if features == nil {
+			features = []*feature.Feature{}
+		}
+		resp(apiResponse{Data: features}, w)
+	}
+}
+
+func apiAreaHandlerCreate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+		if err != nil {
+			respBadRequest("unable to read request body", w)
+			return
+		}
+
+		var data jsonArea
+		if err = json.Unmarshal(body, &data); err != nil {
+			respBadRequest("unable to parse request body, invalid JSON", w)
+			return
+		}
+
+		area := &gohome.Area{
+			ID:          system.NewID(),
+			Name:        data.Name,
+			Description: data.Description,
+		}
+
+		valErrs := area.Validate()
+		if valErrs != nil {
+			respValErr(&data, data.ID, valErrs, w)
+			return
+		}
+
+		var parent *gohome.Area
+		if data.ParentID != "" {
+			parent = system.AreaByID(data.ParentID)
+			if parent == nil {
+				respValErr(&data, data.ID, validation.NewErrors("ParentID", "invalid area ID", false), w)
+				return
+			}
+		}
+
+		if err = system.AddArea(area, parent); err != nil {
+			respValErr(&data, data.ID, validation.NewErrors("ParentID", err.Error(), false), w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveAreas(system)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: systemAreaToJSON(system, area)}, w)
+	}
+}
+
+func apiAreaHandlerUpdate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+		if err != nil {
+			respBadRequest("unable to read request body", w)
+			return
+		}
+
+		var updates struct {
+			Name        *string `json:"name"`
+			Description *string `json:"description"`
+			ParentID    *string `json:"parentId"`
+		}
+		if err = json.Unmarshal(body, &updates); err != nil {
+			respBadRequest("unable to parse request body, invalid JSON", w)
+			return
+		}
+
+		var parent *gohome.Area
+		if updates.ParentID != nil {
+			parent = system.AreaByID(*updates.ParentID)
+			if parent == nil {
+				respValErr(&updates, areaID, validation.NewErrors("ParentID", "invalid area ID", false), w)
+				return
+			}
+		}
+
+		if valErrs := system.UpdateArea(area, updates.Name, updates.Description, parent); valErrs != nil {
+			respValErr(&updates, areaID, valErrs, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveAreas(system)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: systemAreaToJSON(system, area)}, w)
+	}
+}
+
+func apiAreaHandlerDelete(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+
+		if err := system.DeleteArea(area); err != nil {
+			respBadRequest(err.Error(), w)
+			return
+		}
+
+		err := store.WithChange(sysStore, requestChange(r)).SaveAreas(system)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(struct{}{})
+	}
+}
+
+// apiAreaAddFeatureHandler moves the feature in to the area, a feature can only be in one area
+// so it is removed from the area it was previously in
+func apiAreaAddFeatureHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+
+		featureID := mux.Vars(r)["fid"]
+		f := system.FeatureByID(featureID)
+		if f == nil {
+			respBadRequest(fmt.Sprintf("invalid feature ID: %s", featureID), w)
+			return
+		}
+
+		system.SetFeatureArea(f, area)
+
+		err := store.WithChange(sysStore, requestChange(r)).SaveAreas(system)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: systemAreaToJSON(system, area)}, w)
+	}
+}
+
+func apiAreaRemoveFeatureHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areaID := mux.Vars(r)["id"]
+		area := system.AreaByID(areaID)
+		if area == nil {
+			respBadRequest(fmt.Sprintf("invalid area ID: %s", areaID), w)
+			return
+		}
+
+		featureID := mux.Vars(r)["fid"]
+		var f *feature.Feature
+		for _, af := range system.AreaFeatures(area, false) {
+			if af.ID == featureID {
+				f = af
+				break
+			}
+		}
+		if f == nil {
+			respBadRequest(fmt.Sprintf("area: %s does not contain feature: %s", areaID, featureID), w)
+			return
+		}
+
+		system.SetFeatureArea(f, nil)
+
+		err := store.WithChange(sysStore, requestChange(r)).SaveAreas(system)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: systemAreaToJSON(system, area)}, w)
+	}
+}
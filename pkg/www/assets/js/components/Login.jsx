@@ -14,7 +14,8 @@ const logo = require('../../images/logo-min.png');
 var Login = React.createClass({
     getInitialState: function() {
         return {
-            error: null
+            error: null,
+            challenge: null
         };
     },
 
@@ -25,6 +26,18 @@ var Login = React.createClass({
 
         var $el = $(ReactDOM.findDOMNode(this))
         var login = $el.find('#login').val();
+
+        if (this.state.challenge) {
+            var code = $el.find('#code').val();
+            if (code === '') {
+                this.setState({error: {msg: 'code cannot be empty' }});
+                return;
+            }
+
+            Api.sessionCreateWithCode(login, this.state.challenge, code, this.loginComplete);
+            return;
+        }
+
         var password = $el.find('#password').val();
 
         if (login === '') {
@@ -36,16 +49,24 @@ var Login = React.createClass({
             return;
         }
 
-        Api.sessionCreate(login, password, function(err, data) {
-            if (err) {
-                this.setState({error: err});
-                return
-            }
+        Api.sessionCreate(login, password, this.loginComplete);
+    },
 
-            window.location = '/';
+    loginComplete: function(err, data) {
+        if (err) {
+            this.setState({error: err});
+            return
+        }
 
-            //expire cookie: document.cookie = 'sid=; expires=Thu, 01 Jan 1970 00:00:01 GMT;'
-        }.bind(this));
+        // The user has two factor authentication, they need to enter a code to finish
+        if (data.totpRequired) {
+            this.setState({challenge: data.challenge});
+            return;
+        }
+
+        window.location = '/';
+
+        //expire cookie: document.cookie = 'sid=; expires=Thu, 01 Jan 1970 00:00:01 GMT;'
     },
 
     render: function() {
@@ -68,9 +89,20 @@ var Login = React.createClass({
                             placeholder="Login"></input>
                     </div>
 
-                    <div className="form-group">
+                    <div className={'form-group' + (this.state.challenge ? ' hidden' : '')}>
                         <input type="password" className="form-control" id="password" placeholder="Password"></input>
                     </div>
+
+                    <div className={'form-group' + (this.state.challenge ? '' : ' hidden')}>
+                        <input
+                            type="text"
+                            className="form-control"
+                            id="code"
+                            autoCapitalize="none"
+                            autoCorrect="off"
+                            autoComplete="one-time-code"
+                            placeholder="Authenticator code or recovery code"></input>
+                    </div>
                     <button type="submit" onClick={this.loginClicked} className="btn btn-primary">Submit</button>
                     </form>
                 </div>
@@ -18,37 +18,22 @@ ReactDOM.render(
 );
 */
 
-// If the user has logged in there is a session cookie, if not we show the login screen
-var cookies = window.document.cookie.split(';');
-var sid = '';
-for (var i=0; i<cookies.length; ++i) {
-    var index = cookies[i].indexOf('=');
-    if (index === -1) {
-        continue;
+// The session cookie can't be read by scripts, so ask the server if the user is logged in, if not
+// we show the login screen
+Api.sessionCheck(function(loggedIn) {
+    if (loggedIn) {
+        ReactDOM.render(
+                <Provider store={store}>
+                <ControlApp />
+                </Provider>,
+            document.getElementsByClassName('content')[0]
+        );
+    } else {
+        ReactDOM.render(
+                <Provider store={store}>
+                <Login />
+                </Provider>,
+            document.getElementsByClassName('content')[0]
+        );
     }
-
-    var name = cookies[i].substr(0, index);
-    if (name === 'sid') {
-        sid = cookies[i].substr(index+1);
-        break;
-    }
-}
-
-if (sid !== '') {
-    // Need to set the SID so that we can call our APIs
-    Api.setSID(sid);
-
-    ReactDOM.render(
-            <Provider store={store}>
-            <ControlApp />
-            </Provider>,
-        document.getElementsByClassName('content')[0]
-    );
-} else {
-    ReactDOM.render(
-            <Provider store={store}>
-            <Login />
-            </Provider>,
-        document.getElementsByClassName('content')[0]
-    );
-}
+});
@@ -6,25 +6,27 @@ var BASE = '//' + window.location.host;
 var API = {
     //TODO: Use a client side router and middleware
 
-    // setSID sets the session ID, this is needed to call an API
+    // setSID sets the session ID used to call the APIs, if it is not set the session cookie is used
     setSID: function(sid) {
         this.SID = sid;
     },
 
     checkErr: function(xhr) {
         if (xhr.status === 401) {
-            // unauthorized, in this case the user has an invalid sid cookie, delete
-            // it and reload the app which will take the user back to the login page
-            document.cookie = 'sid=; expires=Thu, 01 Jan 1970 00:00:01 GMT;';
-            window.location = '/';
+            // unauthorized, in this case the user has an invalid sid cookie, log out to
+            // delete it, which takes the user back to the login page
+            window.location = '/logout';
             return true;
         }
 
         return false;
     },
 
-    // url builds a valid url to call an API
+    // url builds a valid url to call an API, if the SID is not set the session cookie is used
     url: function(url) {
+        if (!this.SID) {
+            return BASE + url;
+        }
         return BASE + url + '?sid=' + this.SID;
     },
 
@@ -487,7 +489,34 @@ var API = {
         });
     },
 
+    // sessionCreate logs the user in. If the user has two factor authentication enabled the
+    // response contains totpRequired and a challenge, call sessionCreateWithCode to finish
     sessionCreate: function(login, password, callback) {
+        this.sessionCreateWithBody(login, { password: password }, callback);
+    },
+
+    // sessionCreateWithCode finishes logging in a user with two factor authentication, code is
+    // from their authenticator app or one of their recovery codes
+    sessionCreateWithCode: function(login, challenge, code, callback) {
+        this.sessionCreateWithBody(login, { challenge: challenge, code: code }, callback);
+    },
+
+    // sessionCheck calls back with true if the user is logged in
+    sessionCheck: function(callback) {
+        $.ajax({
+            url: this.url('/api/v1/sessions'),
+            dataType: 'json',
+            cache: false,
+            success: function(data) {
+                callback(true);
+            },
+            error: function(xhr, status, err) {
+                callback(false);
+            }
+        });
+    },
+
+    sessionCreateWithBody: function(login, body, callback) {
         // NOTE: This api lives on the WWW server, so we get a session cookie set on the
         // WWW domain, vs. this being on the API domain
         $.ajax({
@@ -495,7 +524,7 @@ var API = {
             type: 'POST',
             dataType: 'json',
             contentType: 'application/json; charset=utf-8',
-            data: JSON.stringify({ password: password }),
+            data: JSON.stringify(body),
             success: function(data) {
                 callback(null, data);
             },
@@ -0,0 +1,74 @@
+package www
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+)
+
+// defaultAuditLimit is the number of audit log entries returned if the caller does not specify a limit
+const defaultAuditLimit = 100
+
+// RegisterAuditHandlers registers the REST API routes for the audit log, the routes are only
+// available if the audit log is enabled
+func RegisterAuditHandlers(r *mux.Router, s *Server) {
+	if s.system.Services.Audit == nil {
+		return
+	}
+
+	r.HandleFunc("/v1/audit",
+		apiAuditHandler(s.system.Services.Audit)).Methods("GET")
+}
+
+// apiAuditHandler returns the entries in the audit log, newest first. The entries can be filtered
+// with user=<id or login>, feature=<feature ID>, from and to, which are RFC3339 times or unix seconds.
+// Pass limit=N to return at most N entries, defaults to 100
+func apiAuditHandler(audit *gohome.AuditLog) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		q := gohome.AuditQuery{
+			User:      query.Get("user"),
+			FeatureID: query.Get("feature"),
+			Limit:     defaultAuditLimit,
+		}
+
+		if val := query.Get("from"); val != "" {
+			t, err := parseHistoryTime(val)
+			if err != nil {
+				respBadRequest("invalid from value, must be RFC3339 or unix seconds", w)
+				return
+			}
+			q.From = t
+		}
+		if val := query.Get("to"); val != "" {
+			t, err := parseHistoryTime(val)
+			if err != nil {
+				respBadRequest("invalid to value, must be RFC3339 or unix seconds", w)
+				return
+			}
+			q.To = t
+		}
+		if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+			respBadRequest("from must be before to", w)
+			return
+		}
+		if val := query.Get("limit"); val != "" {
+			limit, err := strconv.Atoi(val)
+			if err != nil || limit < 0 {
+				respBadRequest(fmt.Sprintf("invalid limit: %s", val), w)
+				return
+			}
+			q.Limit = limit
+		}
+
+		entries, err := audit.Query(q)
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+		resp(apiResponse{Data: entries}, w)
+	}
+}
@@ -0,0 +1,96 @@
+package www
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/store"
+	errExt "github.com/pkg/errors"
+)
+
+// RegisterBackupHandlers registers the REST API routes to backup and restore the system
+func RegisterBackupHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/backup",
+		apiBackupHandler(s.cfg)).Methods("GET")
+	r.HandleFunc("/v1/restore",
+		apiRestoreHandler(s.cfg, s.store, s.shutdown)).Methods("POST")
+}
+
+// apiBackupHandler returns a backup of the system as a .tar.gz file. Pass events=true and
+// history=true to include the event log and attribute history
+func apiBackupHandler(cfg *gohome.Config) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := store.BackupOptions{
+			IncludeEvents:  r.URL.Query().Get("events") == "true",
+			IncludeHistory: r.URL.Query().Get("history") == "true",
+		}
+
+		// Build the whole backup before sending anything, so a failure can still return an error
+		var buf bytes.Buffer
+		_, err := store.CreateBackup(&buf, cfg, opts)
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		filename := fmt.Sprintf("gohome-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Write(buf.Bytes())
+	}
+}
+
+// apiRestoreHandler restores a backup created by apiBackupHandler, the request body is the
+// .tar.gz file. The backup is verified before anything is overwritten. The store is made read only
+// before the files are restored, so the running system can't overwrite them, then the server is
+// shut down and must be restarted, e.g. by systemd, to load the restored system. If only some of
+// the files could be restored the store stays read only
+func apiRestoreHandler(cfg *gohome.Config, sysStore store.Store, shutdown func()) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		backup, err := store.ReadBackup(io.LimitReader(r.Body, store.MaxBackupSize))
+		if err != nil {
+			respBadRequest(fmt.Sprintf("invalid backup: %s", err), w)
+			return
+		}
+
+		// Waits for any saves in progress to finish, after this nothing can change the system file
+		if !store.SetReadOnly(sysStore, true) {
+			respErr(errors.New("the store can't be made read only, restore the backup with ghadmin"), w)
+			return
+		}
+
+		err = backup.Restore(cfg)
+		if errExt.Cause(err) == store.ErrPartialRestore {
+			// Some files have been replaced, saving stays disabled so the running system can't
+			// write a system file the restored key doesn't match
+			log.E("backup partially restored, saving is disabled until the server restarts: %s", err)
+			respErr(errExt.Wrap(err, "restore the backup again, changes can't be saved until the server is restarted"), w)
+			return
+		} else if err != nil {
+			// Nothing has been replaced, so saving can continue
+			store.SetReadOnly(sysStore, false)
+			respErr(err, w)
+			return
+		}
+
+		resp(apiResponse{Data: jsonRestore{
+			Created:       backup.Manifest.Created,
+			SystemVersion: backup.Manifest.SystemVersion,
+			Files:         len(backup.Manifest.Files),
+			Restarting:    true,
+		}}, w)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		log.V("backup restored, shutting down so the restored system is loaded on restart")
+		shutdown()
+	}
+}
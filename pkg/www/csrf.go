@@ -0,0 +1,56 @@
+package www
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// csrfHeader is the header that state changing requests must contain. Browsers don't let a web
+// page add custom headers to a cross origin request unless the server allows it, so the header
+// proves the request came from the web UI or an allowed origin. jQuery adds it to same origin requests
+const csrfHeader = "X-Requested-With"
+
+// originAllowed returns true if the Origin header of the request is the same origin as the server,
+// or is one of allowedOrigins. Requests without an Origin header didn't come from a browser making a
+// cross origin request, so they are allowed. allowedOrigins can contain "*" to allow all origins
+func originAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCSRF protects logged in users from other web pages making requests on their behalf. State
+// changing requests must come from the same origin or one of allowedOrigins, and must contain the
+// X-Requested-With header. Requests using an API token don't need the header, since browsers never
+// send the token automatically
+func CheckCSRF(allowedOrigins []string) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next(rw, r)
+			return
+		}
+
+		if !originAllowed(r, allowedOrigins) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Authorization") == "" && r.Header.Get(csrfHeader) == "" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(rw, r)
+	}
+}
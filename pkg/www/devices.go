@@ -26,17 +26,17 @@ func RegisterDeviceHandlers(r *mux.Router, s *Server) {
 	r.HandleFunc("/v1/devices",
 		apiDevicesHandler(s.system)).Methods("GET")
 	r.HandleFunc("/v1/devices",
-		apiAddDeviceHandler(s.systemSavePath, s.system)).Methods("POST")
+		apiAddDeviceHandler(s.store, s.system)).Methods("POST")
 	r.HandleFunc("/v1/devices/{id}/features",
-		apiDeviceAddFeatureHandler(s.systemSavePath, s.system)).Methods("POST")
+		apiDeviceAddFeatureHandler(s.store, s.system)).Methods("POST")
 	r.HandleFunc("/v1/devices/{id}",
-		apiDeviceHandlerDelete(s.systemSavePath, s.system)).Methods("DELETE")
+		apiDeviceHandlerDelete(s.store, s.system)).Methods("DELETE")
 	r.HandleFunc("/v1/devices/{id}",
-		apiDeviceHandlerUpdate(s.systemSavePath, s.system)).Methods("PUT")
+		apiDeviceHandlerUpdate(s.store, s.system)).Methods("PUT")
 	r.HandleFunc("/v1/devices/{id}/features/{fid}",
-		apiDeviceUpdateFeatureHandler(s.systemSavePath, s.system)).Methods("PUT")
+		apiDeviceUpdateFeatureHandler(s.store, s.system)).Methods("PUT")
 	r.HandleFunc("/v1/devices/{id}/features/{fid}/apply",
-		apiDeviceApplyFeaturesAttrsHandler(s.systemSavePath, s.system)).Methods("PUT")
+		apiDeviceApplyFeaturesAttrsHandler(s.store, s.system)).Methods("PUT")
 }
 
 func DevicesToJSON(devs map[string]*gohome.Device) []jsonDevice {
@@ -55,9 +55,9 @@ func DevicesToJSON(devs map[string]*gohome.Device) []jsonDevice {
 		var authJSON *jsonAuth
 		if device.Auth != nil {
 			authJSON = &jsonAuth{
-				Login:    device.Auth.Login,
-				Password: device.Auth.Password,
-				Token:    device.Auth.Token,
+				Login:       device.Auth.Login,
+				HasPassword: device.Auth.Password != "",
+				HasToken:    device.Auth.Token != "",
 			}
 		}
 
@@ -90,13 +90,32 @@ func apiDevicesHandler(system *gohome.System) func(http.ResponseWriter, *http.Re
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-		if err := json.NewEncoder(w).Encode(DevicesToJSON(system.Devices())); err != nil {
+		// Temperatures are returned in the unit the user has chosen in their preferences
+		user := requestAccess(r)
+		unit := userTempUnit(system, user)
+		isAdmin := user != nil && user.HasRole(gohome.RoleAdmin)
+		devices := make([]jsonDevice, 0)
+		for _, device := range DevicesToJSON(system.Devices()) {
+			// Only admins can see device settings and devices with no features the user can access
+			if !isAdmin {
+				device.Features = accessibleFeatures(user, system, device.Features)
+				if len(device.Features) == 0 {
+					continue
+				}
+				device.Auth = nil
+				device.ConnPool = nil
+			}
+			device.Features = featuresToTempUnit(device.Features, unit)
+			devices = append(devices, device)
+		}
+
+		if err := json.NewEncoder(w).Encode(devices); err != nil {
 			respErr(err, w)
 		}
 	}
 }
 
-func apiDeviceHandlerDelete(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiDeviceHandlerDelete(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -107,7 +126,15 @@ func apiDeviceHandlerDelete(savePath string, system *gohome.System) func(http.Re
 			return
 		}
 		system.DeleteDevice(device)
-		err := store.SaveSystem(savePath, system)
+		changeStore := store.WithChange(sysStore, requestChange(r))
+		err := changeStore.DeleteDevice(system, device)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save changes to disk"), w)
+			return
+		}
+
+		// The device's features were removed from their areas
+		err = changeStore.SaveAreas(system)
 		if err != nil {
 			respErr(errExt.Wrap(err, "failed to save changes to disk"), w)
 			return
@@ -118,7 +145,7 @@ func apiDeviceHandlerDelete(savePath string, system *gohome.System) func(http.Re
 	}
 }
 
-func apiDeviceApplyFeaturesAttrsHandler(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiDeviceApplyFeaturesAttrsHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -154,26 +181,25 @@ func apiDeviceApplyFeaturesAttrsHandler(savePath string, system *gohome.System)
 			return
 		}
 
+		// Temperatures without a unit are in the units the user has chosen to view them in
+		setDefaultTempUnit(data, f, userTempUnit(system, requestUser(r)))
+
 		// Verify that each attribute passed in is valid. The API only cares that you pass in
 		// localID and value, the other fields for the attribute are pulled from the feature
-		finalAttrs := make(map[string]*attr.Attribute)
-		for localID, attribute := range data {
-			blankAttr, ok := f.Attrs[localID]
-			if !ok {
-				respBadRequest(fmt.Sprintf("invalid localID: %s", localID), w)
-				return
-			}
-
-			finalAttrs[localID] = blankAttr.Clone()
-			finalAttrs[localID].Value = attribute.Value
-		}
-
-		desc := "FeatureSetAttrs"
-		err = system.Services.CmdProcessor.Enqueue(gohome.NewCommandGroup(desc, &cmd.FeatureSetAttrs{
+		command := &cmd.FeatureSetAttrs{
 			FeatureID:   featureID,
 			FeatureName: f.Name,
-			Attrs:       finalAttrs,
-		}))
+			FeatureType: f.Type,
+			Attrs:       data,
+		}
+		if valErrs := command.Validate(f, false); valErrs != nil {
+			respValErr(&data, featureID, valErrs, w)
+			return
+		}
+
+		cg := gohome.NewCommandGroup("FeatureSetAttrs", command)
+		cg.Origin = requestOrigin(r)
+		err = system.Services.CmdProcessor.Enqueue(cg)
 
 		if err != nil {
 			respErr(errExt.Wrap(err, "failed to enqueue FeatureSetAttrs command"), w)
@@ -184,7 +210,7 @@ func apiDeviceApplyFeaturesAttrsHandler(savePath string, system *gohome.System)
 	}
 }
 
-func apiDeviceUpdateFeatureHandler(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiDeviceUpdateFeatureHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -222,6 +248,21 @@ func apiDeviceUpdateFeatureHandler(savePath string, system *gohome.System) func(
 			Address:      data.Address,
 			Description:  data.Description,
 			DeviceID:     data.DeviceID,
+			Attrs:        f.Attrs,
+		}
+
+		// If the type is changing, the feature gets new attributes
+		var newFeature *feature.Feature
+		if data.Type != f.Type {
+			newFeature = feature.NewFromType(data.ID, data.Type)
+			if newFeature == nil {
+				valErrs := &validation.Errors{}
+				valErrs.Add("unsupported. Can't change to target type", "Type")
+				respValErr(&data, data.ID, valErrs, w)
+				return
+			}
+			addSensorAttrs(newFeature, data.Attrs)
+			updatedFeature.Attrs = newFeature.Attrs
 		}
 
 		valErrs := updatedFeature.Validate()
@@ -230,20 +271,12 @@ func apiDeviceUpdateFeatureHandler(savePath string, system *gohome.System) func(
 			return
 		}
 
-		if data.Type != f.Type {
+		if newFeature != nil {
 			//TODO: Need to update commands since they store the type and attrs for the feature which are now all invalid
 			fmt.Println("TODO: If you change the type of a feature, then need to update all the commands")
 
 			// Changing the type of the feature, we need to update the attributes
 			// associated with the feature
-			newFeature := feature.NewFromType(data.ID, data.Type)
-			if newFeature == nil {
-				valErrs = &validation.Errors{}
-				valErrs.Add("unsupported. Can't change to target type", "Type")
-				respValErr(&data, data.ID, valErrs, w)
-				return
-			}
-
 			f.Attrs = newFeature.Attrs
 		}
 
@@ -254,7 +287,7 @@ func apiDeviceUpdateFeatureHandler(savePath string, system *gohome.System) func(
 		f.Address = data.Address
 		f.Description = data.Description
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveDevice(system, dev)
 		if err != nil {
 			respErr(errExt.Wrap(err, "error writing changes to disk"), w)
 			return
@@ -266,7 +299,7 @@ func apiDeviceUpdateFeatureHandler(savePath string, system *gohome.System) func(
 	}
 }
 
-func apiDeviceAddFeatureHandler(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiDeviceAddFeatureHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -290,6 +323,13 @@ func apiDeviceAddFeatureHandler(savePath string, system *gohome.System) func(htt
 		}
 
 		newFeature := feature.NewFromType(data.ID, data.Type)
+		if newFeature == nil {
+			valErrs := &validation.Errors{}
+			valErrs.Add("unsupported feature type", "Type")
+			respValErr(&data, data.ID, valErrs, w)
+			return
+		}
+		addSensorAttrs(newFeature, data.Attrs)
 		newFeature.AutomationID = data.AutomationID
 		newFeature.Name = data.Name
 		newFeature.Address = data.Address
@@ -305,7 +345,7 @@ func apiDeviceAddFeatureHandler(savePath string, system *gohome.System) func(htt
 		dev.AddFeature(newFeature)
 		system.AddFeature(newFeature)
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveDevice(system, dev)
 		if err != nil {
 			respErr(errExt.Wrap(err, "error writing changes to disk"), w)
 			return
@@ -318,7 +358,7 @@ func apiDeviceAddFeatureHandler(savePath string, system *gohome.System) func(htt
 	}
 }
 
-func apiAddDeviceHandler(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiAddDeviceHandler(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -434,18 +474,27 @@ func apiAddDeviceHandler(savePath string, system *gohome.System) func(http.Respo
 			log.E("Failed to init device on add: %s", err)
 		}
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveDevice(system, d)
 		if err != nil {
 			respErr(errExt.Wrap(err, "error writing changes to disk"), w)
 			return
 		}
 
+		// Credentials are write only, don't echo them back
+		if data.Auth != nil {
+			data.Auth = &jsonAuth{
+				Login:       data.Auth.Login,
+				HasPassword: data.Auth.Password != "",
+				HasToken:    data.Auth.Token != "",
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		json.NewEncoder(w).Encode(data)
 	}
 }
 
-func apiDeviceHandlerUpdate(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiDeviceHandlerUpdate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
@@ -495,7 +544,24 @@ func apiDeviceHandlerUpdate(savePath string, system *gohome.System) func(http.Re
 		d.Address = data.Address
 		d.Type = gohome.DeviceType(data.Type)
 
-		err = store.SaveSystem(savePath, system)
+		// Credentials are write only, the client never sees the current password or token so
+		// empty values mean keep the existing values
+		if data.Auth != nil {
+			auth := &gohome.Auth{}
+			if d.Auth != nil {
+				*auth = *d.Auth
+			}
+			auth.Login = data.Auth.Login
+			if data.Auth.Password != "" {
+				auth.Password = data.Auth.Password
+			}
+			if data.Auth.Token != "" {
+				auth.Token = data.Auth.Token
+			}
+			d.Auth = auth
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveDevice(system, d)
 		if err != nil {
 			respErr(errExt.Wrap(err, "failed to save new settings to disk"), w)
 			return
@@ -515,3 +581,24 @@ func apiDeviceHandlerUpdate(savePath string, system *gohome.System) func(http.Re
 		json.NewEncoder(w).Encode(jsonDevices[0])
 	}
 }
+
+// addSensorAttrs adds the standard sensor attributes requested by the caller to the feature, if
+// the feature is a sensor. Only the localID, type and name of the requested attributes are used
+func addSensorAttrs(f *feature.Feature, requested feature.Attrs) {
+	if f.Type != feature.FTSensor {
+		return
+	}
+
+	for localID, attribute := range requested {
+		if attribute == nil {
+			continue
+		}
+
+		sensorAttr := attr.NewSensorAttr(localID, attribute.Type)
+		if sensorAttr == nil {
+			continue
+		}
+		sensorAttr.Name = attribute.Name
+		f.Attrs[localID] = sensorAttr
+	}
+}
@@ -0,0 +1,123 @@
+package www
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/gohome"
+)
+
+// defaultHistoryRange is the range of history returned if the caller does not specify a from value
+const defaultHistoryRange = time.Hour * 24
+
+// RegisterFeatureHandlers registers all of the feature specific API REST routes
+func RegisterFeatureHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/features/{id}/history",
+		apiFeatureHistoryHandler(s.system)).Methods("GET")
+}
+
+func apiFeatureHistoryHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := system.FeatureByID(mux.Vars(r)["id"])
+		if f == nil {
+			respBadRequest("invalid feature ID", w)
+			return
+		}
+
+		query := r.URL.Query()
+		localID := query.Get("attr")
+		attribute, ok := f.Attrs[localID]
+		if !ok {
+			respBadRequest(fmt.Sprintf("invalid attr: %s", localID), w)
+			return
+		}
+
+		to := time.Now()
+		if val := query.Get("to"); val != "" {
+			t, err := parseHistoryTime(val)
+			if err != nil {
+				respBadRequest("invalid to value, must be RFC3339 or unix seconds", w)
+				return
+			}
+			to = t
+		}
+
+		from := to.Add(-defaultHistoryRange)
+		if val := query.Get("from"); val != "" {
+			t, err := parseHistoryTime(val)
+			if err != nil {
+				respBadRequest("invalid from value, must be RFC3339 or unix seconds", w)
+				return
+			}
+			from = t
+		}
+		if to.Before(from) {
+			respBadRequest("from must be before to", w)
+			return
+		}
+
+		var step time.Duration
+		if val := query.Get("step"); val != "" {
+			d, err := parseHistoryStep(val)
+			if err != nil || d <= 0 {
+				respBadRequest("invalid step value, must be a duration e.g. 5m, 1h or seconds", w)
+				return
+			}
+			step = d
+		}
+
+		if system.Services.History == nil {
+			respErr(fmt.Errorf("attribute history is not enabled"), w)
+			return
+		}
+
+		points, err := system.Services.History.Query(f.ID, localID, from, to, step)
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		// Return temperatures in the unit the user has chosen to see them in
+		unit := attribute.Unit
+		if userUnit := userTempUnit(system, requestUser(r)); userUnit != "" && attr.IsTempUnit(unit) && userUnit != unit {
+			for i := range points {
+				points[i].Value, _ = attr.ConvertTemp(points[i].Value, unit, userUnit)
+				points[i].Min, _ = attr.ConvertTemp(points[i].Min, unit, userUnit)
+				points[i].Max, _ = attr.ConvertTemp(points[i].Max, unit, userUnit)
+			}
+			unit = userUnit
+		}
+
+		resp(apiResponse{
+			Data: jsonFeatureHistory{
+				FeatureID: f.ID,
+				LocalID:   localID,
+				Unit:      unit,
+				From:      from.UTC(),
+				To:        to.UTC(),
+				Step:      int64(step / time.Second),
+				Points:    points,
+			},
+		}, w)
+	}
+}
+
+// parseHistoryTime parses a time that is either in RFC3339 format or unix seconds
+func parseHistoryTime(val string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// parseHistoryStep parses a step that is either a duration e.g. 5m or a number of seconds
+func parseHistoryStep(val string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(val)
+}
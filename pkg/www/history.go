@@ -0,0 +1,87 @@
+package www
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/store"
+)
+
+// RegisterHistoryHandlers registers the REST API routes for the configuration history, the routes
+// are only available if the configuration history is enabled
+func RegisterHistoryHandlers(r *mux.Router, s *Server) {
+	historyStore, ok := s.store.(*store.HistoryStore)
+	if !ok {
+		return
+	}
+
+	r.HandleFunc("/v1/history",
+		apiHistoryHandler(historyStore)).Methods("GET")
+	r.HandleFunc("/v1/history/{rev}/rollback",
+		apiHistoryRollbackHandler(historyStore, s.system)).Methods("POST")
+}
+
+// apiHistoryHandler returns the changes made to the system configuration, newest first. Pass
+// limit=N to only return the N most recent changes
+func apiHistoryHandler(historyStore *store.HistoryStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if val := r.URL.Query().Get("limit"); val != "" {
+			var err error
+			limit, err = strconv.Atoi(val)
+			if err != nil || limit < 0 {
+				respBadRequest(fmt.Sprintf("invalid limit: %s", val), w)
+				return
+			}
+		}
+
+		revs, err := historyStore.History.Revisions(limit)
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		out := make([]jsonRevision, 0, len(revs))
+		for _, rev := range revs {
+			changes, err := historyStore.History.Diff(rev.Rev)
+			if err != nil {
+				respErr(err, w)
+				return
+			}
+			out = append(out, jsonRevision{Revision: rev, Changes: changes})
+		}
+		resp(apiResponse{Data: out}, w)
+	}
+}
+
+// apiHistoryRollbackHandler changes the system configuration back to how it was in the revision,
+// devices that changed since the revision are reinitialized
+func apiHistoryRollbackHandler(historyStore *store.HistoryStore, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+		if err != nil {
+			respBadRequest(fmt.Sprintf("invalid revision: %s", mux.Vars(r)["rev"]), w)
+			return
+		}
+
+		recorder := store.WithChange(historyStore, requestChange(r)).(*store.HistoryStore)
+		newRev, err := recorder.Rollback(system, rev)
+		if err == store.ErrRevisionNotFound {
+			respBadRequest(fmt.Sprintf("invalid revision: %d", rev), w)
+			return
+		} else if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		changes, err := historyStore.History.Diff(newRev.Rev)
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+		resp(apiResponse{Data: jsonRevision{Revision: *newRev, Changes: changes}}, w)
+	}
+}
@@ -2,9 +2,12 @@ package www
 
 import (
 	"strings"
+	"time"
 
 	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/store"
 )
 
 type jsonAutomation struct {
@@ -23,10 +26,14 @@ type jsonConnPool struct {
 	PoolSize int32  `json:"poolSize"`
 }
 
+// jsonAuth contains device credentials. The password and token are write only, they are never
+// returned to the client, HasPassword and HasToken indicate if they have been set
 type jsonAuth struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	Token    string `json:"token"`
+	Login       string `json:"login"`
+	Password    string `json:"password,omitempty"`
+	Token       string `json:"token,omitempty"`
+	HasPassword bool   `json:"hasPassword"`
+	HasToken    bool   `json:"hasToken"`
 }
 
 type jsonDevice struct {
@@ -120,3 +127,92 @@ func (slice scenes) Less(i, j int) bool {
 func (slice scenes) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
+
+type jsonFeatureHistory struct {
+	FeatureID string                `json:"featureId"`
+	LocalID   string                `json:"attr"`
+	Unit      string                `json:"unit"`
+	From      time.Time             `json:"from"`
+	To        time.Time             `json:"to"`
+	Step      int64                 `json:"step"`
+	Points    []gohome.HistoryPoint `json:"points"`
+}
+
+type jsonArea struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	ParentID    string   `json:"parentId"`
+	AreaIDs     []string `json:"areaIds"`
+	FeatureIDs  []string `json:"featureIds"`
+}
+type jsonAreas []jsonArea
+
+func (slice jsonAreas) Len() int {
+	return len(slice)
+}
+func (slice jsonAreas) Less(i, j int) bool {
+	return strings.ToLower(slice[i].Name) < strings.ToLower(slice[j].Name)
+}
+func (slice jsonAreas) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+type jsonUserPrefs struct {
+	TempUnit       string   `json:"tempUnit"`
+	HiddenFeatures []string `json:"hiddenFeatures"`
+	FeatureOrder   []string `json:"featureOrder"`
+	FavoriteScenes []string `json:"favoriteScenes"`
+	LandingAreaID  string   `json:"landingAreaId"`
+}
+
+type jsonRestore struct {
+	Created       time.Time `json:"created"`
+	SystemVersion string    `json:"systemVersion"`
+	Files         int       `json:"files"`
+	Restarting    bool      `json:"restarting"`
+}
+
+type jsonRevision struct {
+	store.Revision
+	Changes []store.DiffEntry `json:"changes"`
+}
+
+type jsonSession struct {
+	ID         string    `json:"id"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"`
+	UserAgent  string    `json:"userAgent"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Current    bool      `json:"current"`
+}
+
+type jsonToken struct {
+	gohome.Token
+	Current bool `json:"current"`
+}
+
+type jsonGrant struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type jsonUser struct {
+	ID          string      `json:"id"`
+	Login       string      `json:"login"`
+	Role        string      `json:"role"`
+	Grants      []jsonGrant `json:"grants"`
+	TOTPEnabled bool        `json:"totpEnabled"`
+}
+
+type users []jsonUser
+
+func (slice users) Len() int {
+	return len(slice)
+}
+func (slice users) Less(i, j int) bool {
+	return slice[i].Login < slice[j].Login
+}
+func (slice users) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
@@ -13,9 +13,9 @@ import (
 
 // RegisterMonitorHandlers registers all of the monitor specific REST API routes
 func RegisterMonitorHandlers(r *mux.Router, s *Server) {
-	//TODO: Need a way to check the SID used for the user against the current valid
-	//SIDs and make sure it has not expired, otherwise someone can listen forever
-	wsHelper := NewWSHelper(s.system.Services.Monitor, s.system.Services.EvtBus)
+	// Monitor groups can only be used by the session or API token that created them, web socket
+	// connections are closed when the session or token is no longer valid
+	wsHelper := NewWSHelper(s.system, s.system.Services.Monitor, s.system.Services.EvtBus, s.sessions, s.tokens, s.cfg.WWWAllowedOrigins)
 
 	// Clients call to subscribe to items, api returns a monitorID that can then be used
 	// to subscribe and unsubscribe to notifications
@@ -35,7 +35,7 @@ func RegisterMonitorHandlers(r *mux.Router, s *Server) {
 func apiUnsubscribeHandler(system *gohome.System, wsHelper *WSHelper) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		monitorID := mux.Vars(r)["monitorID"]
-		if _, ok := system.Services.Monitor.Group(monitorID); !ok {
+		if !ownsMonitorGroup(system, monitorID, r) {
 			respBadRequest("monitorID is invalid", w)
 			return
 		}
@@ -48,7 +48,7 @@ func apiUnsubscribeHandler(system *gohome.System, wsHelper *WSHelper) func(http.
 func apiRefreshSubscribeHandler(system *gohome.System, wsHelper *WSHelper) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		monitorID := mux.Vars(r)["monitorID"]
-		if _, ok := system.Services.Monitor.Group(monitorID); !ok {
+		if !ownsMonitorGroup(system, monitorID, r) {
 			respBadRequest("monitorID is invalid", w)
 			return
 		}
@@ -75,8 +75,19 @@ func apiSubscribeHandler(system *gohome.System, wsHelper *WSHelper) func(http.Re
 			Timeout:  time.Duration(groupJSON.TimeoutInSeconds) * time.Second,
 			Features: make(map[string]bool),
 			Handler:  wsHelper,
+			Owner:    requestOwner(r),
 		}
+		// Users can only monitor the features they can access, unknown features are ignored
+		user := requestAccess(r)
 		for _, featureID := range groupJSON.FeatureIDs {
+			f := system.FeatureByID(featureID)
+			if f == nil {
+				continue
+			}
+			if user == nil || !user.CanAccessFeature(system, f) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
 			group.Features[featureID] = true
 		}
 
@@ -93,3 +104,10 @@ func apiSubscribeHandler(system *gohome.System, wsHelper *WSHelper) func(http.Re
 		}, w)
 	}
 }
+
+// ownsMonitorGroup returns true if the monitor group exists and was created with the session or
+// API token used to make the request
+func ownsMonitorGroup(system *gohome.System, monitorID string, r *http.Request) bool {
+	group, ok := system.Services.Monitor.Group(monitorID)
+	return ok && group.Owner != "" && group.Owner == requestOwner(r)
+}
@@ -0,0 +1,169 @@
+package www
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+)
+
+// routeAccess is the access a user needs to call an API route
+type routeAccess struct {
+	// Role is the minimum role the user must have
+	Role string
+
+	// FeatureVar is the name of the route variable containing the ID of a feature the user must
+	// be able to access
+	FeatureVar string
+
+	// SceneInBody is true if the request body contains the ID of a scene the user must be able
+	// to access, in the id field
+	SceneInBody bool
+}
+
+// routeAccesses is the access needed for each API route, keyed by method and path template. Routes
+// that are not listed can only be used by admins, so new routes are safe by default. Routes that
+// return lists of features or scenes only return the ones the user can access
+var routeAccesses = map[string]routeAccess{
+	"GET /api/v1/areas":                             {Role: gohome.RoleGuest},
+	"GET /api/v1/areas/{id}":                        {Role: gohome.RoleGuest},
+	"GET /api/v1/areas/{id}/features":               {Role: gohome.RoleGuest},
+	"GET /api/v1/devices":                           {Role: gohome.RoleGuest},
+	"PUT /api/v1/devices/{id}/features/{fid}/apply": {Role: gohome.RoleGuest, FeatureVar: "fid"},
+	"GET /api/v1/features/{id}/history":             {Role: gohome.RoleGuest, FeatureVar: "id"},
+	"GET /api/v1/scenes":                            {Role: gohome.RoleGuest},
+	"POST /api/v1/scenes/active":                    {Role: gohome.RoleGuest, SceneInBody: true},
+	"POST /api/v1/monitor/groups":                   {Role: gohome.RoleGuest},
+	"PUT /api/v1/monitor/groups/{monitorID}":        {Role: gohome.RoleGuest},
+	"DELETE /api/v1/monitor/groups/{monitorID}":     {Role: gohome.RoleGuest},
+	"GET /api/v1/monitor/groups/{monitorID}":        {Role: gohome.RoleGuest},
+	"GET /api/v1/users/me/prefs":                    {Role: gohome.RoleGuest},
+	"PUT /api/v1/users/me/prefs":                    {Role: gohome.RoleGuest},
+	"PUT /api/v1/users/me/password":                 {Role: gohome.RoleGuest},
+	"GET /api/v1/sessions":                          {Role: gohome.RoleGuest},
+	"DELETE /api/v1/sessions/{id}":                  {Role: gohome.RoleGuest},
+	"GET /api/v1/tokens":                            {Role: gohome.RoleGuest},
+	"POST /api/v1/tokens":                           {Role: gohome.RoleGuest},
+	"DELETE /api/v1/tokens/{id}":                    {Role: gohome.RoleGuest},
+	"GET /api/v1/users/me/totp":                     {Role: gohome.RoleGuest},
+	"POST /api/v1/users/me/totp":                    {Role: gohome.RoleGuest},
+	"POST /api/v1/users/me/totp/verify":             {Role: gohome.RoleGuest},
+	"POST /api/v1/users/me/totp/recovery-codes":     {Role: gohome.RoleGuest},
+	"DELETE /api/v1/users/me/totp":                  {Role: gohome.RoleGuest},
+	"GET /api/v1/automations":                       {Role: gohome.RoleMember},
+}
+
+// CheckPermissions verifies the user making the request is allowed to call the API route, it
+// must come after CheckValidSession so the user is known. router is the router the API routes
+// are registered with
+func CheckPermissions(router *mux.Router, system *gohome.System) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			// Let the router respond to requests that don't match a route
+			next(rw, r)
+			return
+		}
+
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		access, ok := routeAccesses[r.Method+" "+template]
+		if !ok {
+			access = routeAccess{Role: gohome.RoleAdmin}
+		}
+
+		user := requestAccess(r)
+		if user == nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !user.HasRole(access.Role) {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// Unknown IDs are left for the handler to report
+		if access.FeatureVar != "" {
+			f := system.FeatureByID(match.Vars[access.FeatureVar])
+			if f != nil && !user.CanAccessFeature(system, f) {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		if access.SceneInBody {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+			if err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var x struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(body, &x)
+			scene := system.SceneByID(x.ID)
+			if scene != nil && !user.CanAccessScene(scene) {
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		next(rw, r)
+	}
+}
+
+// accessibleFeatures returns the features the user can access
+func accessibleFeatures(user *gohome.User, system *gohome.System, features []*feature.Feature) []*feature.Feature {
+	if user != nil && user.HasRole(gohome.RoleAdmin) {
+		return features
+	}
+
+	var out []*feature.Feature
+	for _, f := range features {
+		if user != nil && user.CanAccessFeature(system, f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// accessibleScenes returns the scenes the user can access
+func accessibleScenes(user *gohome.User, scenes map[string]*gohome.Scene) map[string]*gohome.Scene {
+	out := make(map[string]*gohome.Scene)
+	for ID, scene := range scenes {
+		if user != nil && user.CanAccessScene(scene) {
+			out[ID] = scene
+		}
+	}
+	return out
+}
+
+// cookieUser returns the user to check permissions against for the session in the sid cookie, nil if
+// there is no valid session. See requestAccess
+func cookieUser(r *http.Request, sessions *gohome.Sessions, system *gohome.System, requireAdminTOTP bool) *gohome.User {
+	sid, err := r.Cookie("sid")
+	if err != nil {
+		return nil
+	}
+
+	session, ok := sessions.Get(sid.Value)
+	if !ok {
+		return nil
+	}
+	user := system.UserByID(session.UserID)
+	if user == nil {
+		return nil
+	}
+	return system.UserSnapshot(user).TOTPLimited(requireAdminTOTP)
+}
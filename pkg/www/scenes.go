@@ -12,6 +12,7 @@ import (
 	"github.com/markdaws/gohome/pkg/attr"
 	"github.com/markdaws/gohome/pkg/cmd"
 	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
 	"github.com/markdaws/gohome/pkg/store"
 	"github.com/markdaws/gohome/pkg/validation"
 	errExt "github.com/pkg/errors"
@@ -22,19 +23,19 @@ func RegisterSceneHandlers(r *mux.Router, s *Server) {
 	r.HandleFunc("/v1/scenes", apiScenesHandler(s.system)).Methods("GET")
 
 	r.HandleFunc("/v1/scenes/{ID}",
-		apiSceneHandlerUpdate(s.systemSavePath, s.system)).Methods("PUT")
+		apiSceneHandlerUpdate(s.store, s.system)).Methods("PUT")
 
 	r.HandleFunc("/v1/scenes",
-		apiSceneHandlerCreate(s.systemSavePath, s.system)).Methods("POST")
+		apiSceneHandlerCreate(s.store, s.system)).Methods("POST")
 
 	r.HandleFunc("/v1/scenes/{sceneID}/commands/{commandID}",
-		apiSceneHandlerCommandDelete(s.systemSavePath, s.system)).Methods("DELETE")
+		apiSceneHandlerCommandDelete(s.store, s.system)).Methods("DELETE")
 
 	r.HandleFunc("/v1/scenes/{sceneID}/commands",
-		apiSceneHandlerCommandAdd(s.systemSavePath, s.system)).Methods("POST")
+		apiSceneHandlerCommandAdd(s.store, s.system)).Methods("POST")
 
 	r.HandleFunc("/v1/scenes/{ID}",
-		apiSceneHandlerDelete(s.systemSavePath, s.system)).Methods("DELETE")
+		apiSceneHandlerDelete(s.store, s.system)).Methods("DELETE")
 
 	r.HandleFunc("/v1/scenes/active",
 		apiActiveScenesHandler(s.system)).Methods("POST")
@@ -52,32 +53,18 @@ func ScenesToJSON(inputScenes map[string]*gohome.Scene) scenes {
 			Managed:     scene.Managed,
 		}
 
-		cmds := make([]jsonCommand, len(scene.Commands))
-		for j, sCmd := range scene.Commands {
-			switch xCmd := sCmd.(type) {
-			case *cmd.SceneSet:
-				cmds[j] = jsonCommand{
-					ID:   xCmd.ID,
-					Type: "sceneSet",
-					Attributes: map[string]interface{}{
-						"id":      xCmd.ID,
-						"SceneID": xCmd.SceneID,
-					},
-				}
-
-			case *cmd.FeatureSetAttrs:
-				cmds[j] = jsonCommand{
-					ID:   xCmd.ID,
-					Type: "featureSetAttrs",
-					Attributes: map[string]interface{}{
-						"id":    xCmd.FeatureID,
-						"type":  xCmd.FeatureType,
-						"attrs": xCmd.Attrs,
-					},
-				}
-			default:
-				fmt.Println("unknown scene command")
+		cmds := make([]jsonCommand, 0, len(scene.Commands))
+		for _, sCmd := range scene.Commands {
+			cmdType, attrs, err := cmd.Encode(sCmd)
+			if err != nil {
+				log.E("failed to encode command in scene %s: %s", scene.ID, err)
+				continue
 			}
+			cmds = append(cmds, jsonCommand{
+				ID:         sCmd.GetID(),
+				Type:       cmdType,
+				Attributes: attrs,
+			})
 		}
 
 		jsonScenes[i].Commands = cmds
@@ -110,10 +97,12 @@ func apiActiveScenesHandler(system *gohome.System) func(http.ResponseWriter, *ht
 		}
 
 		desc := fmt.Sprintf("Set scene: %s", scene.Name)
-		err = system.Services.CmdProcessor.Enqueue(gohome.NewCommandGroup(desc, &cmd.SceneSet{
+		cg := gohome.NewCommandGroup(desc, &cmd.SceneSet{
 			SceneID:   scene.ID,
 			SceneName: scene.Name,
-		}))
+		})
+		cg.Origin = requestOrigin(r)
+		err = system.Services.CmdProcessor.Enqueue(cg)
 		if err != nil {
 			//TODO: log
 			fmt.Printf("enqueue failed: %s\n", err)
@@ -131,13 +120,25 @@ func apiScenesHandler(system *gohome.System) func(http.ResponseWriter, *http.Req
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 
-		if err := json.NewEncoder(w).Encode(ScenesToJSON(system.Scenes())); err != nil {
+		// Temperatures are returned in the unit the user has chosen in their preferences
+		user := requestAccess(r)
+		unit := userTempUnit(system, user)
+		jsonScenes := ScenesToJSON(accessibleScenes(user, system.Scenes()))
+		for _, scene := range jsonScenes {
+			for _, command := range scene.Commands {
+				if attrs, ok := command.Attributes["attrs"].(map[string]*attr.Attribute); ok {
+					command.Attributes["attrs"] = attrsToTempUnit(attrs, unit)
+				}
+			}
+		}
+
+		if err := json.NewEncoder(w).Encode(jsonScenes); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	}
 }
 
-func apiSceneHandlerDelete(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiSceneHandlerDelete(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		sceneID := mux.Vars(r)["ID"]
@@ -147,7 +148,7 @@ func apiSceneHandlerDelete(savePath string, system *gohome.System) func(http.Res
 			return
 		}
 		system.DeleteScene(scene)
-		err := store.SaveSystem(savePath, system)
+		err := store.WithChange(sysStore, requestChange(r)).DeleteScene(system, scene)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -158,7 +159,7 @@ func apiSceneHandlerDelete(savePath string, system *gohome.System) func(http.Res
 	}
 }
 
-func apiSceneHandlerCommandDelete(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiSceneHandlerCommandDelete(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		sceneID := mux.Vars(r)["sceneID"]
@@ -187,7 +188,7 @@ func apiSceneHandlerCommandDelete(savePath string, system *gohome.System) func(h
 			return
 		}
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveScene(system, scene)
 		if err != nil {
 			respErr(errExt.Wrap(err, "error writing changes to disk"), w)
 			return
@@ -198,7 +199,7 @@ func apiSceneHandlerCommandDelete(savePath string, system *gohome.System) func(h
 	}
 }
 
-func apiSceneHandlerCommandAdd(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiSceneHandlerCommandAdd(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -216,86 +217,40 @@ func apiSceneHandlerCommandAdd(savePath string, system *gohome.System) func(http
 			return
 		}
 
-		var command map[string]*json.RawMessage
+		var command jsonCommand
 		if err = json.Unmarshal(body, &command); err != nil {
 			respBadRequest(errExt.Wrap(err, "unable to parse JSON in request body").Error(), w)
 			return
 		}
 
-		var cmdType string
-		if err = json.Unmarshal(*command["type"], &cmdType); err != nil {
-			respBadRequest("invalid JSON body, missing type field", w)
+		if _, ok := cmd.TypeByName(command.Type); !ok {
+			respBadRequest(fmt.Sprintf("invalid command in type field: %s", command.Type), w)
 			return
 		}
 
-		var finalCmd cmd.Command
-		switch cmdType {
-		case "featureSetAttrs":
-			var cmdAttrs map[string]*json.RawMessage
-			if err = json.Unmarshal(*command["attributes"], &cmdAttrs); err != nil {
-				respBadRequest("invalid JSON body, missing attributes key", w)
-				return
-			}
-
-			var featureID string
-			if err = json.Unmarshal(*cmdAttrs["id"], &featureID); err != nil {
-				respBadRequest("invalid JSON body, missing ID key", w)
-				return
-			}
-
-			f := system.FeatureByID(featureID)
-			if f == nil {
-				respBadRequest(fmt.Sprintf("invalid feature ID: %s", featureID), w)
-				return
-			}
-
-			attrs := make(map[string]*attr.Attribute)
-			if err = json.Unmarshal(*cmdAttrs["attrs"], &attrs); err != nil {
-				respBadRequest("invalid JSON body, missing attrs key", w)
-				return
-			}
-			attr.FixJSON(attrs)
-
-			finalCmd = &cmd.FeatureSetAttrs{
-				ID:          system.NewID(),
-				FeatureID:   featureID,
-				FeatureName: f.Name,
-				FeatureType: f.Type,
-				Attrs:       attrs,
-			}
-
-		case "sceneSet":
-			var sceneCmd jsonCommand
-			if err = json.Unmarshal(body, &sceneCmd); err != nil {
-				respBadRequest("invalid JSON in request body", w)
-				return
-			}
+		finalCmd, err := cmd.Decode(command.Type, system.NewID(), command.Attributes, system)
+		if valErrs, ok := err.(*validation.Errors); ok {
+			respValErr(&command, command.ID, valErrs, w)
+			return
+		} else if err != nil {
+			respBadRequest(err.Error(), w)
+			return
+		}
 
-			if _, ok := sceneCmd.Attributes["SceneID"]; !ok {
-				valErrs := validation.NewErrors("attribute_SceneID", "required field", true)
-				respValErr(&sceneCmd, sceneCmd.ID, valErrs, w)
-				return
-			}
+		// Temperatures without a unit are in the unit the user has chosen in their preferences,
+		// the unit has to be set before the values are validated
+		setAttrsCmd, isSetAttrs := finalCmd.(*cmd.FeatureSetAttrs)
+		if isSetAttrs {
+			f := system.FeatureByID(setAttrsCmd.FeatureID)
+			setDefaultTempUnit(setAttrsCmd.Attrs, f, userTempUnit(system, requestUser(r)))
+		}
 
-			scene := system.SceneByID(sceneCmd.Attributes["SceneID"].(string))
-			if scene == nil {
-				var valErrs *validation.Errors
-				if sceneCmd.Attributes["SceneID"].(string) == "" {
-					valErrs = validation.NewErrors("attributes_SceneID", "required field", true)
-				} else {
-					valErrs = validation.NewErrors("attributes_SceneID", "invalid Scene ID", true)
-				}
-				respValErr(&sceneCmd, sceneCmd.ID, valErrs, w)
-				return
-			}
-			finalCmd = &cmd.SceneSet{
-				ID:        system.NewID(),
-				SceneID:   scene.ID,
-				SceneName: scene.Name,
+		if valErrs := cmd.Validate(finalCmd, system); valErrs != nil {
+			if isSetAttrs {
+				respValErr(&setAttrsCmd.Attrs, setAttrsCmd.FeatureID, valErrs, w)
+			} else {
+				respValErr(&command, command.ID, valErrs, w)
 			}
-
-		default:
-			respBadRequest(fmt.Sprintf("invalid command in type field: %s", cmdType), w)
 			return
 		}
 
@@ -306,7 +261,7 @@ func apiSceneHandlerCommandAdd(savePath string, system *gohome.System) func(http
 			return
 		}
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveScene(system, scene)
 		if err != nil {
 			respErr(errExt.Wrap(err, "error writing changes to disk"), w)
 			return
@@ -323,7 +278,7 @@ func apiSceneHandlerCommandAdd(savePath string, system *gohome.System) func(http
 	}
 }
 
-func apiSceneHandlerUpdate(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiSceneHandlerUpdate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sceneID := mux.Vars(r)["ID"]
 		scene := system.SceneByID(sceneID)
@@ -367,7 +322,7 @@ func apiSceneHandlerUpdate(savePath string, system *gohome.System) func(http.Res
 
 		system.AddScene(&updatedScene)
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveScene(system, &updatedScene)
 		if err != nil {
 			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
 			return
@@ -380,7 +335,7 @@ func apiSceneHandlerUpdate(savePath string, system *gohome.System) func(http.Res
 	}
 }
 
-func apiSceneHandlerCreate(savePath string, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+func apiSceneHandlerCreate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
 		if err != nil {
@@ -408,7 +363,7 @@ func apiSceneHandlerCreate(savePath string, system *gohome.System) func(http.Res
 		}
 		system.AddScene(newScene)
 
-		err = store.SaveSystem(savePath, system)
+		err = store.WithChange(sysStore, requestChange(r)).SaveScene(system, newScene)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -6,10 +6,13 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"math"
 	"mime"
+	"net"
 	"net/http"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,32 +21,43 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/markdaws/gohome/pkg/gohome"
 	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/store"
 	"github.com/urfave/negroni"
 )
 
 type Server struct {
-	rootPath       string
-	system         *gohome.System
-	systemSavePath string
-	sessions       *gohome.Sessions
-	cfg            *gohome.Config
+	rootPath string
+	system   *gohome.System
+	store    store.Store
+	sessions *gohome.Sessions
+	tokens   *gohome.Tokens
+	throttle *gohome.LoginThrottle
+	cfg      *gohome.Config
+	shutdown func()
 }
 
 // ListenAndServe creates a new WWW server, that handles API calls and also
-// runs the gohome website
+// runs the gohome website. shutdown is called when the server has to be
+// restarted, such as after a backup is restored
 func ListenAndServe(
 	rootPath string,
 	addr string,
 	system *gohome.System,
-	systemSavePath string,
+	sysStore store.Store,
 	sessions *gohome.Sessions,
-	cfg *gohome.Config) error {
+	tokens *gohome.Tokens,
+	throttle *gohome.LoginThrottle,
+	cfg *gohome.Config,
+	shutdown func()) error {
 	server := &Server{
-		rootPath:       rootPath,
-		system:         system,
-		systemSavePath: systemSavePath,
-		sessions:       sessions,
-		cfg:            cfg,
+		rootPath: rootPath,
+		system:   system,
+		store:    sysStore,
+		sessions: sessions,
+		tokens:   tokens,
+		throttle: throttle,
+		cfg:      cfg,
+		shutdown: shutdown,
 	}
 	return server.listenAndServe(addr)
 }
@@ -182,55 +196,114 @@ func (s *Server) listenAndServe(addr string) error {
 	sub.HandleFunc("/images/{filename}", cacheHandler("", false, distPath))
 	sub.HandleFunc("/images/{timestamp}/{filename}", cacheHandler("/images/", false, distPath))
 
-	r.HandleFunc("/api/v1/users/{login}/sessions", apiNewSessionHandler(s.system, s.sessions)).Methods("POST")
-	r.HandleFunc("/logout", logoutHandler(s.system, s.rootPath))
-	r.HandleFunc("/config", configHandler(s.cfg, s.sessions))
-	r.HandleFunc("/system", systemHandler(s.cfg, s.sessions))
+	r.HandleFunc("/api/v1/users/{login}/sessions", apiNewSessionHandler(s.system, s.store, s.sessions, s.throttle, newLoginChallenges())).Methods("POST")
+	r.HandleFunc("/logout", logoutHandler(s.system, s.sessions, s.rootPath))
+	r.HandleFunc("/config", configHandler(s.cfg, s.system, s.sessions))
+	r.HandleFunc("/system", systemHandler(s.cfg, s.system, s.sessions))
 
 	apiRouter := mux.NewRouter().PathPrefix("/api").Subrouter().StrictSlash(true)
 	RegisterSceneHandlers(apiRouter, s)
 	RegisterDeviceHandlers(apiRouter, s)
+	RegisterFeatureHandlers(apiRouter, s)
+	RegisterAreaHandlers(apiRouter, s)
+	RegisterUserHandlers(apiRouter, s)
+	RegisterSessionHandlers(apiRouter, s)
+	RegisterTokenHandlers(apiRouter, s)
+	RegisterTOTPHandlers(apiRouter, s)
 	RegisterDiscoveryHandlers(apiRouter, s)
 	RegisterMonitorHandlers(apiRouter, s)
 	RegisterAutomationHandlers(apiRouter, s)
+	RegisterBackupHandlers(apiRouter, s)
+	RegisterHistoryHandlers(apiRouter, s)
+	RegisterAuditHandlers(apiRouter, s)
 
 	r.PathPrefix("/api").Handler(negroni.New(
-		negroni.HandlerFunc(CheckValidSession(s.sessions)),
+		negroni.HandlerFunc(CheckValidSession(s.sessions, s.tokens, s.system, s.cfg.RequireAdminTOTP)),
+		negroni.HandlerFunc(CheckPermissions(apiRouter, s.system)),
 		negroni.Wrap(apiRouter),
 	))
 
 	r.HandleFunc("/", rootHandler(s.rootPath))
 
+	var handler http.Handler = negroni.New(
+		negroni.HandlerFunc(CheckCSRF(s.cfg.WWWAllowedOrigins)),
+		negroni.Wrap(r),
+	)
+
+	// By default only the web UI, on the same origin, can call the API. Other web pages, such as a
+	// dashboard served from a different address, have to be allowed in the config
+	if len(s.cfg.WWWAllowedOrigins) > 0 {
+		handler = handlers.CORS(
+			handlers.AllowedMethods([]string{"PUT", "POST", "DELETE", "GET", "OPTIONS", "UPGRADE"}),
+			handlers.AllowedOrigins(s.cfg.WWWAllowedOrigins),
+			handlers.AllowedHeaders([]string{"content-type", "authorization", csrfHeader}),
+		)(handler)
+	}
+
 	server := &http.Server{
 		Addr:         addr,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
-		Handler: handlers.CORS(
-			handlers.AllowedMethods([]string{"PUT", "POST", "DELETE", "GET", "OPTIONS", "UPGRADE"}),
-			handlers.AllowedOrigins([]string{"*"}),
-			handlers.AllowedHeaders([]string{"content-type"}),
-		)(r),
+		Handler:      handler,
+	}
+	if s.cfg.WWWTLS() {
+		return server.ListenAndServeTLS(s.cfg.WWWCertPath, s.cfg.WWWKeyPath)
 	}
 	return server.ListenAndServe()
 }
 
+// ListenAndServeRedirect listens for HTTP requests on addr and redirects them to the same URL
+// using HTTPS on httpsPort
+func ListenAndServeRedirect(addr, httpsPort string) error {
+	server := &http.Server{
+		Addr:         addr,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+
+			u := *r.URL
+			u.Scheme = "https"
+			u.Host = net.JoinHostPort(host, httpsPort)
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		}),
+	}
+	return server.ListenAndServe()
+}
+
+// sessionCookie returns the cookie containing the session ID. Scripts can't read the cookie, so
+// an XSS bug can't steal the session, and it is only sent over HTTPS if the request used HTTPS
+func sessionCookie(r *http.Request, sid string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     "sid",
+		Value:    sid,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
 func rootHandler(rootPath string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, path.Join(rootPath, "index.html"))
 	}
 }
 
-func configHandler(cfg *gohome.Config, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+func configHandler(cfg *gohome.Config, system *gohome.System, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sid, err := r.Cookie("sid")
-		if err != nil {
+		user := cookieUser(r, sessions, system, cfg.RequireAdminTOTP)
+		if user == nil {
 			w.Write([]byte("Must be logged in to see this file"))
 			return
 		}
-
-		_, ok := sessions.Get(sid.Value)
-		if !ok {
-			w.Write([]byte("Must be logged in to see this file"))
+		if !user.HasRole(gohome.RoleAdmin) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be an admin to see this file"))
 			return
 		}
 
@@ -245,21 +318,29 @@ func configHandler(cfg *gohome.Config, sessions *gohome.Sessions) func(http.Resp
 	}
 }
 
-func systemHandler(cfg *gohome.Config, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+func systemHandler(cfg *gohome.Config, system *gohome.System, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sid, err := r.Cookie("sid")
-		if err != nil {
+		user := cookieUser(r, sessions, system, cfg.RequireAdminTOTP)
+		if user == nil {
 			w.Write([]byte("Must be logged in to see this file"))
 			return
 		}
+		if !user.HasRole(gohome.RoleAdmin) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be an admin to see this file"))
+			return
+		}
 
-		_, ok := sessions.Get(sid.Value)
-		if !ok {
-			w.Write([]byte("Must be logged in to see this file"))
+		// The system can be saved in different formats depending on the store, so the
+		// current system is always returned in the system file format
+		b, err := store.MarshalSystem(system)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		b, err := ioutil.ReadFile(cfg.SystemPath)
+		// Never send credentials or password hashes to the browser
+		b, err = store.RedactSystem(b)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -270,18 +351,42 @@ func systemHandler(cfg *gohome.Config, sessions *gohome.Sessions) func(http.Resp
 	}
 }
 
-func logoutHandler(sys *gohome.System, rootPath string) func(http.ResponseWriter, *http.Request) {
+func logoutHandler(sys *gohome.System, sessions *gohome.Sessions, rootPath string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// The session is revoked, not just the cookie cleared, so the session ID can't be reused
+		var login string
+		if sid, err := r.Cookie("sid"); err == nil {
+			if session, ok := sessions.Get(sid.Value); ok {
+				if user := sys.UserByID(session.UserID); user != nil {
+					login = sys.UserSnapshot(user).Login
+				}
+				sessions.Delete(session.ID)
+				if err := sessions.Save(); err != nil {
+					log.E("failed to save sessions: %s", err)
+				}
+			}
+		}
+
 		sys.Services.EvtBus.Enqueue(&gohome.UserLogoutEvt{
-			//TODO: Get the logout details
-			Login: "",
+			Login: login,
 		})
 
+		// The cookie is HttpOnly so the logout page can't clear it
+		http.SetCookie(w, sessionCookie(r, "", time.Unix(0, 0)))
+
 		http.ServeFile(w, r, rootPath+"/dist/logout.html")
 	}
 }
 
-func apiNewSessionHandler(sys *gohome.System, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+// apiNewSessionHandler logs a user in. Users with two factor authentication log in in two steps,
+// first with their password which returns a challenge, then with the challenge and a code from
+// their authenticator app or one of their recovery codes
+func apiNewSessionHandler(
+	sys *gohome.System,
+	sysStore store.Store,
+	sessions *gohome.Sessions,
+	throttle *gohome.LoginThrottle,
+	challenges *loginChallenges) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
 		if err != nil {
@@ -289,43 +394,106 @@ func apiNewSessionHandler(sys *gohome.System, sessions *gohome.Sessions) func(ht
 			return
 		}
 
-		var success = false
 		login := mux.Vars(r)["login"]
 
+		// Clients have to wait longer after each failed login, so passwords can't be guessed
+		if wait := throttle.Wait(login, r.RemoteAddr); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var success = false
+		var passwordVerified = false
 		defer func() {
+			// The user still has to enter their two factor code, the login hasn't finished
+			if passwordVerified && !success {
+				return
+			}
+
 			sys.Services.EvtBus.Enqueue(&gohome.UserLoginEvt{
 				Login:   login,
 				Success: success,
 			})
-		}()
 
-		var user *gohome.User
-		for _, u := range sys.Users() {
-			if strings.ToLower(u.Login) == strings.ToLower(login) {
-				user = u
-				break
+			if success {
+				throttle.Succeeded(login)
+			} else if lockedOut, until := throttle.Failed(login, r.RemoteAddr); lockedOut {
+				log.V("too many failed logins for %s from %s, locked out until %s", login, r.RemoteAddr, until)
+				sys.Services.EvtBus.Enqueue(&gohome.UserLockedOutEvt{
+					Login:      login,
+					RemoteAddr: r.RemoteAddr,
+					Until:      until,
+				})
 			}
-		}
+		}()
+
+		user := sys.UserByLogin(login)
 		if user == nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
+		// The user may be updated by another request while logging in, so fields are read from a
+		// snapshot
+		snapshot := sys.UserSnapshot(user)
+
 		var x struct {
-			Password string `json:"password"`
+			Password  string `json:"password"`
+			Challenge string `json:"challenge"`
+			Code      string `json:"code"`
 		}
 		if err = json.Unmarshal(body, &x); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		err = user.VerifyPassword(x.Password)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		if x.Challenge != "" {
+			if !snapshot.TOTPEnabled || !challenges.valid(x.Challenge, user.ID) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			ok, err := verifySecondFactor(sys, sysStore, challenges, user, x.Code, r)
+			if err != nil {
+				log.E("failed to verify the two factor code of %s: %s", snapshot.Login, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			challenges.remove(x.Challenge)
+		} else {
+			err = snapshot.VerifyPassword(x.Password)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if snapshot.TOTPEnabled {
+				passwordVerified = true
+				challenge, err := challenges.add(user.ID)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(struct {
+					TOTPRequired bool   `json:"totpRequired"`
+					Challenge    string `json:"challenge"`
+				}{
+					TOTPRequired: true,
+					Challenge:    challenge,
+				})
+				return
+			}
 		}
 
-		sid, err := sessions.Add()
+		sid, err := sessions.Add(user.ID, r.UserAgent(), r.RemoteAddr)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -337,14 +505,7 @@ func apiNewSessionHandler(sys *gohome.System, sessions *gohome.Sessions) func(ht
 			return
 		}
 
-		expiration := time.Now().Add(365 * 24 * time.Hour)
-		cookie := http.Cookie{
-			Name:    "sid",
-			Value:   sid,
-			Path:    "/",
-			Expires: expiration,
-		}
-		http.SetCookie(w, &cookie)
+		http.SetCookie(w, sessionCookie(r, sid, time.Now().Add(sessions.MaxAge)))
 
 		success = true
 
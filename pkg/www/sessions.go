@@ -0,0 +1,75 @@
+package www
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+)
+
+// RegisterSessionHandlers registers the REST API routes so users can see and revoke the clients
+// they are logged in on
+func RegisterSessionHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/sessions",
+		apiSessionsHandler(s.sessions)).Methods("GET")
+	r.HandleFunc("/v1/sessions/{id}",
+		apiSessionHandlerDelete(s.sessions)).Methods("DELETE")
+}
+
+// apiSessionsHandler returns the sessions of the user making the request, newest first
+func apiSessionsHandler(sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		current, _ := requestSession(r)
+
+		userSessions := sessions.UserSessions(user.ID)
+		out := make([]jsonSession, len(userSessions))
+		for i, session := range userSessions {
+			out[i] = jsonSession{
+				ID:         session.ID,
+				Created:    session.Created,
+				LastSeen:   session.LastSeen,
+				UserAgent:  session.UserAgent,
+				RemoteAddr: session.RemoteAddr,
+				Current:    session.ID == current.ID,
+			}
+		}
+		resp(apiResponse{Data: out}, w)
+	}
+}
+
+// apiSessionHandlerDelete revokes one of the sessions of the user making the request, the client
+// using the session will have to log in again
+func apiSessionHandlerDelete(sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Users can only see their own sessions, so sessions owned by other users are not found
+		ID := mux.Vars(r)["id"]
+		found := false
+		for _, session := range sessions.UserSessions(user.ID) {
+			if session.ID == ID {
+				found = sessions.Delete(ID)
+				break
+			}
+		}
+		if !found {
+			respBadRequest("invalid session ID", w)
+			return
+		}
+
+		if err := sessions.Save(); err != nil {
+			log.E("failed to save sessions: %s", err)
+		}
+		resp(apiResponse{Data: struct{}{}}, w)
+	}
+}
@@ -0,0 +1,67 @@
+package www
+
+import (
+	"github.com/markdaws/gohome/pkg/attr"
+	"github.com/markdaws/gohome/pkg/feature"
+	"github.com/markdaws/gohome/pkg/gohome"
+)
+
+// userTempUnit returns the unit the user wants temperatures displayed in, an empty string
+// means the values are returned in the units used by the hardware
+func userTempUnit(system *gohome.System, user *gohome.User) string {
+	if user == nil {
+		return ""
+	}
+	unit := system.UserPrefs(user).TempUnit
+	if !attr.IsTempUnit(unit) {
+		return ""
+	}
+	return unit
+}
+
+// attrsToTempUnit returns a copy of the attributes, where all temperature attributes have
+// been converted to the specified unit. If unit is empty the attributes are returned unmodified
+func attrsToTempUnit(attrs map[string]*attr.Attribute, unit string) map[string]*attr.Attribute {
+	if unit == "" {
+		return attrs
+	}
+
+	out := make(map[string]*attr.Attribute)
+	for localID, attribute := range attrs {
+		out[localID] = attribute.ToTempUnit(unit)
+	}
+	return out
+}
+
+// featuresToTempUnit returns copies of the features, with all temperature attributes converted
+// to the specified unit. If unit is empty the features are returned unmodified
+func featuresToTempUnit(features []*feature.Feature, unit string) []*feature.Feature {
+	if unit == "" {
+		return features
+	}
+
+	out := make([]*feature.Feature, len(features))
+	for i, f := range features {
+		fCopy := *f
+		fCopy.Attrs = attrsToTempUnit(f.Attrs, unit)
+		out[i] = &fCopy
+	}
+	return out
+}
+
+// setDefaultTempUnit sets the unit on any temperature attributes passed in by the caller which
+// don't specify a unit, so they are assumed to be in the unit the user has chosen to display
+// temperatures in
+func setDefaultTempUnit(attrs map[string]*attr.Attribute, f *feature.Feature, unit string) {
+	if unit == "" {
+		return
+	}
+
+	for localID, attribute := range attrs {
+		featureAttr, ok := f.Attrs[localID]
+		if !ok || attribute == nil || attribute.Unit != "" || !attr.IsTempUnit(featureAttr.Unit) {
+			continue
+		}
+		attribute.Unit = unit
+	}
+}
@@ -0,0 +1,139 @@
+package www
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/validation"
+)
+
+// RegisterTokenHandlers registers the REST API routes so users can create and revoke the API
+// tokens their scripts and integrations use
+func RegisterTokenHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/tokens",
+		apiTokensHandler(s.tokens)).Methods("GET")
+	r.HandleFunc("/v1/tokens",
+		apiTokensHandlerCreate(s.system, s.tokens)).Methods("POST")
+	r.HandleFunc("/v1/tokens/{id}",
+		apiTokenHandlerDelete(s.tokens)).Methods("DELETE")
+}
+
+// apiTokensHandler returns the API tokens of the user making the request, newest first. The token
+// secrets are not returned, they are only available when a token is created
+func apiTokensHandler(tokens *gohome.Tokens) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		current, _ := requestToken(r)
+
+		userTokens := tokens.UserTokens(user.ID)
+		out := make([]jsonToken, len(userTokens))
+		for i, token := range userTokens {
+			out[i] = jsonToken{
+				Token:   token,
+				Current: token.ID == current.ID,
+			}
+		}
+		resp(apiResponse{Data: out}, w)
+	}
+}
+
+// apiTokensHandlerCreate creates a new API token for the user making the request. The response
+// contains the token secret, which is the only time it is available
+func apiTokensHandlerCreate(system *gohome.System, tokens *gohome.Tokens) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		if err != nil {
+			respBadRequest("unable to read request body", w)
+			return
+		}
+
+		var x struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		}
+		if err = json.Unmarshal(body, &x); err != nil {
+			respBadRequest("unable to parse request body, invalid JSON", w)
+			return
+		}
+
+		// A token can only create tokens with the same or less access than itself. An admin who
+		// is limited to member until they enrol in two factor authentication gets a member token,
+		// not one with their full role
+		if x.Role == "" {
+			if current, ok := requestToken(r); ok {
+				x.Role = current.Role
+			}
+			if access := requestAccess(r); x.Role == "" && access.Role != system.UserSnapshot(user).Role {
+				x.Role = access.Role
+			}
+		}
+
+		secret, token, err := tokens.Add(requestAccess(r), x.Name, x.Role)
+		if err != nil {
+			if valErrs, ok := err.(*validation.Errors); ok {
+				respValErr(&x, "", valErrs, w)
+				return
+			}
+			respErr(err, w)
+			return
+		}
+
+		if err := tokens.Save(); err != nil {
+			log.E("failed to save tokens: %s", err)
+		}
+
+		resp(apiResponse{Data: struct {
+			jsonToken
+			Secret string `json:"token"`
+		}{
+			jsonToken: jsonToken{Token: token},
+			Secret:    secret,
+		}}, w)
+	}
+}
+
+// apiTokenHandlerDelete revokes one of the API tokens of the user making the request, any script
+// using the token will no longer be able to call the API
+func apiTokenHandlerDelete(tokens *gohome.Tokens) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Users can only see their own tokens, so tokens owned by other users are not found
+		ID := mux.Vars(r)["id"]
+		found := false
+		for _, token := range tokens.UserTokens(user.ID) {
+			if token.ID == ID {
+				found = tokens.Delete(ID)
+				break
+			}
+		}
+		if !found {
+			respBadRequest("invalid token ID", w)
+			return
+		}
+
+		if err := tokens.Save(); err != nil {
+			log.E("failed to save tokens: %s", err)
+		}
+		resp(apiResponse{Data: struct{}{}}, w)
+	}
+}
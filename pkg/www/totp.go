@@ -0,0 +1,371 @@
+package www
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/store"
+	errExt "github.com/pkg/errors"
+)
+
+// loginChallengeTimeout is how long a user has to enter their two factor code after entering
+// their password
+const loginChallengeTimeout = 5 * time.Minute
+
+// loginChallenge is a login waiting for the user to enter their two factor code
+type loginChallenge struct {
+	userID  string
+	expires time.Time
+}
+
+// loginChallenges tracks the users who have entered their password and need to enter a two
+// factor code to finish logging in. It also remembers the last code each user logged in with,
+// so a code can't be used twice
+type loginChallenges struct {
+	mutex        sync.Mutex
+	challenges   map[string]loginChallenge
+	lastCounters map[string]int64
+}
+
+func newLoginChallenges() *loginChallenges {
+	return &loginChallenges{
+		challenges:   make(map[string]loginChallenge),
+		lastCounters: make(map[string]int64),
+	}
+}
+
+// add returns a new challenge for the user, the client sends it back with the two factor code
+func (c *loginChallenges) add(userID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	challenge := base64.URLEncoding.EncodeToString(b)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for key, ch := range c.challenges {
+		if now.After(ch.expires) {
+			delete(c.challenges, key)
+		}
+	}
+	c.challenges[challenge] = loginChallenge{userID: userID, expires: now.Add(loginChallengeTimeout)}
+	return challenge, nil
+}
+
+// valid returns true if the challenge belongs to the user and has not expired
+func (c *loginChallenges) valid(challenge, userID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ch, ok := c.challenges[challenge]
+	return ok && ch.userID == userID && time.Now().Before(ch.expires)
+}
+
+// remove removes the challenge once the user has logged in
+func (c *loginChallenges) remove(challenge string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.challenges, challenge)
+}
+
+// useCounter records the time counter of a TOTP code the user logged in with, it returns false
+// if the user already used this or a later code
+func (c *loginChallenges) useCounter(userID string, counter int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if last, ok := c.lastCounters[userID]; ok && counter <= last {
+		return false
+	}
+	c.lastCounters[userID] = counter
+	return true
+}
+
+var (
+	errTOTPEnabled    = errors.New("two factor authentication is already enabled")
+	errTOTPNotEnabled = errors.New("two factor authentication is not enabled")
+	errTOTPCode       = errors.New("invalid code")
+)
+
+// verifySecondFactor checks the code the user entered when logging in, it can be a TOTP code or
+// one of their recovery codes. A used recovery code is removed from the user and saved
+func verifySecondFactor(sys *gohome.System, sysStore store.Store, challenges *loginChallenges, user *gohome.User, code string, r *http.Request) (bool, error) {
+	if counter, ok := sys.UserSnapshot(user).VerifyTOTP(code, time.Now()); ok {
+		return challenges.useCounter(user.ID, counter), nil
+	}
+
+	if !sys.UseRecoveryCode(user, code) {
+		return false, nil
+	}
+	c := requestChange(r)
+	c.User = user.Login
+	if err := store.WithChange(sysStore, c).SaveUser(sys, user); err != nil {
+		return false, errExt.Wrap(err, "failed to save the used recovery code")
+	}
+	return true, nil
+}
+
+// RegisterTOTPHandlers registers the REST API routes users enrol in two factor authentication with
+func RegisterTOTPHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/users/me/totp",
+		apiTOTPHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/users/me/totp",
+		apiTOTPHandlerEnrol(s.store, s.system)).Methods("POST")
+	r.HandleFunc("/v1/users/me/totp/verify",
+		apiTOTPHandlerVerify(s.store, s.system)).Methods("POST")
+	r.HandleFunc("/v1/users/me/totp/recovery-codes",
+		apiTOTPHandlerRecoveryCodes(s.store, s.system)).Methods("POST")
+	r.HandleFunc("/v1/users/me/totp",
+		apiTOTPHandlerDisable(s.store, s.system)).Methods("DELETE")
+}
+
+// totpUser returns the user making the request, two factor authentication can only be changed
+// from a logged in session, not with an API token
+func totpUser(w http.ResponseWriter, r *http.Request) *gohome.User {
+	user := requestUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	if _, ok := requestToken(r); ok {
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	return user
+}
+
+// readTOTPBody reads the code and password fields from the request body
+func readTOTPBody(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		respBadRequest("unable to read request body", w)
+		return "", "", false
+	}
+
+	var x struct {
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	if err = json.Unmarshal(body, &x); err != nil {
+		respBadRequest("unable to parse request body, invalid JSON", w)
+		return "", "", false
+	}
+	return x.Code, x.Password, true
+}
+
+// apiTOTPHandler returns whether the user making the request has two factor authentication enabled
+func apiTOTPHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := totpUser(w, r)
+		if user == nil {
+			return
+		}
+		user = system.UserSnapshot(user)
+
+		resp(apiResponse{Data: struct {
+			Enabled           bool `json:"enabled"`
+			RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+		}{
+			Enabled:           user.TOTPEnabled,
+			RecoveryCodesLeft: len(user.RecoveryCodes),
+		}}, w)
+	}
+}
+
+// apiTOTPHandlerEnrol starts enrolling the user making the request in two factor authentication.
+// It returns a new secret and the otpauth URI to show as a QR code, two factor authentication is
+// not enabled until the user verifies a code from their authenticator app
+func apiTOTPHandlerEnrol(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := totpUser(w, r)
+		if user == nil {
+			return
+		}
+
+		secret, err := gohome.GenerateTOTPSecret()
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to generate TOTP secret"), w)
+			return
+		}
+		var uri string
+		err = system.UpdateUser(user, func(u *gohome.User) error {
+			if u.TOTPEnabled {
+				return errTOTPEnabled
+			}
+			u.TOTPSecret = secret
+			uri = u.TOTPURI()
+			return nil
+		})
+		if err == errTOTPEnabled {
+			respBadRequest("two factor authentication is already enabled, disable it before enrolling again", w)
+			return
+		}
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		}{
+			Secret: secret,
+			URI:    uri,
+		}}, w)
+	}
+}
+
+// apiTOTPHandlerVerify finishes enrolling the user making the request, once they have entered a
+// valid code from their authenticator app two factor authentication is enabled. The response
+// contains the recovery codes, which is the only time they are available
+func apiTOTPHandlerVerify(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := totpUser(w, r)
+		if user == nil {
+			return
+		}
+
+		code, _, ok := readTOTPBody(w, r)
+		if !ok {
+			return
+		}
+
+		var codes []string
+		err := system.UpdateUser(user, func(u *gohome.User) error {
+			if u.TOTPEnabled {
+				return errTOTPEnabled
+			}
+			if _, ok := u.VerifyTOTP(code, time.Now()); !ok {
+				return errTOTPCode
+			}
+
+			var err error
+			codes, err = u.GenerateRecoveryCodes()
+			if err != nil {
+				return errExt.Wrap(err, "failed to generate recovery codes")
+			}
+			u.TOTPEnabled = true
+			return nil
+		})
+		if err == errTOTPEnabled || err == errTOTPCode {
+			respBadRequest(err.Error(), w)
+			return
+		}
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+		resp(apiResponse{Data: struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}{RecoveryCodes: codes}}, w)
+	}
+}
+
+// apiTOTPHandlerRecoveryCodes replaces the recovery codes of the user making the request, the
+// user must enter a code from their authenticator app
+func apiTOTPHandlerRecoveryCodes(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := totpUser(w, r)
+		if user == nil {
+			return
+		}
+
+		code, _, ok := readTOTPBody(w, r)
+		if !ok {
+			return
+		}
+
+		var codes []string
+		err := system.UpdateUser(user, func(u *gohome.User) error {
+			if !u.TOTPEnabled {
+				return errTOTPNotEnabled
+			}
+			if _, ok := u.VerifyTOTP(code, time.Now()); !ok {
+				return errTOTPCode
+			}
+
+			var err error
+			codes, err = u.GenerateRecoveryCodes()
+			if err != nil {
+				return errExt.Wrap(err, "failed to generate recovery codes")
+			}
+			return nil
+		})
+		if err == errTOTPNotEnabled || err == errTOTPCode {
+			respBadRequest(err.Error(), w)
+			return
+		}
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+		resp(apiResponse{Data: struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}{RecoveryCodes: codes}}, w)
+	}
+}
+
+// apiTOTPHandlerDisable turns off two factor authentication for the user making the request, the
+// user must enter their password
+func apiTOTPHandlerDisable(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := totpUser(w, r)
+		if user == nil {
+			return
+		}
+
+		_, password, ok := readTOTPBody(w, r)
+		if !ok {
+			return
+		}
+		if system.UserSnapshot(user).VerifyPassword(password) != nil {
+			respBadRequest("invalid password", w)
+			return
+		}
+
+		err := system.UpdateUser(user, func(u *gohome.User) error {
+			u.DisableTOTP()
+			return nil
+		})
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+		resp(apiResponse{Data: struct{}{}}, w)
+	}
+}
@@ -0,0 +1,457 @@
+package www
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/markdaws/gohome/pkg/gohome"
+	"github.com/markdaws/gohome/pkg/log"
+	"github.com/markdaws/gohome/pkg/store"
+	"github.com/markdaws/gohome/pkg/validation"
+	errExt "github.com/pkg/errors"
+)
+
+// RegisterUserHandlers registers all of the user specific API REST routes
+func RegisterUserHandlers(r *mux.Router, s *Server) {
+	r.HandleFunc("/v1/users/me/prefs",
+		apiUserPrefsHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/users/me/prefs",
+		apiUserPrefsHandlerUpdate(s.store, s.system)).Methods("PUT")
+	r.HandleFunc("/v1/users/me/password",
+		apiUserPasswordHandlerUpdate(s.store, s.system, s.sessions)).Methods("PUT")
+
+	r.HandleFunc("/v1/users",
+		apiUsersHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/users/{id}",
+		apiUserHandler(s.system)).Methods("GET")
+	r.HandleFunc("/v1/users",
+		apiUserHandlerCreate(s.store, s.system)).Methods("POST")
+	r.HandleFunc("/v1/users/{id}",
+		apiUserHandlerUpdate(s.store, s.system, s.sessions)).Methods("PUT")
+	r.HandleFunc("/v1/users/{id}",
+		apiUserHandlerDelete(s.store, s.system, s.sessions, s.tokens)).Methods("DELETE")
+}
+
+// UserToJSON converts the user to its JSON representation, passwords and two factor secrets are
+// never included
+func UserToJSON(u *gohome.User) jsonUser {
+	grants := make([]jsonGrant, len(u.Grants))
+	for i, grant := range u.Grants {
+		grants[i] = jsonGrant{Type: grant.Type, ID: grant.ID}
+	}
+	return jsonUser{
+		ID:          u.ID,
+		Login:       u.Login,
+		Role:        u.Role,
+		Grants:      grants,
+		TOTPEnabled: u.TOTPEnabled,
+	}
+}
+
+// jsonUserUpdate is the body of requests that create or update users, only the fields included
+// in the body are modified
+type jsonUserUpdate struct {
+	Login    *string      `json:"login"`
+	Password *string      `json:"password"`
+	Role     *string      `json:"role"`
+	Grants   *[]jsonGrant `json:"grants"`
+}
+
+// applyUserUpdate updates the user with the fields in the request body. The user is validated
+// before the password is set, if the updates are not valid the errors are returned and the user
+// may be partially updated, so callers should update a snapshot and then call setUserUpdate
+func applyUserUpdate(system *gohome.System, user *gohome.User, updates jsonUserUpdate) *validation.Errors {
+	if updates.Login != nil {
+		user.Login = *updates.Login
+	}
+	if updates.Role != nil {
+		user.Role = *updates.Role
+	}
+	if updates.Grants != nil {
+		user.Grants = make([]gohome.Grant, len(*updates.Grants))
+		for i, grant := range *updates.Grants {
+			user.Grants[i] = gohome.Grant{Type: grant.Type, ID: grant.ID}
+		}
+	}
+
+	if valErrs := user.Validate(system); valErrs != nil {
+		return valErrs
+	}
+
+	// Grants for items that have since been removed don't give access to anything, so they are only
+	// checked when the grants are changed, otherwise they would stop the user being updated
+	if updates.Grants != nil {
+		if valErrs := user.ValidateGrants(system); valErrs != nil {
+			return valErrs
+		}
+	}
+
+	if updates.Password != nil {
+		if err := user.SetPassword(*updates.Password); err != nil {
+			if valErrs, ok := err.(*validation.Errors); ok {
+				return valErrs
+			}
+			return validation.NewErrors("Password", err.Error(), false)
+		}
+	}
+	return nil
+}
+
+// setUserUpdate copies the fields in the request body from updated, which applyUserUpdate has
+// validated, to the user. Other fields may have been changed by another request since updated was
+// copied, so they are left alone
+func setUserUpdate(user, updated *gohome.User, updates jsonUserUpdate) {
+	if updates.Login != nil {
+		user.Login = updated.Login
+	}
+	if updates.Role != nil {
+		user.Role = updated.Role
+	}
+	if updates.Grants != nil {
+		user.Grants = updated.Grants
+	}
+	if updates.Password != nil {
+		user.HashedPwd = updated.HashedPwd
+		user.Salt = updated.Salt
+	}
+}
+
+// readUserUpdate reads the user fields from the request body
+func readUserUpdate(w http.ResponseWriter, r *http.Request) (jsonUserUpdate, bool) {
+	var updates jsonUserUpdate
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		respBadRequest("unable to read request body", w)
+		return updates, false
+	}
+	if err = json.Unmarshal(body, &updates); err != nil {
+		respBadRequest("unable to parse request body, invalid JSON", w)
+		return updates, false
+	}
+	return updates, true
+}
+
+// apiUsersHandler returns all of the users in the system, sorted by login
+func apiUsersHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := make(users, 0)
+		for _, u := range system.Users() {
+			out = append(out, UserToJSON(system.UserSnapshot(u)))
+		}
+		sort.Sort(out)
+		resp(apiResponse{Data: out}, w)
+	}
+}
+
+// apiUserHandler returns the user with the ID in the URL
+func apiUserHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		user := system.UserByID(ID)
+		if user == nil {
+			respBadRequest(fmt.Sprintf("invalid user ID: %s", ID), w)
+			return
+		}
+		resp(apiResponse{Data: UserToJSON(system.UserSnapshot(user))}, w)
+	}
+}
+
+// apiUserHandlerCreate adds a new user, login and password are required. If no role is specified
+// the user is a member
+func apiUserHandlerCreate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updates, ok := readUserUpdate(w, r)
+		if !ok {
+			return
+		}
+
+		user := &gohome.User{
+			ID:   system.NewID(),
+			Role: gohome.RoleMember,
+		}
+		if updates.Password == nil {
+			respValErr(&updates, user.ID, validation.NewErrors("Password", "required field", false), w)
+			return
+		}
+		if valErrs := applyUserUpdate(system, user, updates); valErrs != nil {
+			respValErr(&updates, user.ID, valErrs, w)
+			return
+		}
+
+		system.AddUser(user)
+		err := store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+		resp(apiResponse{Data: UserToJSON(user)}, w)
+	}
+}
+
+// apiUserHandlerUpdate renames a user, resets their password or changes their role and grants.
+// Resetting the password logs the user out everywhere, apart from the session making the request
+func apiUserHandlerUpdate(sysStore store.Store, system *gohome.System, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		user := system.UserByID(ID)
+		if user == nil {
+			respBadRequest(fmt.Sprintf("invalid user ID: %s", ID), w)
+			return
+		}
+
+		updates, ok := readUserUpdate(w, r)
+		if !ok {
+			return
+		}
+
+		updated := system.UserSnapshot(user)
+		if valErrs := applyUserUpdate(system, updated, updates); valErrs != nil {
+			respValErr(&updates, user.ID, valErrs, w)
+			return
+		}
+
+		err := system.UpdateUser(user, func(u *gohome.User) error {
+			setUserUpdate(u, updated, updates)
+			return nil
+		})
+		if err == gohome.ErrLastAdmin {
+			respBadRequest("the last admin can't be given a different role", w)
+			return
+		} else if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		if updates.Password != nil {
+			current, _ := requestSession(r)
+			if sessions.DeleteUserSessions(user.ID, current.ID) > 0 {
+				if err := sessions.Save(); err != nil {
+					log.E("failed to save sessions: %s", err)
+				}
+			}
+		}
+		resp(apiResponse{Data: UserToJSON(system.UserSnapshot(user))}, w)
+	}
+}
+
+// apiUserHandlerDelete deletes a user, the user is logged out everywhere and their API tokens
+// are revoked
+func apiUserHandlerDelete(sysStore store.Store, system *gohome.System, sessions *gohome.Sessions, tokens *gohome.Tokens) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		user := system.UserByID(ID)
+		if user == nil {
+			respBadRequest(fmt.Sprintf("invalid user ID: %s", ID), w)
+			return
+		}
+		if system.DeleteUser(user) == gohome.ErrLastAdmin {
+			respBadRequest("the last admin can't be deleted", w)
+			return
+		}
+
+		err := store.WithChange(sysStore, requestChange(r)).DeleteUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		if sessions.DeleteUserSessions(user.ID, "") > 0 {
+			if err := sessions.Save(); err != nil {
+				log.E("failed to save sessions: %s", err)
+			}
+		}
+		if tokens.DeleteUserTokens(user.ID) > 0 {
+			if err := tokens.Save(); err != nil {
+				log.E("failed to save tokens: %s", err)
+			}
+		}
+		resp(apiResponse{Data: struct{}{}}, w)
+	}
+}
+
+// apiUserPasswordHandlerUpdate changes the password of the user making the request, they must
+// enter their current password. The user is logged out of their other sessions
+func apiUserPasswordHandlerUpdate(sysStore store.Store, system *gohome.System, sessions *gohome.Sessions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Passwords can only be changed from a logged in session, not with an API token
+		current, ok := requestSession(r)
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		if err != nil {
+			respBadRequest("unable to read request body", w)
+			return
+		}
+
+		var x struct {
+			OldPassword string `json:"oldPassword"`
+			NewPassword string `json:"newPassword"`
+		}
+		if err = json.Unmarshal(body, &x); err != nil {
+			respBadRequest("unable to parse request body, invalid JSON", w)
+			return
+		}
+
+		updated := system.UserSnapshot(user)
+		if updated.VerifyPassword(x.OldPassword) != nil {
+			respValErr(&x, user.ID, validation.NewErrors("OldPassword", "invalid password", false), w)
+			return
+		}
+
+		if err := updated.SetPassword(x.NewPassword); err != nil {
+			if valErrs, ok := err.(*validation.Errors); ok {
+				respValErr(&x, user.ID, valErrs, w)
+				return
+			}
+			respErr(err, w)
+			return
+		}
+
+		err = system.UpdateUser(user, func(u *gohome.User) error {
+			u.HashedPwd = updated.HashedPwd
+			u.Salt = updated.Salt
+			return nil
+		})
+		if err != nil {
+			respErr(err, w)
+			return
+		}
+
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		if sessions.DeleteUserSessions(user.ID, current.ID) > 0 {
+			if err := sessions.Save(); err != nil {
+				log.E("failed to save sessions: %s", err)
+			}
+		}
+		resp(apiResponse{Data: struct{}{}}, w)
+	}
+}
+
+// UserPrefsToJSON converts the user preferences to their JSON representation
+func UserPrefsToJSON(prefs gohome.UserPrefs) jsonUserPrefs {
+	p := jsonUserPrefs{
+		TempUnit:       prefs.TempUnit,
+		HiddenFeatures: make([]string, 0, len(prefs.UI.HiddenFeatures)),
+		FeatureOrder:   prefs.UI.FeatureOrder,
+		FavoriteScenes: prefs.UI.FavoriteScenes,
+		LandingAreaID:  prefs.UI.LandingAreaID,
+	}
+	for featureID, hidden := range prefs.UI.HiddenFeatures {
+		if hidden {
+			p.HiddenFeatures = append(p.HiddenFeatures, featureID)
+		}
+	}
+	sort.Strings(p.HiddenFeatures)
+
+	if p.FeatureOrder == nil {
+		p.FeatureOrder = []string{}
+	}
+	if p.FavoriteScenes == nil {
+		p.FavoriteScenes = []string{}
+	}
+	return p
+}
+
+func apiUserPrefsHandler(system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		resp(apiResponse{Data: UserPrefsToJSON(system.UserPrefs(user))}, w)
+	}
+}
+
+// apiUserPrefsHandlerUpdate updates the preferences of the user making the request, only the
+// fields included in the body are modified
+func apiUserPrefsHandlerUpdate(sysStore store.Store, system *gohome.System) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+		if err != nil {
+			respBadRequest("unable to read request body", w)
+			return
+		}
+
+		var updates struct {
+			TempUnit       *string   `json:"tempUnit"`
+			HiddenFeatures *[]string `json:"hiddenFeatures"`
+			FeatureOrder   *[]string `json:"featureOrder"`
+			FavoriteScenes *[]string `json:"favoriteScenes"`
+			LandingAreaID  *string   `json:"landingAreaId"`
+		}
+		if err = json.Unmarshal(body, &updates); err != nil {
+			respBadRequest("unable to parse request body, invalid JSON", w)
+			return
+		}
+
+		// Features, scenes and areas may have been removed since the preferences were saved, those
+		// IDs are dropped so they don't stop the user changing their preferences
+		prefs := system.UserPrefs(user).Prune(system)
+		if updates.TempUnit != nil {
+			prefs.TempUnit = *updates.TempUnit
+		}
+		if updates.HiddenFeatures != nil {
+			prefs.UI.HiddenFeatures = make(map[string]bool)
+			for _, featureID := range *updates.HiddenFeatures {
+				prefs.UI.HiddenFeatures[featureID] = true
+			}
+		}
+		if updates.FeatureOrder != nil {
+			prefs.UI.FeatureOrder = *updates.FeatureOrder
+		}
+		if updates.FavoriteScenes != nil {
+			prefs.UI.FavoriteScenes = *updates.FavoriteScenes
+		}
+		if updates.LandingAreaID != nil {
+			prefs.UI.LandingAreaID = *updates.LandingAreaID
+		}
+
+		valErrs := prefs.Validate(system)
+		if valErrs != nil {
+			respValErr(&updates, user.ID, valErrs, w)
+			return
+		}
+
+		system.SetUserPrefs(user, prefs)
+		err = store.WithChange(sysStore, requestChange(r)).SaveUser(system, user)
+		if err != nil {
+			respErr(errExt.Wrap(err, "failed to save system to disk"), w)
+			return
+		}
+
+		resp(apiResponse{Data: UserPrefsToJSON(prefs)}, w)
+	}
+}
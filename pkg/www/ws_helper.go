@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,10 +16,15 @@ import (
 	"github.com/markdaws/gohome/pkg/log"
 )
 
-// Need check origin to allow cross-domain calls
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+// wsAuthCheckPeriod is how often web socket connections check that the session or API token that
+// opened them is still valid
+const wsAuthCheckPeriod = 10 * time.Second
 
 type WSHelper struct {
+	system      *gohome.System
+	upgrader    websocket.Upgrader
+	sessions    *gohome.Sessions
+	tokens      *gohome.Tokens
 	monitor     *gohome.Monitor
 	evtBus      *evtbus.Bus
 	nextID      int64
@@ -31,13 +37,24 @@ type WSHelper struct {
 type connection struct {
 	monitorID    string
 	connectionID string
+	owner        string
+	tempUnit     string
 	ws           *websocket.Conn
 	writeChan    chan bool
 	readChan     chan bool
 }
 
-func NewWSHelper(monitor *gohome.Monitor, evtBus *evtbus.Bus) *WSHelper {
+// NewWSHelper returns a new WSHelper. Web pages can only open a connection if they are on the same
+// origin as the server or are in allowedOrigins. Connections are closed once the session or API
+// token that opened them is no longer valid in sessions or tokens
+func NewWSHelper(system *gohome.System, monitor *gohome.Monitor, evtBus *evtbus.Bus, sessions *gohome.Sessions, tokens *gohome.Tokens, allowedOrigins []string) *WSHelper {
 	h := WSHelper{
+		system:   system,
+		sessions: sessions,
+		tokens:   tokens,
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+			return originAllowed(r, allowedOrigins)
+		}},
 		monitor:     monitor,
 		evtBus:      evtBus,
 		nextID:      time.Now().UnixNano(),
@@ -92,23 +109,28 @@ func (h *WSHelper) unregister(c *connection) {
 
 func (h *WSHelper) HTTPHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Check the monitorID, use has to first subscribe and get an ID
+		// before trying to stream the values. Only the session or token that
+		// subscribed can stream the values
+		monitorID := mux.Vars(r)["monitorID"]
+		owner := requestOwner(r)
+		group, ok := h.monitor.Group(monitorID)
+		if !ok || owner == "" || group.Owner != owner {
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		// Check the monitorID, use has to first subscribe and get an ID
-		// before trying to stream the values
-		monitorID := mux.Vars(r)["monitorID"]
-		if _, ok := h.monitor.Group(monitorID); !ok {
-			c.Close()
+		c, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		conn := &connection{
 			connectionID: strconv.FormatInt(h.nextID, 10),
 			monitorID:    monitorID,
+			owner:        owner,
+			tempUnit:     userTempUnit(h.system, requestUser(r)),
 			ws:           c,
 			writeChan:    make(chan bool),
 			readChan:     make(chan bool),
@@ -155,24 +177,33 @@ func (h *WSHelper) processUpdates() {
 			}
 			h.mutex.RUnlock()
 
-			evt := jsonMonitorGroupResponse{
-				Features: make(map[string]map[string]*attr.Attribute),
-			}
-			for featureID, attrs := range update.Features {
-				evt.Features[featureID] = attrs
-			}
-
-			bytes, err := json.Marshal(evt)
-			if err != nil {
-				log.E("failed to marshal change batch to JSON for update: %s", err)
-				continue
-			}
+			// Each connection may want temperatures in a different unit, only marshal
+			// the update once for each unit
+			unitBytes := make(map[string][]byte)
 
 			// Serial, if we ever get a lot of conncurrent users, would want to push
 			// these in parallel
 			for _, conn := range connList {
+				bytes, ok := unitBytes[conn.tempUnit]
+				if !ok {
+					evt := jsonMonitorGroupResponse{
+						Features: make(map[string]map[string]*attr.Attribute),
+					}
+					for featureID, attrs := range update.Features {
+						evt.Features[featureID] = attrsToTempUnit(attrs, conn.tempUnit)
+					}
+
+					var err error
+					bytes, err = json.Marshal(evt)
+					if err != nil {
+						log.E("failed to marshal change batch to JSON for update: %s", err)
+						continue
+					}
+					unitBytes[conn.tempUnit] = bytes
+				}
+
 				conn.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				err = conn.ws.WriteMessage(websocket.TextMessage, bytes)
+				err := conn.ws.WriteMessage(websocket.TextMessage, bytes)
 				if err != nil {
 					h.unregister(conn)
 				}
@@ -217,10 +248,23 @@ func (h *WSHelper) Expired(monitorID string) {
 
 // =========================================================
 
+// ownerValid returns true if the session or API token identified by owner is still valid
+func (h *WSHelper) ownerValid(owner string) bool {
+	switch {
+	case strings.HasPrefix(owner, "session:"):
+		return h.sessions.Valid(strings.TrimPrefix(owner, "session:"))
+	case strings.HasPrefix(owner, "token:"):
+		return h.tokens.Valid(strings.TrimPrefix(owner, "token:"))
+	}
+	return false
+}
+
 func (c *connection) writeLoop(l *WSHelper) {
 	ticker := time.NewTicker(50 * time.Second)
+	authTicker := time.NewTicker(wsAuthCheckPeriod)
 	defer func() {
 		ticker.Stop()
+		authTicker.Stop()
 	}()
 
 	var exit = false
@@ -237,6 +281,15 @@ func (c *connection) writeLoop(l *WSHelper) {
 				l.unregister(c)
 				exit = true
 			}
+		case <-authTicker.C:
+			// The session may have been revoked or expired, the monitor group can't be
+			// used by anyone else so it is removed too
+			if !l.ownerValid(c.owner) {
+				log.V("WSHelper - session or token no longer valid, closing connection, monitorID: %s", c.monitorID)
+				l.unregister(c)
+				l.monitor.Unsubscribe(c.monitorID)
+				exit = true
+			}
 		}
 
 		if exit {